@@ -1,34 +1,11 @@
 package main
 
-import (
-	"context"
-	"flag"
-	"net/http"
+import "hostmanager/pkg/agent"
 
-	"github.com/rancher/remotedialer"
-	"github.com/sirupsen/logrus"
-)
-
-var (
-	addr  string
-	id    string
-	debug bool
-)
+// version is sent to hostmanager on connect, set it with -ldflags "-X main.version=..."
+var version = "dev"
 
+// the client of catalogmanager is the hostmanager agent, with the same flags
 func main() {
-	flag.StringVar(&addr, "connect", "ws://localhost:8123/connect", "Address to connect to")
-	flag.StringVar(&id, "id", "foo", "Client ID")
-	flag.BoolVar(&debug, "debug", true, "Debug logging")
-	flag.Parse()
-
-	if debug {
-		logrus.SetLevel(logrus.DebugLevel)
-		remotedialer.PrintTunnelData = true
-	}
-
-	headers := http.Header{
-		"X-Tunnel-ID": []string{id},
-	}
-
-	remotedialer.ClientConnect(context.Background(), addr, headers, nil, func(string, string) bool { return true }, nil)
+	agent.Main(version)
 }
@@ -6,7 +6,12 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/rancher/remotedialer v0.2.5
 	github.com/sirupsen/logrus v1.4.2
+	hostmanager v0.0.0
+	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
 	k8s.io/klog v1.0.0
 )
+
+// the agent library lives in the hostmanager module
+replace hostmanager => ../
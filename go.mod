@@ -4,8 +4,13 @@ go 1.14
 
 require (
 	github.com/gorilla/mux v1.7.3
+	github.com/gorilla/websocket v1.4.0
+	github.com/prometheus/client_golang v1.4.0
 	github.com/rancher/remotedialer v0.2.5
 	github.com/sirupsen/logrus v1.4.2
+	golang.org/x/net v0.0.0-20191004110552-13f9640d40b9
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
 	k8s.io/klog v1.0.0
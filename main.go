@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	controller "hostmanager/pkg"
-	"io"
+	"hostmanager/pkg/api"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/breaker"
+	"hostmanager/pkg/connectivitycheck"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/dns"
+	"hostmanager/pkg/forward"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
-	"strconv"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,17 +26,69 @@ import (
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rancher/remotedialer"
 	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/ingress"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/proxy"
+	"hostmanager/pkg/session"
 	"hostmanager/pkg/signals"
+	"hostmanager/pkg/tunnelroute"
+	"hostmanager/pkg/tunnelservice"
 	"net/http"
 )
 
 var (
-	serverURL string
-	debug     bool
+	serverURL     string
+	adminURL      string
+	debug         bool
+	kubeconfig    string
+	discoveryType string
+	peersFile     string
+	dnsName       string
+	endpointsName string
+	peerToken     string
+	fanout        int
+	drainTimeout  time.Duration
+	pools         poolFlags
+	poolBalance   string
+	exposePorts   string
+	servicePorts  string
+	ingressClass  string
+	ingressURL    string
+	vhostSuffix   string
+	tunnelRoutes  bool
+	checks        bool
+	dnsURL        string
+	dnsZone       string
+	udpTimeout    time.Duration
+	clusterProxy  bool
+	clusterOwners string
+	clusterCIDRs  string
+	userHeader    string
+	groupHeader   string
+	clientLimits  string
+	callerLimits  string
+	timeouts      proxy.Timeouts
+	maxTimeout    time.Duration
+	breakers      bool
+	breakerOpts   breaker.Options
+	probeOpts     session.ProbeOptions
 )
 
+// poolFlags are the repeated -pool name:selector flags
+type poolFlags []string
+
+func (f *poolFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *poolFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
 
 	if debug {
@@ -39,87 +104,406 @@ func main() {
 
 	handler := remotedialer.New(authorizer, remotedialer.DefaultErrorWriter)
 
+	// the crd discovery and the TunnelListener crd share the host clientset
+	var hostClient hostclientset.Interface
+	if discoveryType == discovery.CRD {
+		hostClient = newHostClient()
+	}
+
 	//得到controller
-	controller := controller.NewController(stopCh, wg, handler, serverURL)
+	disc := newDiscovery(hostClient)
+	controller := controller.NewController(stopCh, wg, handler, serverURL, disc, peerToken)
+	directory, _ := disc.(discovery.Directory)
+	if fanout > 0 && directory == nil {
+		klog.Warningf("%s discovery has no client directory, fanout %d ignored", discoveryType, fanout)
+	} else {
+		controller.Fanout = fanout
+	}
 
 	//controller开始处理消息
 	if err := controller.Run(2, stopCh); err != nil {
 		klog.Fatalf("Error running controller: %s", err.Error())
 	}
 
-	//handler.PeerToken = peerToken
-	//handler.PeerID = peerID
-	//
-	//for _, peer := range strings.Split(peers, ",") {
-	//	parts := strings.SplitN(strings.TrimSpace(peer), ":", 3)
-	//	if len(parts) != 3 {
-	//		continue
-	//	}
-	//	handler.AddPeer(parts[2], parts[0], parts[1])
-	//}
-
-	router := mux.NewRouter()
-	router.Handle("/connect", handler)
-	router.HandleFunc("/client/{id}/{scheme}/{host}{path:.*}", func(rw http.ResponseWriter, req *http.Request) {
-		Client(handler, rw, req)
-	})
-
-	fmt.Println("Listening on ", serverURL)
-	http.ListenAndServe(serverURL, router)
+	limits, err := limit.ParseLimits(clientLimits)
+	if err != nil {
+		klog.Fatalf("invalid clientlimits: %s", err.Error())
+	}
+	perCaller, err := limit.ParseLimits(callerLimits)
+	if err != nil {
+		klog.Fatalf("invalid callerlimits: %s", err.Error())
+	}
+	limiter := limit.NewLimiter(limits, perCaller)
+
+	registry := session.NewRegistry(handler, authorizer, controller)
+	limiter.Declared = registry.Limits
+	registry.Usage = limiter.Status
+	if clusterOwners != "" {
+		if registry.ClusterClients, err = parseBindings(clusterOwners); err != nil {
+			klog.Fatalf("invalid clusterclients: %s", err.Error())
+		}
+	}
+	if probeOpts.Interval > 0 {
+		if probeOpts.Timeout <= 0 || probeOpts.Timeout >= probeOpts.Interval || probeOpts.Failures <= 0 {
+			klog.Fatalf("invalid probe options, probetimeout must be below probeinterval and probefailures positive")
+		}
+		registry.Probe(probeOpts, stopCh)
+	}
+	if directory != nil {
+		// the rules of the clients connected elsewhere, which may be dialed through a peer
+		registry.Remote = func(clientID string) []hostv1.ClientSession {
+			return remoteSessions(directory, controller.LocalPeer().ID, clientID)
+		}
+		// a cluster id registered elsewhere by another client is refused
+		registry.RemoteClusters = func(id string) []hostv1.ClientSession {
+			return remoteClusters(directory, controller.LocalPeer().ID, id)
+		}
+		registry.Run(func(sessions []hostv1.ClientSession) error {
+			return directory.PublishSessions(controller.LocalPeer(), sessions)
+		}, stopCh)
+	}
+	forwards := forward.NewManager(handler)
+	if udpTimeout <= 0 {
+		klog.Fatalf("invalid udptimeout %s", udpTimeout)
+	}
+	forwards.UDPTimeout = udpTimeout
+	forwards.Allows = registry.Allows
+	forwards.UDPRelay = registry.UDPRelay
+	forwards.Limiter = limiter
+	ports, err := forward.ParsePortRange(exposePorts)
+	if err != nil {
+		klog.Fatalf("invalid exposeports: %s", err.Error())
+	}
+	forward.WatchAgents(forwards, registry, ports, stopCh)
+	if hostClient != nil {
+		forward.WatchCRD(forwards, hostClient, controller.LocalPeer().ID, stopCh)
+	}
+	if hostClient != nil && servicePorts != "" {
+		ports, err := forward.ParsePortRange(servicePorts)
+		if err != nil {
+			klog.Fatalf("invalid serviceports: %s", err.Error())
+		}
+		services := tunnelservice.NewController(newKubeClient(), hostClient, forwards, controller.Hosts, controller.LocalPeer().ID, ports)
+		if err := services.Run(2, stopCh); err != nil {
+			klog.Fatalf("Error running tunnel service controller: %s", err.Error())
+		}
+	}
+
+	clientProxy := proxy.New(handler, registry, directory, newPools())
+
+	// the escaped unix socket path of /client/{id}/http+unix/{host}{path} stays in {host}
+	router := mux.NewRouter().UseEncodedPath()
+	clientProxy.Limiter = limiter
+	// the callers of the requests forwarded by the peers count against the limits here
+	clientProxy.Authenticate = controller.Authenticate
+	clientProxy.Timeouts, clientProxy.MaxTimeout = timeouts, maxTimeout
+	if breakers {
+		if breakerOpts.Window <= 0 || breakerOpts.MinRequests <= 0 || breakerOpts.ErrorRate <= 0 || breakerOpts.ErrorRate > 1 || breakerOpts.OpenTimeout <= 0 {
+			klog.Fatalf("invalid breaker options %+v", breakerOpts)
+		}
+		clientProxy.Breakers = breaker.NewBreakers(breakerOpts)
+	}
+	router.Handle("/connect", controller.PeerHandler(registry))
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/client/{id}/{scheme}/{host}{path:.*}", clientProxy.Client)
+	router.HandleFunc("/select/{scheme}/{host}{path:.*}", clientProxy.Select)
+	router.HandleFunc("/pool/{name}/{scheme}/{host}{path:.*}", clientProxy.Pool)
+	if clusterProxy {
+		clientProxy.UserHeader, clientProxy.GroupHeader = userHeader, groupHeader
+		// the impersonated caller is only trusted from the authenticating proxies
+		if clientProxy.ClusterCallers, err = parseCIDRs(clusterCIDRs); err != nil || len(clientProxy.ClusterCallers) == 0 {
+			klog.Fatalf("clusterproxy needs the networks of the authenticating proxies in -clusterproxycidr, got %q", clusterCIDRs)
+		}
+		router.PathPrefix(proxy.CLUSTER_PREFIX + "{id}").HandlerFunc(clientProxy.Cluster)
+	}
+	hostAPI := api.New(handler, registry, directory, controller)
+	hostAPI.Breakers = clientProxy.Breakers
+	hostAPI.Register(router)
+	var ingresses *ingress.Controller
+	if ingressClass != "" {
+		ingresses = ingress.NewController(newKubeClient(), clientProxy, ingressClass)
+		if err := ingresses.Run(stopCh); err != nil {
+			klog.Fatalf("Error running ingress controller: %s", err.Error())
+		}
+		// the other hosts send the ingress requests for the clients connected here
+		router.PathPrefix(ingress.PREFIX + "/").Handler(http.StripPrefix(ingress.PREFIX, ingresses))
+	}
+	// TunnelRoutes serve the requests no route above matches
+	router.NotFoundHandler = http.HandlerFunc(clientProxy.Route)
+	if hostClient != nil && tunnelRoutes {
+		routes := tunnelroute.NewController(hostClient, clientProxy, controller.Hosts, controller.LocalPeer().ID)
+		if err := routes.Run(stopCh); err != nil {
+			klog.Fatalf("Error running tunnel route controller: %s", err.Error())
+		}
+	}
+	if hostClient != nil && checks {
+		connectivity := connectivitycheck.NewController(newKubeClient(), hostClient, handler, registry, controller.LocalPeer().ID)
+		if err := connectivity.Run(stopCh); err != nil {
+			klog.Fatalf("Error running connectivity check controller: %s", err.Error())
+		}
+	}
+
+	if dnsURL != "" {
+		ip, _, _ := net.SplitHostPort(controller.LocalPeer().ID)
+		nameserver := dns.NewServer(dnsZone, net.ParseIP(ip), dns.NewRecords(clientProxy, forwards))
+		go func() {
+			fmt.Println("DNS listening on ", dnsURL)
+			if err := nameserver.ListenAndServe(dnsURL); err != nil {
+				klog.Fatalf("Error serving dns %s: %s", dnsURL, err.Error())
+			}
+		}()
+	}
+
+	var root http.Handler = router
+	if vhostSuffix != "" {
+		root = clientProxy.VirtualHost(vhostSuffix, router)
+	}
+	servers := []*http.Server{serve(serverURL, root)}
+	if adminURL != "" {
+		// the admin api changes this hostmanager, it is not served on the public port
+		adminRouter := mux.NewRouter()
+		hostAPI.RegisterAdmin(adminRouter)
+		servers = append(servers, serve(adminURL, adminRouter))
+	}
+	if ingresses != nil {
+		servers = append(servers, serve(ingressURL, ingresses))
+	}
+
+	<-stopCh
+	shutdown(controller, registry, forwards, servers)
 	wg.Wait()
 	klog.Infof("main end")
 }
 
-func authorizer(req *http.Request) (string, bool, error) {
-	id := req.Header.Get("x-tunnel-id")
-	return id, id != "", nil
-}
+// shutdown drains this hostmanager: the Host is marked Draining and new agent sessions are refused,
+// in-flight requests get -draintimeout to finish, then the agents are disconnected so they reconnect
+// to another hostmanager, and the Host is deleted.
+func shutdown(controller *controller.Controller, registry *session.Registry, forwards *forward.Manager, servers []*http.Server) {
+	klog.Infof("draining, timeout %s", drainTimeout)
+	if err := controller.Drain(); err != nil {
+		klog.Errorf("set draining fail:%s", err.Error())
+	}
+	registry.Drain()
+	// forwarded connections already accepted are closed with the sessions
+	forwards.Close()
 
-func Client(server *remotedialer.Server, rw http.ResponseWriter, req *http.Request) {
-	timeout := req.URL.Query().Get("timeout")
-	if timeout == "" {
-		timeout = "15"
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			klog.Errorf("in-flight requests to %s not finished in %s: %s", srv.Addr, drainTimeout, err.Error())
+		}
 	}
 
-	vars := mux.Vars(req)
-	clientKey := vars["id"]
-	url := fmt.Sprintf("%s://%s%s", vars["scheme"], vars["host"], vars["path"])
-	client := getClient(server, clientKey, timeout)
+	registry.CloseSessions()
+	if err := controller.Shutdown(); err != nil {
+		klog.Errorf("delete host fail:%s", err.Error())
+	}
+}
 
-	klog.Infof("REQ t=%s %s", timeout, url)
+// remoteSessions returns the sessions of clientID published by the hosts other than self
+func remoteSessions(directory discovery.Directory, self, clientID string) []hostv1.ClientSession {
+	peerSessions, err := directory.Sessions(clientID)
+	if err != nil {
+		klog.Errorf("lookup sessions of client[%s] fail:%s", clientID, err.Error())
+		return nil
+	}
+	var sessions []hostv1.ClientSession
+	for _, s := range peerSessions {
+		if s.Peer.ID != self {
+			sessions = append(sessions, s.Session)
+		}
+	}
+	return sessions
+}
 
-	resp, err := client.Get(url)
+// remoteClusters returns the sessions registering the cluster id on the other hosts
+func remoteClusters(directory discovery.Directory, self, id string) []hostv1.ClientSession {
+	peerSessions, err := directory.Select(labels.Everything())
 	if err != nil {
-		klog.Errorf("REQ ERR t=%s %s: %v", timeout, url, err)
-		remotedialer.DefaultErrorWriter(rw, req, 500, err)
-		return
+		klog.Errorf("lookup agents of cluster %s fail:%s", id, err.Error())
+		return nil
+	}
+	var sessions []hostv1.ClientSession
+	for _, s := range peerSessions {
+		if s.Peer.ID != self && s.Session.Cluster == id {
+			sessions = append(sessions, s.Session)
+		}
 	}
-	defer resp.Body.Close()
+	return sessions
+}
 
-	klog.Infof(" REQ OK t=%s %s", timeout, url)
-	rw.WriteHeader(resp.StatusCode)
-	io.Copy(rw, resp.Body)
-	klog.Infof("REQ DONE t=%s %s", timeout, url)
+// parseBindings parses comma separated key=value bindings
+func parseBindings(list string) (map[string]string, error) {
+	bindings := map[string]string{}
+	for _, binding := range strings.Split(list, ",") {
+		if binding = strings.TrimSpace(binding); binding == "" {
+			continue
+		}
+		parts := strings.SplitN(binding, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid binding %s, expected key=value", binding)
+		}
+		bindings[parts[0]] = parts[1]
+	}
+	return bindings, nil
+}
+
+// parseCIDRs parses a comma separated list of networks
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// serve serves handler on addr until it is shut down
+func serve(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		fmt.Println("Listening on ", addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			klog.Fatalf("Error serving %s: %s", addr, err.Error())
+		}
+	}()
+	return srv
+}
+
+// newPools returns the client pools configured by -pool and -poolbalance
+func newPools() *proxy.Pools {
+	p, err := proxy.NewPools(poolBalance)
+	if err != nil {
+		klog.Fatalf("invalid poolbalance: %s", err.Error())
+	}
+	for _, pool := range pools {
+		parts := strings.SplitN(pool, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			klog.Fatalf("invalid pool %s, expected name:selector", pool)
+		}
+		selector, err := labels.Parse(parts[1])
+		if err != nil {
+			klog.Fatalf("invalid pool %s selector: %s", parts[0], err.Error())
+		}
+		p.Add(parts[0], selector)
+	}
+	return p
 }
 
-func getClient(server *remotedialer.Server, clientKey, timeout string) *http.Client {
+func newKubeClient() kubernetes.Interface {
+	kubeClient, err := kubernetes.NewForConfig(buildConfig())
+	if err != nil {
+		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+	}
+	return kubeClient
+}
 
-	dialer := server.Dialer(clientKey, 15*time.Second)
-	client := &http.Client{
-		Transport: &http.Transport{
-			Dial: dialer,
-		},
+func newHostClient() hostclientset.Interface {
+	hostClient, err := hostclientset.NewForConfig(buildConfig())
+	if err != nil {
+		klog.Fatalf("Error building host clientset: %s", err.Error())
 	}
-	if timeout != "" {
-		t, err := strconv.Atoi(timeout)
-		if err == nil {
-			client.Timeout = time.Duration(t) * time.Second
+	return hostClient
+}
+
+// newDiscovery returns the peer discovery backend selected by -discovery, hostClient is set for crd
+func newDiscovery(hostClient hostclientset.Interface) discovery.Discovery {
+	switch discoveryType {
+	case discovery.CRD:
+		return discovery.NewCRDDiscovery(hostClient)
+	case discovery.STATIC:
+		if peersFile == "" || peerToken == "" {
+			klog.Fatalf("-peers and -peertoken are required by %s discovery", discovery.STATIC)
 		}
+		return discovery.NewStaticDiscovery(peersFile, 10*time.Second)
+	case discovery.DNS:
+		if dnsName == "" || peerToken == "" {
+			klog.Fatalf("-dnsname and -peertoken are required by %s discovery", discovery.DNS)
+		}
+		return discovery.NewDNSDiscovery(dnsName, serverPort(), peerToken, 10*time.Second)
+	case discovery.ENDPOINTS:
+		parts := strings.SplitN(endpointsName, "/", 2)
+		if len(parts) != 2 || peerToken == "" {
+			klog.Fatalf("-endpoints namespace/name and -peertoken are required by %s discovery", discovery.ENDPOINTS)
+		}
+		return discovery.NewEndpointsDiscovery(newKubeClient(), parts[0], parts[1], serverPort(), peerToken)
 	}
-	return client
+	klog.Fatalf("unknown discovery %s", discoveryType)
+	return nil
+}
+
+func buildConfig() *rest.Config {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		klog.Fatalf("Error building kubeconfig: %s", err.Error())
+	}
+	return cfg
+}
+
+// serverPort returns the port part of -serverurl, peers are expected to serve on the same port
+func serverPort() string {
+	_, port, err := net.SplitHostPort(serverURL)
+	if err != nil {
+		klog.Fatalf("invalid serverurl %s: %s", serverURL, err.Error())
+	}
+	return port
+}
+
+func authorizer(req *http.Request) (string, bool, error) {
+	id := req.Header.Get("x-tunnel-id")
+	return id, id != "", nil
 }
 
 func init() {
 	flag.StringVar(&serverURL, "serverurl", ":8123", "remotedialer server url")
+	flag.StringVar(&adminURL, "adminurl", "127.0.0.1:8124", "admin api url, keep it on a protected address, none if empty")
 	flag.BoolVar(&debug, "debug", true, "debug remotedialer server")
+	flag.StringVar(&kubeconfig, "kubeconfig", controller.HOST_CONFIG_PATH, "kubeconfig file, used by crd and endpoints discovery")
+	flag.StringVar(&discoveryType, "discovery", discovery.CRD, "peer discovery: crd, static, dns or endpoints")
+	flag.StringVar(&peersFile, "peers", "", "static discovery peers file, one \"<address> <token> [url]\" per line")
+	flag.StringVar(&dnsName, "dnsname", "", "dns discovery name, SRV records if it starts with _, else A records")
+	flag.StringVar(&endpointsName, "endpoints", "", "endpoints discovery namespace/name")
+	flag.IntVar(&fanout, "fanout", 0, "number of peers to connect with, 0 for all (full mesh). others are reached through the crd client directory")
+	flag.DurationVar(&drainTimeout, "draintimeout", 30*time.Second, "time in-flight requests get to finish on shutdown")
+	flag.Var(&pools, "pool", "client pool name:selector, e.g. berlin:site=berlin, repeatable. other pools have the clients labeled pool=<name>")
+	flag.StringVar(&poolBalance, "poolbalance", proxy.ROUND_ROBIN, "pool balancing: roundrobin or leastoutstanding")
+	flag.StringVar(&exposePorts, "exposeports", "", "ports agents may ask to listen on, e.g. 30000-32767, none if empty")
+	flag.StringVar(&servicePorts, "serviceports", "", "ports allocated to TunnelServices, e.g. 31000-31999, TunnelServices are ignored if empty (crd discovery only)")
+	flag.StringVar(&ingressClass, "ingressclass", "", "serve the Ingresses of this class, e.g. hostmanager, on -ingressurl, none if empty")
+	flag.StringVar(&ingressURL, "ingressurl", ":8080", "ingress server url")
+	flag.StringVar(&vhostSuffix, "vhostsuffix", "", "tunnel domain, requests for <target>.<clientid>.<suffix> go to <target> through <clientid>, none if empty")
+	flag.BoolVar(&tunnelRoutes, "tunnelroutes", false, "serve the TunnelRoutes of all namespaces (crd discovery only)")
+	flag.BoolVar(&checks, "connectivitychecks", false, "run the ConnectivityChecks of all namespaces through the clients connected here (crd discovery only)")
+	flag.StringVar(&dnsURL, "dnsurl", "", "udp address of the dns responder, e.g. :5353, none if empty")
+	flag.StringVar(&dnsZone, "dnszone", "tunnel.", "dns zone, <svc>.<clientid>.<zone> resolves to this hostmanager while the client is connected")
+	flag.DurationVar(&udpTimeout, "udptimeout", forward.UDP_TIMEOUT, "idle time after which a forwarded udp flow is closed")
+	flag.BoolVar(&clusterProxy, "clusterproxy", false, "serve /k8s/clusters/{id} with the API servers of the clusters of the agents, impersonating the caller of -userheader")
+	flag.StringVar(&clusterCIDRs, "clusterproxycidr", "", "comma separated networks of the authenticating proxies in front of hostmanager, e.g. 10.0.3.0/24, the only callers of /k8s/clusters/{id} trusted to set -userheader and -groupheader. required with -clusterproxy")
+	flag.StringVar(&clusterOwners, "clusterclients", "", "comma separated cluster=client bindings, only the bound client may register a cluster, e.g. east=agent-east. without bindings any client may register a cluster no other client holds")
+	flag.StringVar(&userHeader, "userheader", proxy.USER_HEADER, "header with the caller user, set by the authenticating proxy in front of hostmanager")
+	flag.StringVar(&groupHeader, "groupheader", proxy.GROUP_HEADER, "header with the caller groups, set by the authenticating proxy in front of hostmanager")
+	flag.StringVar(&clientLimits, "clientlimits", "", "limits of every client, e.g. streams=100,rate=50,bandwidth=10485760 bytes per second, the stricter limits declared by an agent apply to it")
+	flag.StringVar(&callerLimits, "callerlimits", "", "limits of every caller address through every client, e.g. streams=10,rate=5")
+	flag.DurationVar(&timeouts.Dial, "dialtimeout", proxy.DIAL_TIMEOUT, "time the agent gets to dial a target")
+	flag.DurationVar(&timeouts.ResponseHeader, "headertimeout", 0, "time a target gets to send the response headers, none if 0")
+	flag.DurationVar(&timeouts.Idle, "idletimeout", 0, "time without bytes on the connection to a target after which it is closed, none if 0")
+	flag.DurationVar(&timeouts.Request, "requesttimeout", 0, "time a request gets, none if 0. /client and /select default to 15s")
+	flag.DurationVar(&maxTimeout, "maxtimeout", proxy.MAX_TIMEOUT, "most a caller can set a timeout to with X-Tunnel-Timeout or ?timeout=")
+	flag.BoolVar(&breakers, "breakers", true, "fail the requests fast with 503 while a client or one of its targets keeps failing")
+	flag.DurationVar(&breakerOpts.Window, "breakerwindow", breaker.WINDOW, "period the error rate of a circuit is measured over")
+	flag.IntVar(&breakerOpts.MinRequests, "breakerminrequests", breaker.MIN_REQUESTS, "requests needed in a window before a circuit opens")
+	flag.Float64Var(&breakerOpts.ErrorRate, "breakererrorrate", breaker.ERROR_RATE, "rate of failed requests of a window which opens a circuit")
+	flag.DurationVar(&breakerOpts.SlowLatency, "breakerslow", 0, "requests slower than it count as failures of their target, none if 0")
+	flag.DurationVar(&breakerOpts.OpenTimeout, "breakeropen", breaker.OPEN_TIMEOUT, "time a circuit stays open before a probe request is let through")
+	flag.DurationVar(&probeOpts.Interval, "probeinterval", session.PROBE_INTERVAL, "how often the agents are pinged and their targets probed, never if 0")
+	flag.DurationVar(&probeOpts.Timeout, "probetimeout", session.PROBE_TIMEOUT, "time an agent gets to answer a ping and to dial a target")
+	flag.IntVar(&probeOpts.Failures, "probefailures", session.PROBE_FAILURES, "failed pings or probes of a target in a row which degrade an agent")
+	flag.StringVar(&peerToken, "peertoken", "", "token shared by all peers, random if empty (crd discovery only)")
 }
@@ -0,0 +1,331 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rancher/remotedialer"
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ID_HEADER carries the client id on connect
+	ID_HEADER = "X-Tunnel-ID"
+
+	// the wait before reconnecting doubles with every failed attempt, from BACKOFF_BASE up to BACKOFF_MAX
+	BACKOFF_BASE = time.Second
+	BACKOFF_MAX  = time.Minute
+
+	// SERVICE_ACCOUNT_DIR has the token and CA of the pod service account
+	SERVICE_ACCOUNT_DIR = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Options configure an Agent
+type Options struct {
+	// Servers are connect urls, e.g. ws://10.0.2.15:8123/connect
+	Servers []string
+	// Bootstrap are hostmanager urls the connect urls are listed from, e.g. http://10.0.2.15:8123
+	Bootstrap []string
+	// PreferLatency tries the server with the fastest tcp connect first, instead of a random one
+	PreferLatency bool
+
+	// ID is the client id hostmanager reaches the agent by
+	ID string
+	// Credentials are extra connect headers checked by the hostmanager authorizer
+	Credentials http.Header
+	// Metadata describes the agent to hostmanager
+	Metadata Metadata
+	// Expose asks hostmanager to listen on ports and forward their connections to targets on the
+	// agent network, the ports must be allowed by hostmanager and the targets by Allow
+	Expose []session.Exposure
+	// Cluster registers the kubernetes cluster the agent runs in, hostmanager proxies its API server
+	// at /k8s/clusters/{id} with the credentials of the agent
+	Cluster *session.Cluster
+	// Allow are the dials hostmanager may make through the agent, all but unix sockets when empty.
+	// They are declared to hostmanager, which refuses the dials outside of them: remotedialer would
+	// end the session on such a dial, the agent then reconnects.
+	Allow []Rule
+	// Limits are declared to hostmanager, which enforces them on the streams through the agent. The agent
+	// enforces the bandwidth on its tunnel too.
+	Limits limit.Limits
+	// Probes are host:port targets hostmanager dials through the agent to tell if it is healthy, they
+	// must be allowed by Allow
+	Probes []string
+	// UDPTimeout is the idle time after which a udp flow relayed for hostmanager is closed, UDP_TIMEOUT when 0
+	UDPTimeout time.Duration
+
+	// Logger defaults to the logrus standard logger
+	Logger logrus.FieldLogger
+	// OnConnect is called when a session to url is opened
+	OnConnect func(url string)
+	// OnDisconnect is called when the session to url ended with err
+	OnDisconnect func(url string, err error)
+}
+
+// Metadata is sent to hostmanager on connect, which records it in the client session.
+// Hostname, OS and Arch default to the ones of this machine.
+type Metadata struct {
+	Version  string
+	Hostname string
+	OS       string
+	Arch     string
+	// Labels, like site=berlin, let hostmanager select clients
+	Labels map[string]string
+	// CIDRs are the networks reachable through the agent
+	CIDRs []string
+}
+
+func (m Metadata) headers(headers http.Header) {
+	if m.Hostname == "" {
+		m.Hostname, _ = os.Hostname()
+	}
+	if m.OS == "" {
+		m.OS = runtime.GOOS
+	}
+	if m.Arch == "" {
+		m.Arch = runtime.GOARCH
+	}
+	for header, value := range map[string]string{
+		session.AGENT_VERSION_HEADER: m.Version,
+		session.HOSTNAME_HEADER:      m.Hostname,
+		session.OS_HEADER:            m.OS,
+		session.ARCH_HEADER:          m.Arch,
+		session.LABELS_HEADER:        labels.Set(m.Labels).String(),
+		session.CIDRS_HEADER:         strings.Join(m.CIDRs, ","),
+	} {
+		if value != "" {
+			headers.Set(header, value)
+		}
+	}
+}
+
+// InCluster returns the cluster id with the API server address and service account of the pod the agent runs in
+func InCluster(id string) (*session.Cluster, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(path.Join(SERVICE_ACCOUNT_DIR, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(path.Join(SERVICE_ACCOUNT_DIR, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	return &session.Cluster{
+		ID:      id,
+		Address: net.JoinHostPort(host, port),
+		Token:   strings.TrimSpace(string(token)),
+		CACert:  ca,
+	}, nil
+}
+
+// Rule allows dials with Proto, any proto but unix when empty, to the addresses matching Address,
+// a path.Match pattern like 10.0.*:80 or /var/run/docker.sock for unix
+type Rule = session.Rule
+
+// ParseRule parses [proto:]address, e.g. tcp:10.0.*:80 or *:443
+func ParseRule(rule string) (Rule, error) {
+	return session.ParseRule(rule)
+}
+
+// Agent keeps a tunnel session to one of its hostmanager servers, failing over to the next one
+// when the session can not be opened or ends.
+type Agent struct {
+	opts    Options
+	log     logrus.FieldLogger
+	servers *servers
+	// relayAddress is the address of the udp relay while Run runs
+	relayAddress string
+}
+
+func New(opts Options) *Agent {
+	log := opts.Logger
+	if log == nil {
+		log = logrus.StandardLogger()
+	}
+	return &Agent{
+		opts:    opts,
+		log:     log,
+		servers: newServers(opts.Servers, opts.Bootstrap, opts.PreferLatency, log),
+	}
+}
+
+// Run connects until ctx is done, retrying with a jittered exponential backoff
+func (a *Agent) Run(ctx context.Context) error {
+	if len(a.opts.Servers) == 0 && len(a.opts.Bootstrap) == 0 {
+		return fmt.Errorf("no servers and no bootstrap urls")
+	}
+	headers := http.Header{}
+	for key, values := range a.opts.Credentials {
+		headers[key] = values
+	}
+	a.opts.Metadata.headers(headers)
+	if len(a.opts.Expose) > 0 {
+		var exposures []string
+		for _, expose := range a.opts.Expose {
+			exposures = append(exposures, expose.String())
+		}
+		headers.Set(session.EXPOSE_HEADER, strings.Join(exposures, ","))
+	}
+	// hostmanager refuses the dials outside of the rules, a denied dial would end the session
+	if len(a.opts.Allow) > 0 {
+		headers.Set(session.ALLOW_HEADER, strings.Join(session.Rules(a.opts.Allow).Strings(), ","))
+	}
+	if a.opts.Cluster != nil {
+		value, err := a.opts.Cluster.Header()
+		if err != nil {
+			return err
+		}
+		headers.Set(session.CLUSTER_HEADER, value)
+	}
+	if limits := a.opts.Limits.String(); limits != "" {
+		headers.Set(session.LIMITS_HEADER, limits)
+	}
+	// the pings of hostmanager are answered by failing to dial session.PING_ADDRESS
+	headers.Set(session.PING_HEADER, "true")
+	var probes []string
+	for _, target := range a.opts.Probes {
+		if !a.allow("tcp", target) {
+			// a denied dial would end the session
+			a.log.Errorf("probe %s is not allowed, dropped", target)
+			continue
+		}
+		probes = append(probes, target)
+	}
+	if len(probes) > 0 {
+		headers.Set(session.PROBES_HEADER, strings.Join(probes, ","))
+	}
+	headers.Set(ID_HEADER, a.opts.ID)
+
+	relay, err := newRelay(a.opts.UDPTimeout, session.Rules(a.opts.Allow).Allows, a.log)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	go relay.serve()
+	a.relayAddress = relay.Addr().String()
+	headers.Set(session.UDP_RELAY_HEADER, a.relayAddress)
+
+	failures := 0
+	for ctx.Err() == nil {
+		url := a.servers.next()
+		if url == "" {
+			failures++
+			a.log.Errorf("no server to connect to")
+		} else if connected, err := a.connect(ctx, url, headers); connected {
+			// a session was up, fail over at once but without looping on a server dropping every session
+			failures = 1
+			a.log.WithError(err).Infof("disconnected from %s", url)
+			if a.opts.OnDisconnect != nil {
+				a.opts.OnDisconnect(url, err)
+			}
+		} else {
+			failures++
+			a.log.WithError(err).Errorf("connect to %s fail", url)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff(failures)):
+		}
+	}
+	return ctx.Err()
+}
+
+// connect serves a tunnel session on url until it ends, connected is false if it could not be opened.
+// A refusing hostmanager may hint at the servers to connect to instead.
+func (a *Agent) connect(ctx context.Context, url string, headers http.Header) (bool, error) {
+	a.log.WithField("url", url).Info("Connecting to proxy")
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: remotedialer.HandshakeTimeOut}
+	if a.opts.Limits.Bandwidth > 0 {
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return limit.Throttle(conn, a.opts.Limits.Bandwidth), nil
+		}
+	}
+	ws, resp, err := dialer.DialContext(ctx, url, headers)
+	if err != nil {
+		if resp != nil {
+			if redirect := resp.Header.Get(session.REDIRECT_HEADER); redirect != "" {
+				a.servers.redirect(SplitList(redirect))
+			}
+			switch {
+			case resp.Header.Get(session.DRAINING_HEADER) != "":
+				err = fmt.Errorf("%s is draining", url)
+			case resp.Header.Get(session.CORDONED_HEADER) != "":
+				err = fmt.Errorf("%s is cordoned", url)
+			default:
+				err = fmt.Errorf("%s: %s", resp.Status, err.Error())
+			}
+		}
+		return false, err
+	}
+	defer ws.Close()
+
+	a.log.WithField("url", url).Info("Connected to proxy")
+	if a.opts.OnConnect != nil {
+		a.opts.OnConnect(url)
+	}
+	s := remotedialer.NewClientSession(a.allow, ws)
+	defer s.Close()
+	_, err = s.Serve(ctx)
+	return true, err
+}
+
+// allow is the remotedialer connect authorizer, unix sockets are not allowed without a unix rule
+func (a *Agent) allow(proto, address string) bool {
+	if proto == "tcp" && (address == session.PING_ADDRESS || address == a.relayAddress) {
+		return true
+	}
+	if session.Rules(a.opts.Allow).Allows(proto, address) {
+		return true
+	}
+	a.log.Infof("dial %s %s not allowed", proto, address)
+	return false
+}
+
+// backoff returns the wait after failures consecutive failures, jittered so agents do not reconnect all at once
+func backoff(failures int) time.Duration {
+	d := BACKOFF_MAX
+	if failures < 7 {
+		d = BACKOFF_BASE << uint(failures-1)
+		if d > BACKOFF_MAX {
+			d = BACKOFF_MAX
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ParseExposure parses port[/udp]=host:port
+func ParseExposure(value string) (session.Exposure, error) {
+	return session.ParseExposure(value)
+}
+
+// SplitList splits a comma separated list, dropping empty items
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/api"
+	"hostmanager/pkg/session"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, test := range []struct {
+		rule string
+		want Rule
+		err  bool
+	}{
+		{rule: "tcp:10.0.*:80", want: Rule{Proto: "tcp", Address: "10.0.*:80"}},
+		{rule: "udp:*:53", want: Rule{Proto: "udp", Address: "*:53"}},
+		{rule: "unix:/var/run/docker.sock", want: Rule{Proto: "unix", Address: "/var/run/docker.sock"}},
+		{rule: "*:443", want: Rule{Address: "*:443"}},
+		{rule: "example.com:443", want: Rule{Address: "example.com:443"}},
+		{rule: "tcp:", err: true},
+		{rule: "", err: true},
+		{rule: "10.0.[:80", err: true},
+	} {
+		got, err := ParseRule(test.rule)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.rule, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: expected %+v, got %+v %v", test.rule, test.want, got, err)
+		}
+		if got.String() != test.rule {
+			t.Errorf("%q: formatted as %q", test.rule, got.String())
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	rules := func(list ...string) []Rule {
+		parsed, err := session.ParseRules(list)
+		if err != nil {
+			t.Fatalf("parse %v: %v", list, err)
+		}
+		return parsed
+	}
+	for _, test := range []struct {
+		allow   []Rule
+		proto   string
+		address string
+		want    bool
+	}{
+		// no rules allow all but unix sockets
+		{allow: nil, proto: "tcp", address: "10.0.0.1:80", want: true},
+		{allow: nil, proto: "udp", address: "10.0.0.1:53", want: true},
+		{allow: nil, proto: "unix", address: "/var/run/docker.sock", want: false},
+		{allow: rules("tcp:10.0.*:80"), proto: "tcp", address: "10.0.3.4:80", want: true},
+		{allow: rules("tcp:10.0.*:80"), proto: "tcp", address: "10.0.3.4:443", want: false},
+		{allow: rules("tcp:10.0.*:80"), proto: "udp", address: "10.0.3.4:80", want: false},
+		{allow: rules("*:443"), proto: "udp", address: "10.0.3.4:443", want: true},
+		// a rule without proto never allows unix sockets
+		{allow: rules("*"), proto: "unix", address: "/var/run/docker.sock", want: false},
+		{allow: rules("unix:/var/run/*.sock"), proto: "unix", address: "/var/run/docker.sock", want: true},
+		{allow: rules("unix:/var/run/*.sock"), proto: "tcp", address: "10.0.3.4:80", want: false},
+		// the pings are answered whatever the rules
+		{allow: rules("unix:/var/run/docker.sock"), proto: "tcp", address: session.PING_ADDRESS, want: true},
+	} {
+		a := New(Options{Allow: test.allow, Logger: logrus.New()})
+		if got := a.allow(test.proto, test.address); got != test.want {
+			t.Errorf("%v allow %s %s: expected %v, got %v", test.allow, test.proto, test.address, test.want, got)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+	allowed := backend.LocalAddr().String()
+	r, err := newRelay(100*time.Millisecond, func(proto, address string) bool {
+		return proto == "udp" && address == allowed
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	defer r.Close()
+	go r.serve()
+
+	flow := func(target string) net.Conn {
+		conn, err := net.Dial("tcp", r.Addr().String())
+		if err != nil {
+			t.Fatalf("dial relay: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := session.WriteDatagram(conn, []byte(target)); err != nil {
+			t.Fatalf("write target: %v", err)
+		}
+		return conn
+	}
+
+	conn := flow(allowed)
+	defer conn.Close()
+	buf := make([]byte, session.MAX_DATAGRAM)
+	for _, datagram := range []string{"a", "bb", "ccc"} {
+		if err := session.WriteDatagram(conn, []byte(datagram)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		n, err := session.ReadDatagram(conn, buf)
+		if err != nil || string(buf[:n]) != datagram {
+			t.Fatalf("expected the echo of %q, got %q %v", datagram, buf[:n], err)
+		}
+	}
+	// the idle flow is closed by the relay
+	start := time.Now()
+	if _, err := session.ReadDatagram(conn, buf); err == nil {
+		t.Errorf("expected the idle flow to be closed")
+	} else if time.Since(start) > 2*time.Second {
+		t.Errorf("idle flow closed after %s", time.Since(start))
+	}
+
+	denied := flow("127.0.0.1:1")
+	defer denied.Close()
+	if _, err := session.ReadDatagram(denied, buf); err == nil {
+		t.Errorf("expected the flow to a target outside the rules to be closed")
+	}
+
+	// the relay is always allowed to hostmanager, whatever the rules
+	a := New(Options{Allow: []Rule{{Proto: "udp", Address: "10.0.0.1:53"}}, Logger: logrus.New()})
+	a.relayAddress = r.Addr().String()
+	if !a.allow("tcp", a.relayAddress) {
+		t.Errorf("the relay should be allowed")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, test := range []struct {
+		failures int
+		max      time.Duration
+	}{
+		{failures: 1, max: BACKOFF_BASE},
+		{failures: 2, max: 2 * BACKOFF_BASE},
+		{failures: 4, max: 8 * BACKOFF_BASE},
+		{failures: 7, max: BACKOFF_MAX},
+		{failures: 100, max: BACKOFF_MAX},
+	} {
+		for i := 0; i < 20; i++ {
+			if d := backoff(test.failures); d < test.max/2 || d > test.max {
+				t.Errorf("backoff(%d) = %s, expected within [%s, %s]", test.failures, d, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	for list, want := range map[string][]string{
+		"":                  nil,
+		" , ,":              nil,
+		"a":                 {"a"},
+		"a, b,,c ":          {"a", "b", "c"},
+		"ws://a/connect, b": {"ws://a/connect", "b"},
+	} {
+		if got := SplitList(list); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %v, got %v", list, want, got)
+		}
+	}
+}
+
+func TestServers(t *testing.T) {
+	hosts := []api.Host{{URL: "ws://b/connect"}, {URL: "ws://c/connect"}}
+	bootstrap := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/hosts" {
+			http.NotFound(rw, req)
+			return
+		}
+		json.NewEncoder(rw).Encode(hosts)
+	}))
+	defer bootstrap.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	round := func(s *servers, n int) map[string]int {
+		seen := map[string]int{}
+		for i := 0; i < n; i++ {
+			seen[s.next()]++
+		}
+		return seen
+	}
+
+	for _, test := range []struct {
+		name      string
+		static    []string
+		bootstrap []string
+		want      map[string]int
+	}{
+		{name: "static", static: []string{"ws://a/connect"}, want: map[string]int{"ws://a/connect": 1}},
+		// the static urls and the hosts of the first bootstrap url that answers, without duplicates
+		{
+			name: "bootstrap", static: []string{"ws://a/connect", "ws://b/connect"}, bootstrap: []string{down.URL, bootstrap.URL},
+			want: map[string]int{"ws://a/connect": 1, "ws://b/connect": 1, "ws://c/connect": 1},
+		},
+		{name: "none", bootstrap: []string{down.URL}, want: map[string]int{"": 1}},
+	} {
+		s := newServers(test.static, test.bootstrap, false, log)
+		if got := round(s, len(test.want)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected a round of %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	// a redirect is tried before the rest of the round
+	s := newServers([]string{"ws://a/connect", "ws://b/connect"}, nil, false, log)
+	s.next()
+	s.redirect([]string{"ws://x/connect"})
+	if url := s.next(); url != "ws://x/connect" {
+		t.Errorf("expected the redirect first, got %s", url)
+	}
+	if url := s.next(); url != "ws://a/connect" && url != "ws://b/connect" {
+		t.Errorf("expected the rest of the round, got %s", url)
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Main runs the agent configured by the command line flags until it fails, version is sent to hostmanager
+func Main(version string) {
+	var (
+		addr          string
+		bootstrap     string
+		preferLatency bool
+		allow         string
+		labelList     string
+		cidrs         string
+		pool          string
+		expose        string
+		cluster       string
+		limits        string
+		probes        string
+		id            string
+		udpTimeout    time.Duration
+		debug         bool
+	)
+	flag.StringVar(&addr, "connect", "ws://localhost:8123/connect", "Comma separated addresses to connect to")
+	flag.StringVar(&bootstrap, "bootstrap", "", "Comma separated hostmanager urls to get the addresses to connect to from, e.g. http://10.0.2.15:8123")
+	flag.BoolVar(&preferLatency, "preferlatency", false, "Connect to the address with the lowest latency first")
+	flag.StringVar(&allow, "allow", "", "Comma separated [proto:]address patterns hostmanager may dial, e.g. tcp:10.0.*:80 or unix:/var/run/docker.sock, all but unix sockets when empty")
+	flag.StringVar(&labelList, "labels", "", "Comma separated k=v labels hostmanager can select the client by, e.g. site=berlin")
+	flag.StringVar(&cidrs, "cidrs", "", "Comma separated networks reachable through the client")
+	flag.StringVar(&pool, "pool", "", "Pool of the client, sets the pool label")
+	flag.StringVar(&expose, "expose", "", "Comma separated port[/udp]=host:port listeners to ask hostmanager for, e.g. 30022=127.0.0.1:22,30053/udp=10.0.0.2:53")
+	flag.StringVar(&cluster, "cluster", "", "Id of the kubernetes cluster the client runs in, hostmanager proxies its API server with the client service account")
+	flag.StringVar(&limits, "limits", "", "Limits of the streams through the client, e.g. streams=10,rate=5,bandwidth=1048576 bytes per second, enforced by hostmanager and the bandwidth by the client too")
+	flag.StringVar(&probes, "probes", "", "Comma separated host:port targets hostmanager probes through the client to tell if it is healthy, e.g. 10.0.0.5:443")
+	flag.DurationVar(&udpTimeout, "udptimeout", UDP_TIMEOUT, "Idle time after which a udp flow relayed for hostmanager is closed")
+	flag.StringVar(&id, "id", "foo", "Client ID")
+	flag.BoolVar(&debug, "debug", true, "Debug logging")
+	flag.Parse()
+
+	if debug {
+		logrus.SetLevel(logrus.DebugLevel)
+		remotedialer.PrintTunnelData = true
+	}
+	rand.Seed(time.Now().UnixNano())
+
+	opts := Options{
+		Bootstrap:     SplitList(bootstrap),
+		PreferLatency: preferLatency,
+		ID:            id,
+		UDPTimeout:    udpTimeout,
+		Metadata: Metadata{
+			Version: version,
+			CIDRs:   SplitList(cidrs),
+		},
+	}
+	set, err := labels.ConvertSelectorToLabelsMap(labelList)
+	if err != nil {
+		logrus.Fatalf("invalid labels %s: %s", labelList, err.Error())
+	}
+	if pool != "" {
+		set[session.POOL_LABEL] = pool
+	}
+	opts.Metadata.Labels = set
+	if bootstrap == "" || isFlagSet("connect") {
+		opts.Servers = SplitList(addr)
+	}
+	if opts.Allow, err = session.ParseRules(SplitList(allow)); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, value := range SplitList(expose) {
+		e, err := ParseExposure(value)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		opts.Expose = append(opts.Expose, e)
+	}
+
+	if opts.Limits, err = limit.ParseLimits(limits); err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, target := range SplitList(probes) {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			logrus.Fatalf("invalid probe %s: %s", target, err.Error())
+		}
+		opts.Probes = append(opts.Probes, target)
+	}
+
+	if cluster != "" {
+		if opts.Cluster, err = InCluster(cluster); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	if err := New(opts).Run(context.Background()); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/session"
+)
+
+const (
+	// UDP_TIMEOUT is the default idle time after which a relayed udp flow is closed
+	UDP_TIMEOUT = 60 * time.Second
+	// CHECK_TIMEOUT is the longest dial of a tcp check, hostmanager gives up on its own timeout
+	CHECK_TIMEOUT = 30 * time.Second
+)
+
+// relay forwards the udp flows of hostmanager. remotedialer streams the bytes of a connection without
+// keeping the datagram boundaries, so hostmanager dials the relay over tcp through the tunnel and frames
+// the datagrams with session.WriteDatagram, the first frame being the host:port target. The relay sends
+// every datagram on its own from the agent network and frames the replies back. A first frame with
+// session.TCP_CHECK_PREFIX asks the relay to confirm a tcp dial instead.
+type relay struct {
+	net.Listener
+	timeout time.Duration
+	// allows returns true if the rules of the agent allow to dial the target
+	allows func(proto, address string) bool
+	log    logrus.FieldLogger
+}
+
+// newRelay listens on the loopback interface, only the agent dials the relay on behalf of hostmanager
+func newRelay(timeout time.Duration, allows func(proto, address string) bool, log logrus.FieldLogger) (*relay, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = UDP_TIMEOUT
+	}
+	return &relay{Listener: l, timeout: timeout, allows: allows, log: log}, nil
+}
+
+func (r *relay) serve() {
+	for {
+		conn, err := r.Accept()
+		if err != nil {
+			// closed
+			return
+		}
+		go r.flow(conn)
+	}
+}
+
+// flow relays the datagrams of a flow until either side fails or the flow is idle for the timeout
+func (r *relay) flow(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, session.MAX_DATAGRAM)
+	n, err := session.ReadDatagram(conn, buf)
+	if err != nil {
+		r.log.WithError(err).Error("udp relay read target fail")
+		return
+	}
+	target := string(buf[:n])
+	if strings.HasPrefix(target, session.TCP_CHECK_PREFIX) {
+		r.check(conn, strings.TrimPrefix(target, session.TCP_CHECK_PREFIX))
+		return
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil || !r.allows("udp", target) {
+		r.log.Errorf("udp relay to %s not allowed", target)
+		return
+	}
+	remote, err := net.Dial("udp", target)
+	if err != nil {
+		r.log.WithError(err).Errorf("udp relay dial %s fail", target)
+		return
+	}
+	defer remote.Close()
+
+	var lastSeen int64
+	seen := func() { atomic.StoreInt64(&lastSeen, time.Now().UnixNano()) }
+	seen()
+	var once sync.Once
+	done := make(chan struct{})
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+			remote.Close()
+		})
+	}
+	defer stop()
+	go func() {
+		ticker := time.NewTicker(r.timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&lastSeen))) >= r.timeout {
+					r.log.Debugf("udp relay to %s idle, closed", target)
+					stop()
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer stop()
+		reply := make([]byte, session.MAX_DATAGRAM)
+		for {
+			n, err := remote.Read(reply)
+			if err != nil {
+				return
+			}
+			seen()
+			if err := session.WriteDatagram(conn, reply[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		n, err := session.ReadDatagram(conn, buf)
+		if err != nil {
+			return
+		}
+		seen()
+		// a refused datagram, e.g. nothing listening yet, does not end the flow
+		remote.Write(buf[:n])
+	}
+}
+
+// check dials the tcp target and answers with an empty frame when connected, the error otherwise.
+// remotedialer reports no successful dial, this lets hostmanager tell a silent target from a lost dial.
+func (r *relay) check(conn net.Conn, target string) {
+	answer := ""
+	if _, _, err := net.SplitHostPort(target); err != nil || !r.allows("tcp", target) {
+		r.log.Errorf("tcp check of %s not allowed", target)
+		answer = "not allowed by the agent"
+	} else if remote, err := net.DialTimeout("tcp", target, CHECK_TIMEOUT); err != nil {
+		answer = err.Error()
+	} else {
+		remote.Close()
+	}
+	session.WriteDatagram(conn, []byte(answer))
+}
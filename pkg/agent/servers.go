@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/api"
+)
+
+const (
+	// BOOTSTRAP_TIMEOUT bounds the request for the hosts to a bootstrap url
+	BOOTSTRAP_TIMEOUT = 5 * time.Second
+	// LATENCY_TIMEOUT bounds the tcp connect measuring the latency of a server
+	LATENCY_TIMEOUT = 2 * time.Second
+)
+
+// servers hands out the connect urls to try one after the other. Every round the urls are
+// listed again, from the static ones and the hosts returned by the bootstrap urls.
+type servers struct {
+	static        []string
+	bootstrap     []string
+	preferLatency bool
+	queue         []string
+	log           logrus.FieldLogger
+}
+
+func newServers(static, bootstrap []string, preferLatency bool, log logrus.FieldLogger) *servers {
+	return &servers{
+		static:        static,
+		bootstrap:     bootstrap,
+		preferLatency: preferLatency,
+		log:           log,
+	}
+}
+
+// next returns the url to connect to next, or "" when none is known
+func (s *servers) next() string {
+	if len(s.queue) == 0 {
+		s.queue = s.list()
+	}
+	if len(s.queue) == 0 {
+		return ""
+	}
+	url := s.queue[0]
+	s.queue = s.queue[1:]
+	return url
+}
+
+// redirect makes the urls, given by a refusing hostmanager, the next ones to try
+func (s *servers) redirect(urls []string) {
+	s.queue = append(urls, s.queue...)
+}
+
+// list returns the urls of a round, fastest first with preferLatency, otherwise shuffled
+// so the agents spread over the servers
+func (s *servers) list() []string {
+	seen := map[string]bool{}
+	var urls []string
+	for _, u := range append(s.static, s.fetch()...) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	if s.preferLatency {
+		return s.byLatency(urls)
+	}
+	rand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+	return urls
+}
+
+// fetch returns the connect urls of the hosts listed by the first bootstrap url that answers
+func (s *servers) fetch() []string {
+	client := &http.Client{Timeout: BOOTSTRAP_TIMEOUT}
+	for _, bootstrap := range s.bootstrap {
+		urls, err := fetchHosts(client, bootstrap)
+		if err != nil {
+			s.log.WithError(err).Errorf("get hosts from %s fail", bootstrap)
+			continue
+		}
+		return urls
+	}
+	return nil
+}
+
+func fetchHosts(client *http.Client, bootstrap string) ([]string, error) {
+	resp, err := client.Get(bootstrap + "/api/v1/hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var hosts []api.Host
+	if err := json.NewDecoder(resp.Body).Decode(&hosts); err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		urls = append(urls, host.URL)
+	}
+	return urls, nil
+}
+
+// byLatency sorts urls by the time a tcp connect to them takes, unreachable ones last
+func (s *servers) byLatency(urls []string) []string {
+	latencies := make(map[string]time.Duration, len(urls))
+	for _, u := range urls {
+		latencies[u] = latency(u)
+		s.log.Debugf("latency of %s: %s", u, latencies[u])
+	}
+	sort.SliceStable(urls, func(i, j int) bool { return latencies[urls[i]] < latencies[urls[j]] })
+	return urls
+}
+
+func latency(rawurl string) time.Duration {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return LATENCY_TIMEOUT
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, LATENCY_TIMEOUT)
+	if err != nil {
+		return LATENCY_TIMEOUT
+	}
+	conn.Close()
+	return time.Since(start)
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/breaker"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+// LocalHost is the hostmanager serving the api
+type LocalHost interface {
+	Cordon(cordoned bool) error
+	// Hosts returns the hostmanagers agents may connect to
+	Hosts() []discovery.Peer
+}
+
+// API serves the hostmanager http api under /api/v1
+type API struct {
+	server   *remotedialer.Server
+	registry *session.Registry
+	// directory may be nil when the discovery does not support it
+	directory discovery.Directory
+	host      LocalHost
+	// Breakers are served by the admin api, none when nil
+	Breakers *breaker.Breakers
+}
+
+func New(server *remotedialer.Server, registry *session.Registry, directory discovery.Directory, host LocalHost) *API {
+	return &API{
+		server:    server,
+		registry:  registry,
+		directory: directory,
+		host:      host,
+	}
+}
+
+// Register adds the api routes to router
+func (a *API) Register(router *mux.Router) {
+	router.HandleFunc("/api/v1/clients", a.listClients).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/clients/{id}", a.getClient).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/hosts", a.getHosts).Methods(http.MethodGet)
+}
+
+// RegisterAdmin adds the admin api routes to router, which must only be served on a protected listener
+func (a *API) RegisterAdmin(router *mux.Router) {
+	router.HandleFunc("/api/v1/admin/cordon", a.cordon(true)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/admin/uncordon", a.cordon(false)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/admin/breakers", a.getBreakers).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/admin/breakers/reset", a.resetBreakers).Methods(http.MethodPost)
+}
+
+// Host is a hostmanager agents may connect to, /api/v1/hosts returns a list of them
+type Host struct {
+	Host string `json:"host"`
+	URL  string `json:"url"`
+}
+
+// getHosts serves /api/v1/hosts, the bootstrap list of agents
+func (a *API) getHosts(rw http.ResponseWriter, req *http.Request) {
+	hosts := []Host{}
+	for _, peer := range a.host.Hosts() {
+		hosts = append(hosts, Host{Host: peer.ID, URL: peer.URL})
+	}
+	writeJSON(rw, http.StatusOK, hosts)
+}
+
+// HostState is the response of the admin api
+type HostState struct {
+	Host     string `json:"host"`
+	Cordoned bool   `json:"cordoned"`
+}
+
+// cordon serves /api/v1/admin/cordon and /api/v1/admin/uncordon for this hostmanager
+func (a *API) cordon(cordoned bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if err := a.host.Cordon(cordoned); err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(rw, http.StatusOK, HostState{Host: a.server.PeerID, Cordoned: cordoned})
+	}
+}
+
+// getBreakers serves /api/v1/admin/breakers, the circuits of this hostmanager, the open ones first
+func (a *API) getBreakers(rw http.ResponseWriter, req *http.Request) {
+	states := []breaker.State{}
+	if a.Breakers != nil {
+		states = append(states, a.Breakers.States()...)
+	}
+	writeJSON(rw, http.StatusOK, states)
+}
+
+// resetBreakers serves /api/v1/admin/breakers/reset?client={id}, closing the circuits of the client, of all
+// clients without it
+func (a *API) resetBreakers(rw http.ResponseWriter, req *http.Request) {
+	if a.Breakers == nil {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("circuit breakers are disabled"))
+		return
+	}
+	clientID := req.URL.Query().Get("client")
+	a.Breakers.Reset(clientID)
+	klog.Infof("reset circuit breakers of client[%s]", clientID)
+	a.getBreakers(rw, req)
+}
+
+// Client is the response of /api/v1/clients/{id}, /api/v1/clients returns a list of them
+type Client struct {
+	ClientID string          `json:"clientID"`
+	Sessions []ClientSession `json:"sessions"`
+}
+
+// ClientSession is a session of the client and the host owning it
+type ClientSession struct {
+	Host          string            `json:"host"`
+	Local         bool              `json:"local"`
+	ConnectedAt   time.Time         `json:"connectedAt"`
+	RemoteAddress string            `json:"remoteAddress"`
+	AgentVersion  string            `json:"agentVersion,omitempty"`
+	Hostname      string            `json:"hostname,omitempty"`
+	OS            string            `json:"os,omitempty"`
+	Arch          string            `json:"arch,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	CIDRs         []string          `json:"cidrs,omitempty"`
+	// Health is set for the sessions probed by their host
+	Health *hostv1.ClientHealth `json:"health,omitempty"`
+}
+
+func newClientSession(host string, local bool, s hostv1.ClientSession) ClientSession {
+	return ClientSession{
+		Host:          host,
+		Local:         local,
+		ConnectedAt:   s.ConnectedAt.Time,
+		RemoteAddress: s.RemoteAddress,
+		AgentVersion:  s.AgentVersion,
+		Hostname:      s.Hostname,
+		OS:            s.OS,
+		Arch:          s.Arch,
+		Labels:        s.Labels,
+		CIDRs:         s.CIDRs,
+		Health:        s.Health,
+	}
+}
+
+func (a *API) getClient(rw http.ResponseWriter, req *http.Request) {
+	clientID := mux.Vars(req)["id"]
+	var remote []discovery.PeerSession
+	if a.directory != nil {
+		var err error
+		if remote, err = a.directory.Sessions(clientID); err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	clients := a.clients(a.registry.Sessions(clientID), remote)
+	if len(clients) == 0 {
+		writeError(rw, http.StatusNotFound, fmt.Errorf("client %s is not connected", clientID))
+		return
+	}
+	writeJSON(rw, http.StatusOK, clients[0])
+}
+
+// listClients serves /api/v1/clients, optionally only the clients with sessions matching the label selector
+func (a *API) listClients(rw http.ResponseWriter, req *http.Request) {
+	selector, err := labels.Parse(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	var remote []discovery.PeerSession
+	if a.directory != nil {
+		if remote, err = a.directory.Select(selector); err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSON(rw, http.StatusOK, a.clients(a.registry.Select(selector), remote))
+}
+
+// clients groups the local and remote sessions by client, sorted by client id. The local sessions
+// are up to date, the directory may lag behind for them, so its sessions of this host are skipped.
+func (a *API) clients(local []session.Session, remote []discovery.PeerSession) []Client {
+	byID := map[string]*Client{}
+	add := func(clientID string, s ClientSession) {
+		client, ok := byID[clientID]
+		if !ok {
+			client = &Client{ClientID: clientID}
+			byID[clientID] = client
+		}
+		client.Sessions = append(client.Sessions, s)
+	}
+	for _, s := range local {
+		add(s.ClientID, newClientSession(a.server.PeerID, true, s.Status()))
+	}
+	for _, s := range remote {
+		if s.Peer.ID != a.server.PeerID {
+			add(s.Session.ClientID, newClientSession(s.Peer.ID, false, s.Session))
+		}
+	}
+
+	clients := make([]Client, 0, len(byID))
+	for _, client := range byID {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ClientID < clients[j].ClientID })
+	return clients
+}
+
+func writeJSON(rw http.ResponseWriter, code int, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(obj); err != nil {
+		klog.Errorf("write api response fail:%s", err.Error())
+	}
+}
+
+func writeError(rw http.ResponseWriter, code int, err error) {
+	writeJSON(rw, code, map[string]string{"error": err.Error()})
+}
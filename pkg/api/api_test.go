@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/breaker"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/session"
+	"hostmanager/pkg/session/sessiontest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// directory is a static client directory
+type directory []discovery.PeerSession
+
+func (d directory) PublishSessions(peer discovery.Peer, sessions []hostv1.ClientSession) error {
+	return nil
+}
+
+func (d directory) Owners(clientID string) ([]discovery.Peer, error) {
+	var owners []discovery.Peer
+	for _, s := range d {
+		if s.Session.ClientID == clientID {
+			owners = append(owners, s.Peer)
+		}
+	}
+	return owners, nil
+}
+
+func (d directory) Sessions(clientID string) ([]discovery.PeerSession, error) {
+	var sessions []discovery.PeerSession
+	for _, s := range d {
+		if s.Session.ClientID == clientID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (d directory) Select(selector labels.Selector) ([]discovery.PeerSession, error) {
+	var sessions []discovery.PeerSession
+	for _, s := range d {
+		if selector.Matches(labels.Set(s.Session.Labels)) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func TestGetClient(t *testing.T) {
+	const self, other = "10.0.0.1:8123", "10.0.0.2:8123"
+	server := sessiontest.NewServer(t)
+	server.PeerID = self
+	remote := func(host, clientID string) discovery.PeerSession {
+		return discovery.PeerSession{
+			Peer:    discovery.NewPeer(host, ""),
+			Session: hostv1.ClientSession{ClientID: clientID, ConnectedAt: metav1.Now(), RemoteAddress: "192.168.0.9:40000"},
+		}
+	}
+	a := New(server.Server, server.Registry, directory{
+		// the directory entry of this host lags behind, the registry is used instead
+		remote(self, "foo"),
+		remote(other, "foo"),
+		remote(other, "bar"),
+	}, nil)
+	router := mux.NewRouter()
+	a.Register(router)
+	server.Start(router)
+	server.Connect("foo", http.Header{session.LABELS_HEADER: []string{"site=berlin"}})
+
+	get := func(id string) (int, Client) {
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/v1/clients/"+id, nil))
+		var client Client
+		if rw.Code == http.StatusOK {
+			if err := json.NewDecoder(rw.Body).Decode(&client); err != nil {
+				t.Fatalf("decode client %s: %v", id, err)
+			}
+		}
+		return rw.Code, client
+	}
+
+	code, client := get("foo")
+	if code != http.StatusOK || client.ClientID != "foo" || len(client.Sessions) != 2 {
+		t.Fatalf("local client: %d %+v", code, client)
+	}
+	local, peer := client.Sessions[0], client.Sessions[1]
+	if !local.Local || local.Host != self || local.Labels["site"] != "berlin" || local.RemoteAddress == "" {
+		t.Errorf("unexpected local session %+v", local)
+	}
+	if peer.Local || peer.Host != other {
+		t.Errorf("unexpected remote session %+v", peer)
+	}
+
+	code, client = get("bar")
+	if code != http.StatusOK || len(client.Sessions) != 1 || client.Sessions[0].Local || client.Sessions[0].Host != other || client.Sessions[0].RemoteAddress != "192.168.0.9:40000" {
+		t.Errorf("remote client: %d %+v", code, client)
+	}
+
+	if code, _ := get("baz"); code != http.StatusNotFound {
+		t.Errorf("unknown client: expected %d, got %d", http.StatusNotFound, code)
+	}
+
+	// without a directory only the local sessions are known
+	a.directory = nil
+	if code, client := get("foo"); code != http.StatusOK || len(client.Sessions) != 1 || !client.Sessions[0].Local {
+		t.Errorf("local client without directory: %d %+v", code, client)
+	}
+	if code, _ := get("bar"); code != http.StatusNotFound {
+		t.Errorf("remote client without directory: expected %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+// host records the cordon calls
+type host struct {
+	cordoned bool
+}
+
+func (h *host) Cordon(cordoned bool) error {
+	h.cordoned = cordoned
+	return nil
+}
+
+func (h *host) Hosts() []discovery.Peer { return nil }
+
+func TestAdminRoutes(t *testing.T) {
+	server := sessiontest.NewServer(t)
+	h := &host{}
+	a := New(server.Server, server.Registry, nil, h)
+	public, admin := mux.NewRouter(), mux.NewRouter()
+	a.Register(public)
+	a.RegisterAdmin(admin)
+
+	request := func(method string, router *mux.Router, path string) int {
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(method, path, nil))
+		return rw.Code
+	}
+	post := func(router *mux.Router, path string) int { return request(http.MethodPost, router, path) }
+	if code := post(public, "/api/v1/admin/cordon"); code == http.StatusOK || h.cordoned {
+		t.Fatalf("cordon served on the public router: %d", code)
+	}
+	if code := post(admin, "/api/v1/admin/cordon"); code != http.StatusOK || !h.cordoned {
+		t.Fatalf("cordon on the admin router: %d", code)
+	}
+	if code := post(admin, "/api/v1/admin/uncordon"); code != http.StatusOK || h.cordoned {
+		t.Fatalf("uncordon on the admin router: %d", code)
+	}
+
+	a.Breakers = breaker.NewBreakers(breaker.DefaultOptions())
+	done, _, err := a.Breakers.Allow("foo", "10.0.0.1:80")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	done(200, nil)
+	// the circuits name the clients and their targets
+	if code := request(http.MethodGet, public, "/api/v1/admin/breakers"); code == http.StatusOK {
+		t.Fatalf("breakers served on the public router: %d", code)
+	}
+	if code := request(http.MethodGet, admin, "/api/v1/admin/breakers"); code != http.StatusOK {
+		t.Fatalf("breakers on the admin router: %d", code)
+	}
+	if code := post(public, "/api/v1/admin/breakers/reset"); code == http.StatusOK || len(a.Breakers.States()) == 0 {
+		t.Fatalf("breakers reset served on the public router: %d", code)
+	}
+	if code := post(admin, "/api/v1/admin/breakers/reset?client=foo"); code != http.StatusOK || len(a.Breakers.States()) != 0 {
+		t.Fatalf("breakers reset on the admin router: %d %+v", code, a.Breakers.States())
+	}
+}
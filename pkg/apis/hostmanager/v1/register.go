@@ -31,6 +31,14 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		SchemeGroupVersion,
 		&Host{},
 		&HostList{},
+		&TunnelListener{},
+		&TunnelListenerList{},
+		&TunnelService{},
+		&TunnelServiceList{},
+		&TunnelRoute{},
+		&TunnelRouteList{},
+		&ConnectivityCheck{},
+		&ConnectivityCheckList{},
 	)
 
 	// register the type in the scheme
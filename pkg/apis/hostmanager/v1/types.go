@@ -10,7 +10,8 @@ import (
 type Host struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              HostSpec `json:"spec"`
+	Spec              HostSpec   `json:"spec"`
+	Status            HostStatus `json:"status,omitempty"`
 }
 
 type HostSpec struct {
@@ -18,6 +19,74 @@ type HostSpec struct {
 	HostStatus  string `json:"hostStatus"`
 	HostInfo    string `json:"hostInfo"`
 	HostToken   string `json:"hostToken"`
+	// Cordoned hosts refuse new agent sessions and peers stop routing new requests to them,
+	// existing sessions keep working
+	Cordoned bool `json:"cordoned,omitempty"`
+}
+
+// HostStatus is published by the hostmanager of the Host, other hosts use it as a directory
+type HostStatus struct {
+	// Clients are the ids of the tunnel clients connected to this host
+	Clients []string `json:"clients,omitempty"`
+	// Sessions are the tunnel client sessions on this host
+	Sessions []ClientSession `json:"sessions,omitempty"`
+}
+
+// ClientSession is a tunnel client session
+type ClientSession struct {
+	ClientID      string      `json:"clientID"`
+	ConnectedAt   metav1.Time `json:"connectedAt"`
+	RemoteAddress string      `json:"remoteAddress"`
+	AgentVersion  string      `json:"agentVersion,omitempty"`
+	// metadata reported by the agent on connect
+	Hostname string            `json:"hostname,omitempty"`
+	OS       string            `json:"os,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	CIDRs    []string          `json:"cidrs,omitempty"`
+	// Cluster is the id of the kubernetes cluster the agent runs in and proxies the API server of
+	Cluster string `json:"cluster,omitempty"`
+	// Allow are the [proto:]address rules of the dials the agent allows, all but unix sockets when empty
+	Allow []string `json:"allow,omitempty"`
+	// UDPRelay is the address of the udp relay of the agent, which keeps the datagram boundaries
+	UDPRelay string `json:"udpRelay,omitempty"`
+	// Usage is the use of the client through this hostmanager, set when it was used
+	Usage *ClientUsage `json:"usage,omitempty"`
+	// Health is the result of the probes of the session, set when the agent answers them
+	Health *ClientHealth `json:"health,omitempty"`
+}
+
+// ClientHealth is the result of the pings of a client session by its hostmanager, and of the probes of
+// the targets the agent declared
+type ClientHealth struct {
+	// State is healthy or degraded
+	State string `json:"state"`
+	// RTT is the round trip time of the last answered ping
+	RTT metav1.Duration `json:"rtt"`
+	// Loss is the percentage of the recent pings not answered
+	Loss        int32          `json:"loss"`
+	LastSuccess *metav1.Time   `json:"lastSuccess,omitempty"`
+	Targets     []TargetHealth `json:"targets,omitempty"`
+}
+
+// TargetHealth is the result of the probes of a target through the client
+type TargetHealth struct {
+	Target      string       `json:"target"`
+	Healthy     bool         `json:"healthy"`
+	Error       string       `json:"error,omitempty"`
+	LastSuccess *metav1.Time `json:"lastSuccess,omitempty"`
+}
+
+// ClientUsage counts the streams through a client since hostmanager started
+type ClientUsage struct {
+	// Streams are in progress
+	Streams  int32 `json:"streams"`
+	Requests int64 `json:"requests"`
+	// Rejected streams reached a limit
+	Rejected int64 `json:"rejected"`
+	Bytes    int64 `json:"bytes"`
+	// Limits are the limits enforced on the client, e.g. streams=10,rate=5
+	Limits string `json:"limits,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -30,7 +99,219 @@ type HostList struct {
 	Items []Host `json:"items"`
 }
 
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelListener opens a port on hostmanager, whose connections are forwarded through a tunnel client
+type TunnelListener struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              TunnelListenerSpec `json:"spec"`
+}
+
+type TunnelListenerSpec struct {
+	// Port is the port hostmanager listens on
+	Port int32 `json:"port"`
+	// Protocol is TCP, the default, or UDP
+	Protocol string `json:"protocol,omitempty"`
+	// ClientID is the tunnel client the connections go through
+	ClientID string `json:"clientID"`
+	// Target is the host:port the tunnel client connects to, or a unix:///path socket
+	Target string `json:"target"`
+	// Host is the address of the hostmanager listening, all of them when empty
+	Host string `json:"host,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelListenerList is a list of TunnelListener resources
+type TunnelListenerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TunnelListener `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelService is a remote service reached through a tunnel client, hostmanager reconciles it into a
+// Service with the same name whose Endpoints are the hostmanagers listening for it
+type TunnelService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              TunnelServiceSpec   `json:"spec"`
+	Status            TunnelServiceStatus `json:"status,omitempty"`
+}
+
+type TunnelServiceSpec struct {
+	// ClientID is the tunnel client the connections go through
+	ClientID string `json:"clientID"`
+	// Target is the host:port the tunnel client connects to
+	Target string `json:"target"`
+	// Port is the port of the Service
+	Port int32 `json:"port"`
+	// Protocol is TCP, the default, or UDP
+	Protocol string `json:"protocol,omitempty"`
+}
+
+type TunnelServiceStatus struct {
+	// ListenerPort is allocated by hostmanager, every hostmanager listens on it and it is the Endpoints port
+	ListenerPort int32 `json:"listenerPort,omitempty"`
+	// Hosts are the ids of the hostmanagers with the listener open, the schedulable ones are the Endpoints
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelServiceList is a list of TunnelService resources
+type TunnelServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TunnelService `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelRoute serves the requests for a host and path prefix on hostmanager with a target reached
+// through a tunnel client
+type TunnelRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              TunnelRouteSpec   `json:"spec"`
+	Status            TunnelRouteStatus `json:"status,omitempty"`
+}
+
+type TunnelRouteSpec struct {
+	// Host is the Host header of the requests, any host when empty
+	Host string `json:"host,omitempty"`
+	// PathPrefix is the path prefix of the requests, / when empty
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// ClientID is the tunnel client the requests go through, or else one matching Selector
+	ClientID string `json:"clientID,omitempty"`
+	// Selector is a label selector of the tunnel clients, e.g. site=berlin
+	Selector string `json:"selector,omitempty"`
+	// Target is the url the tunnel client sends the requests to, e.g. http://10.0.0.5:8080/app, or the
+	// unix:///path socket it sends them over
+	Target string `json:"target"`
+	// Rewrite replaces PathPrefix in the path, the path is appended to the path of Target
+	Rewrite string `json:"rewrite,omitempty"`
+	// RequestHeaders are applied to the requests, ResponseHeaders to the responses
+	RequestHeaders  HeaderRules `json:"requestHeaders,omitempty"`
+	ResponseHeaders HeaderRules `json:"responseHeaders,omitempty"`
+	// Timeout bounds the request, e.g. 30s, no bound when empty
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// DialTimeout, ResponseHeaderTimeout and IdleTimeout override the ones of hostmanager: the dial of
+	// Target, the wait for the response headers and the time without bytes on the connection to Target
+	DialTimeout           *metav1.Duration `json:"dialTimeout,omitempty"`
+	ResponseHeaderTimeout *metav1.Duration `json:"responseHeaderTimeout,omitempty"`
+	IdleTimeout           *metav1.Duration `json:"idleTimeout,omitempty"`
+}
+
+// HeaderRules set and remove headers
+type HeaderRules struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+type TunnelRouteStatus struct {
+	// Connected is true when a tunnel client of the route is connected to a hostmanager
+	Connected bool `json:"connected"`
+	// Message is why the route is not served or not connected
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TunnelRouteList is a list of TunnelRoute resources
+type TunnelRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TunnelRoute `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ConnectivityCheck is a synthetic check of a target through tunnel clients, run every Interval by the
+// hostmanagers the clients are connected to
+type ConnectivityCheck struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ConnectivityCheckSpec   `json:"spec"`
+	Status            ConnectivityCheckStatus `json:"status,omitempty"`
+}
+
+type ConnectivityCheckSpec struct {
+	// ClientID is the tunnel client the check goes through, or else every client matching Selector
+	ClientID string `json:"clientID,omitempty"`
+	// Selector is a label selector of the tunnel clients, e.g. site=berlin
+	Selector string `json:"selector,omitempty"`
+	// Target is a http(s) url, e.g. http://10.0.0.5:8080/health, or a tcp://host:port
+	Target string `json:"target"`
+	// Interval between the checks, 1m when empty
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Timeout bounds a check, 10s when empty
+	Timeout *metav1.Duration  `json:"timeout,omitempty"`
+	Expect  CheckExpectations `json:"expect,omitempty"`
+}
+
+// CheckExpectations are what a check has to meet to pass
+type CheckExpectations struct {
+	// StatusCode of a http target, any below 400 when 0
+	StatusCode int32 `json:"statusCode,omitempty"`
+	// BodyRegex has to match the body of a http target
+	BodyRegex string `json:"bodyRegex,omitempty"`
+	// MaxLatency bounds the time to the response headers of a http target, to the first byte of a tcp one
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+}
+
+type ConnectivityCheckStatus struct {
+	// State is Passing when the check passed through all the clients, Failing when it failed through one,
+	// Unknown when no client is connected or the spec is invalid
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Clients are the results of the last check through every client
+	Clients []CheckResult `json:"clients,omitempty"`
+	// History are the last results, newest first
+	History []CheckResult `json:"history,omitempty"`
+}
+
+// CheckResult is the result of a check through a client, by the hostmanager it is connected to
+type CheckResult struct {
+	ClientID   string          `json:"clientID"`
+	Host       string          `json:"host"`
+	Time       metav1.Time     `json:"time"`
+	Passed     bool            `json:"passed"`
+	Latency    metav1.Duration `json:"latency"`
+	StatusCode int32           `json:"statusCode,omitempty"`
+	Message    string          `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ConnectivityCheckList is a list of ConnectivityCheck resources
+type ConnectivityCheckList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ConnectivityCheck `json:"items"`
+}
+
+const (
+	// the states of a ConnectivityCheck
+	Passing = "Passing"
+	Failing = "Failing"
+	Unknown = "Unknown"
+)
+
 const (
 	Available   = "Available"
 	UnAvailable = "UnAvailable"
+	// Draining hosts are shutting down, they refuse new agent sessions
+	Draining = "Draining"
 )
@@ -0,0 +1,320 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// the states of a circuit: closed lets the requests through, open fails them fast, half-open lets
+	// one probe through to decide whether to close again
+	CLOSED    = "closed"
+	OPEN      = "open"
+	HALF_OPEN = "half-open"
+
+	WINDOW       = 30 * time.Second
+	MIN_REQUESTS = 10
+	ERROR_RATE   = 0.5
+	OPEN_TIMEOUT = 10 * time.Second
+)
+
+// Options are the thresholds of the circuits
+type Options struct {
+	// Window is the period the error rate is measured over
+	Window time.Duration
+	// MinRequests are needed in a window before the circuit opens
+	MinRequests int
+	// ErrorRate of the requests of a window opens the circuit
+	ErrorRate float64
+	// SlowLatency counts the requests slower than it as failures, none if 0
+	SlowLatency time.Duration
+	// OpenTimeout is the time the circuit stays open before a probe is let through
+	OpenTimeout time.Duration
+}
+
+// DefaultOptions returns the default thresholds
+func DefaultOptions() Options {
+	return Options{
+		Window:      WINDOW,
+		MinRequests: MIN_REQUESTS,
+		ErrorRate:   ERROR_RATE,
+		OpenTimeout: OPEN_TIMEOUT,
+	}
+}
+
+// OpenError is returned for a request whose circuit is open
+type OpenError struct {
+	ClientID string
+	// Target is empty when the circuit of the whole client is open
+	Target    string
+	Failures  int
+	Requests  int
+	LastError string
+	// RetryAfter is the time until a probe is let through
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	what := "client " + e.ClientID
+	if e.Target != "" {
+		what += " target " + e.Target
+	}
+	return fmt.Sprintf("circuit of %s open, %d of %d requests failed, last error: %s, retry in %s",
+		what, e.Failures, e.Requests, e.LastError, e.RetryAfter.Round(time.Second))
+}
+
+// State is the state of a circuit, served by the admin api
+type State struct {
+	ClientID string `json:"clientID"`
+	// Target is empty for the circuit of the whole client
+	Target    string     `json:"target,omitempty"`
+	State     string     `json:"state"`
+	Requests  int        `json:"requests"`
+	Failures  int        `json:"failures"`
+	Latency   string     `json:"latency"`
+	LastError string     `json:"lastError,omitempty"`
+	OpenedAt  *time.Time `json:"openedAt,omitempty"`
+}
+
+// Breakers keep a circuit for every tunnel client and for every target through it. The circuit of a
+// target counts the errors, 5xx and slow responses, the one of a client only the timeouts of all its
+// targets, as a stalled agent or tunnel times out on any of them while a refused dial is the target's.
+type Breakers struct {
+	opts Options
+
+	sync.Mutex
+	circuits map[key]*circuit
+	// evicted is the last time the idle circuits were evicted
+	evicted time.Time
+}
+
+type key struct {
+	clientID string
+	target   string
+}
+
+type circuit struct {
+	state string
+	// the requests of the window started at windowStart
+	windowStart time.Time
+	requests    int
+	failures    int
+	latency     time.Duration
+	lastError   string
+	openedAt    time.Time
+	// probing is set while the probe of a half-open circuit is in progress
+	probing bool
+	// lastUsed is the time of the last result
+	lastUsed time.Time
+}
+
+func NewBreakers(opts Options) *Breakers {
+	return &Breakers{opts: opts, circuits: map[key]*circuit{}}
+}
+
+// Allow checks the circuits of clientID and of target through it. done reports the result of the
+// request, which failed when err is not nil or code is 5xx. release lets a request that was not sent go
+// without a result, a half-open circuit lets the next probe through; it does nothing once done was
+// called, so it can be deferred. An open circuit returns an *OpenError.
+func (b *Breakers) Allow(clientID, target string) (done func(code int, err error), release func(), err error) {
+	now := time.Now()
+	b.Lock()
+	defer b.Unlock()
+	b.evict(now)
+	keys := []key{{clientID, ""}, {clientID, target}}
+	for _, k := range keys {
+		if err := b.allow(k, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	// the probes of half-open circuits are this request
+	for _, k := range keys {
+		if c := b.circuits[k]; c != nil && c.state == HALF_OPEN {
+			c.probing = true
+		}
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			b.Lock()
+			defer b.Unlock()
+			for _, k := range keys {
+				if c := b.circuits[k]; c != nil && c.state == HALF_OPEN {
+					c.probing = false
+				}
+			}
+		})
+	}
+	done = func(code int, err error) {
+		once.Do(func() {
+			latency := time.Since(now)
+			message := ""
+			switch {
+			case err != nil:
+				message = err.Error()
+			case code >= 500:
+				message = fmt.Sprintf("status %d", code)
+			case b.opts.SlowLatency > 0 && latency > b.opts.SlowLatency:
+				message = fmt.Sprintf("slow response in %s", latency.Round(time.Millisecond))
+			}
+			clientMessage := ""
+			if timeout(err) {
+				clientMessage = message
+			}
+			b.Lock()
+			defer b.Unlock()
+			b.record(keys[0], latency, clientMessage)
+			b.record(keys[1], latency, message)
+		})
+	}
+	return done, release, nil
+}
+
+// evict forgets the closed circuits without a request for a window, with the lock held. A circuit is
+// kept for every target ever requested otherwise.
+func (b *Breakers) evict(now time.Time) {
+	if now.Sub(b.evicted) < b.opts.Window {
+		return
+	}
+	b.evicted = now
+	for k, c := range b.circuits {
+		if c.state == CLOSED && now.Sub(c.lastUsed) >= b.opts.Window {
+			delete(b.circuits, k)
+		}
+	}
+}
+
+// timeout is true for net.Error timeouts and exceeded deadlines
+func timeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// allow returns an *OpenError if the circuit of k is open, or half-open with its probe in progress
+func (b *Breakers) allow(k key, now time.Time) error {
+	c := b.circuits[k]
+	if c == nil {
+		return nil
+	}
+	if c.state == OPEN && now.Sub(c.openedAt) >= b.opts.OpenTimeout {
+		c.state, c.probing = HALF_OPEN, false
+	}
+	if c.state == CLOSED || (c.state == HALF_OPEN && !c.probing) {
+		return nil
+	}
+	retry := b.opts.OpenTimeout - now.Sub(c.openedAt)
+	if retry < 0 {
+		retry = 0
+	}
+	return &OpenError{
+		ClientID:   k.clientID,
+		Target:     k.target,
+		Failures:   c.failures,
+		Requests:   c.requests,
+		LastError:  c.lastError,
+		RetryAfter: retry,
+	}
+}
+
+// record counts the result of a request, failed when message is set, with the lock held
+func (b *Breakers) record(k key, latency time.Duration, message string) {
+	now := time.Now()
+	c := b.circuits[k]
+	if c == nil {
+		c = &circuit{state: CLOSED, windowStart: now}
+		b.circuits[k] = c
+	}
+	if c.state == CLOSED && now.Sub(c.windowStart) >= b.opts.Window {
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	}
+	c.requests++
+	c.lastUsed = now
+	// the latency is smoothed over the last requests
+	if c.latency == 0 {
+		c.latency = latency
+	} else {
+		c.latency = (c.latency*7 + latency) / 8
+	}
+	if message != "" {
+		c.failures++
+		c.lastError = message
+	}
+
+	switch c.state {
+	case HALF_OPEN:
+		if !c.probing {
+			return
+		}
+		if message != "" {
+			c.state, c.openedAt, c.probing = OPEN, now, false
+			return
+		}
+		c.state, c.probing = CLOSED, false
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	case CLOSED:
+		if c.requests >= b.opts.MinRequests && float64(c.failures) >= b.opts.ErrorRate*float64(c.requests) {
+			c.state, c.openedAt = OPEN, now
+		}
+	}
+}
+
+// Reset closes the circuits of clientID, of all clients if empty
+func (b *Breakers) Reset(clientID string) {
+	b.Lock()
+	defer b.Unlock()
+	for k := range b.circuits {
+		if clientID == "" || k.clientID == clientID {
+			delete(b.circuits, k)
+		}
+	}
+}
+
+// States returns the state of the circuits, the open ones first
+func (b *Breakers) States() []State {
+	now := time.Now()
+	b.Lock()
+	var states []State
+	for k, c := range b.circuits {
+		state := c.state
+		if state == OPEN && now.Sub(c.openedAt) >= b.opts.OpenTimeout {
+			state = HALF_OPEN
+		}
+		s := State{
+			ClientID:  k.clientID,
+			Target:    k.target,
+			State:     state,
+			Requests:  c.requests,
+			Failures:  c.failures,
+			Latency:   c.latency.Round(time.Millisecond).String(),
+			LastError: c.lastError,
+		}
+		if state != CLOSED {
+			openedAt := c.openedAt
+			s.OpenedAt = &openedAt
+		}
+		states = append(states, s)
+	}
+	b.Unlock()
+	rank := map[string]int{OPEN: 0, HALF_OPEN: 1, CLOSED: 2}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].State != states[j].State {
+			return rank[states[i].State] < rank[states[j].State]
+		}
+		if states[i].ClientID != states[j].ClientID {
+			return states[i].ClientID < states[j].ClientID
+		}
+		return states[i].Target < states[j].Target
+	})
+	return states
+}
@@ -0,0 +1,149 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreakers(t *testing.T) {
+	b := NewBreakers(Options{Window: time.Minute, MinRequests: 4, ErrorRate: 0.5, OpenTimeout: 50 * time.Millisecond})
+
+	// 5xx open the circuit of the target only
+	for i := 0; i < 4; i++ {
+		done, _, err := b.Allow("foo", "10.0.0.1:80")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if i%2 == 0 {
+			done(500, nil)
+		} else {
+			done(200, nil)
+		}
+	}
+	_, _, err := b.Allow("foo", "10.0.0.1:80")
+	open, ok := err.(*OpenError)
+	if !ok || open.Target != "10.0.0.1:80" || open.Failures != 2 || open.Requests != 4 || open.LastError != "status 500" {
+		t.Fatalf("expected the circuit of the target open, got %v", err)
+	}
+	if done, _, err := b.Allow("foo", "10.0.0.2:80"); err != nil {
+		t.Fatalf("other target: %v", err)
+	} else {
+		done(200, nil)
+	}
+	if states := b.States(); len(states) != 3 || states[0].State != OPEN || states[0].OpenedAt == nil {
+		t.Errorf("unexpected states %+v", states)
+	}
+
+	// after the open timeout one probe is let through, its success closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	probe, _, err := b.Allow("foo", "10.0.0.1:80")
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if _, _, err := b.Allow("foo", "10.0.0.1:80"); err == nil {
+		t.Errorf("expected a single probe")
+	}
+	probe(200, nil)
+	if done, _, err := b.Allow("foo", "10.0.0.1:80"); err != nil {
+		t.Fatalf("closed: %v", err)
+	} else {
+		done(200, nil)
+	}
+
+	// timeouts open the circuit of the whole client, other errors only the one of their target
+	for i := 0; i < 4; i++ {
+		done, _, err := b.Allow("bar", "10.0.0.1:80")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		done(0, errors.New("connection refused"))
+	}
+	if done, _, err := b.Allow("bar", "10.0.0.2:80"); err != nil {
+		t.Fatalf("refused dials opened the client: %v", err)
+	} else {
+		done(200, nil)
+	}
+	for i := 0; i < 5; i++ {
+		done, _, err := b.Allow("bar", fmt.Sprintf("10.0.1.%d:80", i))
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		done(0, context.DeadlineExceeded)
+	}
+	if _, _, err := b.Allow("bar", "10.0.0.9:80"); err == nil || err.(*OpenError).Target != "" {
+		t.Errorf("expected the circuit of the client open, got %v", err)
+	}
+	b.Reset("bar")
+	if _, _, err := b.Allow("bar", "10.0.0.1:80"); err != nil {
+		t.Errorf("reset: %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	b := NewBreakers(Options{Window: time.Minute, MinRequests: 2, ErrorRate: 0.5, OpenTimeout: 10 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		done, _, err := b.Allow("foo", "10.0.0.1:80")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		done(502, nil)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// a probe released without a result neither closes nor keeps the circuit busy
+	_, release, err := b.Allow("foo", "10.0.0.1:80")
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	release()
+	done, release, err := b.Allow("foo", "10.0.0.1:80")
+	if err != nil {
+		t.Fatalf("the released probe should let the next one through: %v", err)
+	}
+	if states := b.States(); states[0].Target != "10.0.0.1:80" || states[0].State != HALF_OPEN || states[0].Requests != 2 {
+		t.Errorf("release recorded a result: %+v", states)
+	}
+	done(200, nil)
+	// deferred after done it changes nothing
+	release()
+	if _, _, err := b.Allow("foo", "10.0.0.1:80"); err != nil {
+		t.Errorf("expected the circuit closed by the probe: %v", err)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	b := NewBreakers(Options{Window: 20 * time.Millisecond, MinRequests: 2, ErrorRate: 0.5, OpenTimeout: time.Minute})
+	for _, target := range []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.2:80"} {
+		done, _, err := b.Allow("foo", target)
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if target == "10.0.0.2:80" {
+			done(500, nil)
+		} else {
+			done(200, nil)
+		}
+	}
+	if states := b.States(); len(states) != 3 {
+		t.Fatalf("expected the client and 2 target circuits, got %+v", states)
+	}
+
+	// the idle closed circuits are forgotten on the next request after a window, the open one stays
+	time.Sleep(30 * time.Millisecond)
+	done, _, err := b.Allow("bar", "10.0.0.3:80")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	done(200, nil)
+	var got []string
+	for _, s := range b.States() {
+		got = append(got, s.ClientID+"/"+s.Target+"/"+s.State)
+	}
+	want := []string{"foo/10.0.0.2:80/open", "bar//closed", "bar/10.0.0.3:80/closed"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,220 @@
+package connectivitycheck
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/session"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	INTERVAL = time.Minute
+	TIMEOUT  = 10 * time.Second
+	// MAX_BODY is the most of a http response body the body regex is matched against
+	MAX_BODY = 1 << 20
+)
+
+// check is a parsed ConnectivityCheck
+type check struct {
+	clientID   string
+	selector   labels.Selector
+	target     *url.URL
+	interval   time.Duration
+	timeout    time.Duration
+	statusCode int
+	body       *regexp.Regexp
+	maxLatency time.Duration
+}
+
+func parse(cc *hostv1.ConnectivityCheck) (*check, error) {
+	spec := cc.Spec
+	chk := &check{clientID: spec.ClientID, interval: INTERVAL, timeout: TIMEOUT, statusCode: int(spec.Expect.StatusCode)}
+	if (spec.ClientID == "") == (spec.Selector == "") {
+		return nil, fmt.Errorf("one of clientID and selector is required")
+	}
+	if spec.Selector != "" {
+		selector, err := labels.Parse(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %s: %s", spec.Selector, err.Error())
+		}
+		chk.selector = selector
+	}
+	target, err := url.Parse(spec.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s: %s", spec.Target, err.Error())
+	}
+	switch target.Scheme {
+	case "http", "https":
+	case "tcp":
+		if _, _, err := net.SplitHostPort(target.Host); err != nil {
+			return nil, fmt.Errorf("target %s is not a tcp://host:port", spec.Target)
+		}
+	default:
+		return nil, fmt.Errorf("target %s is not a http(s) url or a tcp://host:port", spec.Target)
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("target %s has no host", spec.Target)
+	}
+	chk.target = target
+	if target.Scheme == "tcp" && (spec.Expect.StatusCode != 0 || spec.Expect.BodyRegex != "") {
+		return nil, fmt.Errorf("statusCode and bodyRegex expect a http target")
+	}
+	if spec.Expect.StatusCode != 0 && (spec.Expect.StatusCode < 100 || spec.Expect.StatusCode > 599) {
+		return nil, fmt.Errorf("invalid statusCode %d", spec.Expect.StatusCode)
+	}
+	if spec.Expect.BodyRegex != "" {
+		if chk.body, err = regexp.Compile(spec.Expect.BodyRegex); err != nil {
+			return nil, fmt.Errorf("invalid bodyRegex %s: %s", spec.Expect.BodyRegex, err.Error())
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value *metav1.Duration
+		d     *time.Duration
+	}{
+		{"interval", spec.Interval, &chk.interval},
+		{"timeout", spec.Timeout, &chk.timeout},
+		{"maxLatency", spec.Expect.MaxLatency, &chk.maxLatency},
+	} {
+		if d.value == nil {
+			continue
+		}
+		if d.value.Duration <= 0 {
+			return nil, fmt.Errorf("%s %s is not positive", d.name, d.value.Duration)
+		}
+		*d.d = d.value.Duration
+	}
+	if chk.timeout >= chk.interval {
+		return nil, fmt.Errorf("timeout %s is not below the interval %s", chk.timeout, chk.interval)
+	}
+	return chk, nil
+}
+
+// address returns the host:port the check dials through the client
+func (chk *check) address() string {
+	if chk.target.Port() != "" {
+		return chk.target.Host
+	}
+	if chk.target.Scheme == "https" {
+		return net.JoinHostPort(chk.target.Hostname(), "443")
+	}
+	return net.JoinHostPort(chk.target.Hostname(), "80")
+}
+
+// run checks the target through the client of dial, tcp dials are confirmed by its udp relay when it has one
+func (chk *check) run(clientID string, dial remotedialer.Dialer, relay string) hostv1.CheckResult {
+	result := hostv1.CheckResult{ClientID: clientID}
+	var latency time.Duration
+	var err error
+	if chk.target.Scheme == "tcp" {
+		latency, err = chk.tcp(dial, relay)
+	} else {
+		var code int
+		latency, code, err = chk.http(dial)
+		result.StatusCode = int32(code)
+	}
+	if err == nil && chk.maxLatency > 0 && latency > chk.maxLatency {
+		err = fmt.Errorf("latency %s above %s", latency.Round(time.Millisecond), chk.maxLatency)
+	}
+	result.Latency.Duration = latency.Round(time.Millisecond)
+	result.Passed = err == nil
+	if err != nil {
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// http gets the target, latency is the time to the response headers
+func (chk *check) http(dial remotedialer.Dialer) (time.Duration, int, error) {
+	client := &http.Client{
+		Transport: &http.Transport{Dial: dial, DisableKeepAlives: true},
+		Timeout:   chk.timeout,
+		// a redirect is a response of the target
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	start := time.Now()
+	resp, err := client.Get(chk.target.String())
+	if err != nil {
+		return time.Since(start), 0, err
+	}
+	latency := time.Since(start)
+	defer resp.Body.Close()
+
+	if chk.statusCode != 0 && resp.StatusCode != chk.statusCode {
+		return latency, resp.StatusCode, fmt.Errorf("status %d, expected %d", resp.StatusCode, chk.statusCode)
+	}
+	if chk.statusCode == 0 && resp.StatusCode >= 400 {
+		return latency, resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if chk.body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_BODY))
+		if err != nil {
+			return latency, resp.StatusCode, fmt.Errorf("read body: %s", err.Error())
+		}
+		if !chk.body.Match(body) {
+			return latency, resp.StatusCode, fmt.Errorf("body does not match %s", chk.body.String())
+		}
+	}
+	return latency, resp.StatusCode, nil
+}
+
+// tcp dials the target through the agent. remotedialer reports failed dials only, so the udp relay of the
+// agent is asked to confirm the dial, latency is the time to its answer. Without relay the target is up when it
+// sent data or closed the connection within the timeout, latency is the time to that.
+func (chk *check) tcp(dial remotedialer.Dialer, relay string) (time.Duration, error) {
+	start := time.Now()
+	address := chk.target.Host
+	if relay != "" {
+		address = relay
+	}
+	conn, err := dial("tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// remotedialer connections ignore read deadlines, the agent gets a second to report its dial timeout
+	var expired int32
+	timer := time.AfterFunc(chk.timeout+time.Second, func() {
+		atomic.StoreInt32(&expired, 1)
+		conn.Close()
+	})
+	defer timer.Stop()
+	if relay != "" {
+		if err := session.WriteDatagram(conn, []byte(session.TCP_CHECK_PREFIX+chk.target.Host)); err != nil {
+			return time.Since(start), err
+		}
+		answer := make([]byte, session.MAX_DATAGRAM)
+		n, err := session.ReadDatagram(conn, answer)
+		switch {
+		case atomic.LoadInt32(&expired) == 1:
+			return time.Since(start), fmt.Errorf("dial not confirmed by the client within %s", chk.timeout)
+		case err != nil:
+			return time.Since(start), err
+		case n > 0:
+			return time.Since(start), errors.New(string(answer[:n]))
+		}
+		return time.Since(start), nil
+	}
+	n, err := conn.Read(make([]byte, 1))
+	switch {
+	case atomic.LoadInt32(&expired) == 1:
+		return time.Since(start), fmt.Errorf("dial not confirmed within %s, the client has no udp relay and the target sent nothing", chk.timeout)
+	case n > 0, err == io.EOF:
+		return time.Since(start), nil
+	default:
+		return time.Since(start), err
+	}
+}
@@ -0,0 +1,268 @@
+package connectivitycheck
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	hostscheme "hostmanager/pkg/generated/clientset/versioned/scheme"
+	hostinformers "hostmanager/pkg/generated/informers/externalversions"
+	hostlisters "hostmanager/pkg/generated/listers/hostmanager/v1"
+	"hostmanager/pkg/session"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+const (
+	RESYNC_PERIOD = 30 * time.Second
+	// TICK is how often the checks due are started
+	TICK = time.Second
+	// HISTORY is the number of results kept in the status
+	HISTORY = 20
+	// STALE_INTERVALS without a result through a client drop it from the status, its hostmanager is gone
+	STALE_INTERVALS = 3
+
+	// the reasons of the events of a client starting to fail or pass a check
+	REASON_FAILED = "CheckFailed"
+	REASON_PASSED = "CheckPassed"
+)
+
+// Controller runs the ConnectivityChecks of all namespaces through the tunnel clients connected to this
+// hostmanager. Every hostmanager writes the results of its clients in the status and emits an event when
+// one of them starts to fail or pass.
+type Controller struct {
+	hostclientset hostclientset.Interface
+	informer      cache.SharedIndexInformer
+	lister        hostlisters.ConnectivityCheckLister
+	factory       hostinformers.SharedInformerFactory
+	recorder      record.EventRecorder
+	server        *remotedialer.Server
+	registry      *session.Registry
+	self          string
+
+	lock sync.Mutex
+	// checks are the schedules of the ConnectivityChecks by namespace/name
+	checks map[string]*schedule
+}
+
+type schedule struct {
+	next    time.Time
+	running bool
+}
+
+func NewController(kubeClient kubernetes.Interface, hostClient hostclientset.Interface, server *remotedialer.Server, registry *session.Registry, self string) *Controller {
+	factory := hostinformers.NewSharedInformerFactory(hostClient, RESYNC_PERIOD)
+	informer := factory.Hostmanager().V1().ConnectivityChecks()
+
+	// the events of ConnectivityChecks need their kind in the scheme
+	utilruntime.Must(hostscheme.AddToScheme(scheme.Scheme))
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
+	return &Controller{
+		hostclientset: hostClient,
+		informer:      informer.Informer(),
+		lister:        informer.Lister(),
+		factory:       factory,
+		recorder:      broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "hostmanager", Host: self}),
+		server:        server,
+		registry:      registry,
+		self:          self,
+		checks:        map[string]*schedule{},
+	}
+}
+
+// Run starts the informer and the checks, it does not block
+func (c *Controller) Run(stopCh <-chan struct{}) error {
+	c.factory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, c.informer.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	go wait.Until(c.runDue, TICK, stopCh)
+	return nil
+}
+
+// runDue starts the checks whose interval passed and are not running
+func (c *Controller) runDue() {
+	all, err := c.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list connectivity checks fail:%s", err.Error())
+		return
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	seen := map[string]bool{}
+	for _, cc := range all {
+		key := cc.Namespace + "/" + cc.Name
+		seen[key] = true
+		s := c.checks[key]
+		if s == nil {
+			s = &schedule{}
+			c.checks[key] = s
+		}
+		if s.running || now.Before(s.next) {
+			continue
+		}
+		chk, err := parse(cc)
+		interval := INTERVAL
+		if err == nil {
+			interval = chk.interval
+		}
+		s.running, s.next = true, now.Add(interval)
+		go func(cc *hostv1.ConnectivityCheck) {
+			c.run(cc, chk, err)
+			c.lock.Lock()
+			s.running = false
+			c.lock.Unlock()
+		}(cc)
+	}
+	for key := range c.checks {
+		if !seen[key] {
+			delete(c.checks, key)
+		}
+	}
+}
+
+// run checks cc through the clients connected here and writes their results, or the error of its spec
+func (c *Controller) run(cc *hostv1.ConnectivityCheck, chk *check, invalid error) {
+	key := cc.Namespace + "/" + cc.Name
+	var results []hostv1.CheckResult
+	if invalid == nil {
+		var wg sync.WaitGroup
+		clients := c.clients(chk)
+		results = make([]hostv1.CheckResult, len(clients))
+		for i, clientID := range clients {
+			wg.Add(1)
+			go func(i int, clientID string) {
+				defer wg.Done()
+				if c.registry.Allows(clientID, "tcp", chk.address()) {
+					results[i] = chk.run(clientID, c.server.Dialer(clientID, chk.timeout), c.registry.UDPRelay(clientID))
+				} else {
+					// the agent would end its session on a denied dial
+					results[i] = hostv1.CheckResult{ClientID: clientID, Message: fmt.Sprintf("%s not allowed by the client", chk.address())}
+				}
+				results[i].Host, results[i].Time = c.self, metav1.Now()
+			}(i, clientID)
+		}
+		wg.Wait()
+	}
+
+	var previous []hostv1.CheckResult
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := c.hostclientset.HostmanagerV1().ConnectivityChecks(cc.Namespace).Get(cc.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		interval := INTERVAL
+		if chk != nil {
+			interval = chk.interval
+		}
+		status := merge(latest.Status, c.self, results, invalid, time.Duration(STALE_INTERVALS)*interval)
+		previous = latest.Status.Clients
+		if equality.Semantic.DeepEqual(status, latest.Status) {
+			return nil
+		}
+		latest.Status = status
+		_, err = c.hostclientset.HostmanagerV1().ConnectivityChecks(cc.Namespace).UpdateStatus(latest)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("update connectivity check:[%s] status fail:%s", key, err.Error())
+		return
+	}
+	c.events(cc, previous, results)
+}
+
+// clients returns the clients of chk connected here
+func (c *Controller) clients(chk *check) []string {
+	var sessions []session.Session
+	if chk.clientID != "" {
+		sessions = c.registry.Sessions(chk.clientID)
+	} else {
+		sessions = c.registry.Select(chk.selector)
+	}
+	seen := map[string]bool{}
+	var clients []string
+	for _, s := range sessions {
+		if !seen[s.ClientID] {
+			seen[s.ClientID] = true
+			clients = append(clients, s.ClientID)
+		}
+	}
+	return clients
+}
+
+// events records an event for every client whose check started to fail, or to pass again
+func (c *Controller) events(cc *hostv1.ConnectivityCheck, previous, results []hostv1.CheckResult) {
+	passed := map[string]bool{}
+	for _, r := range previous {
+		passed[r.ClientID] = r.Passed
+	}
+	for _, r := range results {
+		was, checked := passed[r.ClientID]
+		switch {
+		case !r.Passed && (!checked || was):
+			c.recorder.Eventf(cc, corev1.EventTypeWarning, REASON_FAILED, "client %s: %s", r.ClientID, r.Message)
+		case r.Passed && checked && !was:
+			c.recorder.Eventf(cc, corev1.EventTypeNormal, REASON_PASSED, "client %s: passed in %s", r.ClientID, r.Latency.Duration)
+		}
+	}
+}
+
+// merge returns status with the results of self, the results of the other hosts older than stale dropped
+func merge(status hostv1.ConnectivityCheckStatus, self string, results []hostv1.CheckResult, invalid error, stale time.Duration) hostv1.ConnectivityCheckStatus {
+	merged := hostv1.ConnectivityCheckStatus{}
+	for _, r := range status.Clients {
+		if r.Host != self && time.Since(r.Time.Time) < stale {
+			merged.Clients = append(merged.Clients, r)
+		}
+	}
+	merged.Clients = append(merged.Clients, results...)
+	sort.Slice(merged.Clients, func(i, j int) bool { return merged.Clients[i].ClientID < merged.Clients[j].ClientID })
+
+	merged.History = append(append([]hostv1.CheckResult{}, results...), status.History...)
+	if len(merged.History) > HISTORY {
+		merged.History = merged.History[:HISTORY]
+	}
+
+	failed := 0
+	var message string
+	for _, r := range merged.Clients {
+		if !r.Passed {
+			if failed == 0 {
+				message = fmt.Sprintf("client %s: %s", r.ClientID, r.Message)
+			}
+			failed++
+		}
+	}
+	switch {
+	case invalid != nil:
+		merged.State, merged.Message, merged.Clients = hostv1.Unknown, invalid.Error(), nil
+	case len(merged.Clients) == 0:
+		merged.State, merged.Message = hostv1.Unknown, "no client connected"
+	case failed > 0:
+		merged.State = hostv1.Failing
+		merged.Message = fmt.Sprintf("failed through %d of %d clients, %s", failed, len(merged.Clients), message)
+	default:
+		merged.State = hostv1.Passing
+		merged.Message = fmt.Sprintf("passed through %d clients", len(merged.Clients))
+	}
+	return merged
+}
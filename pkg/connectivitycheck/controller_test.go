@@ -0,0 +1,211 @@
+package connectivitycheck
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/agent"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	hostfake "hostmanager/pkg/generated/clientset/versioned/fake"
+	"hostmanager/pkg/session"
+	"hostmanager/pkg/session/sessiontest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newConnectivityCheck(name string, spec hostv1.ConnectivityCheckSpec) *hostv1.ConnectivityCheck {
+	return &hostv1.ConnectivityCheck{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       spec,
+	}
+}
+
+func TestConnectivityCheck(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, "status: ok")
+	}))
+	defer backend.Close()
+
+	server := sessiontest.NewServer(t).Start(nil)
+
+	interval := &metav1.Duration{Duration: time.Hour}
+	hostClient := hostfake.NewSimpleClientset(
+		newConnectivityCheck("http", hostv1.ConnectivityCheckSpec{
+			ClientID: "foo",
+			Target:   backend.URL + "/health",
+			Interval: interval,
+			Expect:   hostv1.CheckExpectations{StatusCode: 200, BodyRegex: "status: (ok|degraded)"},
+		}),
+		newConnectivityCheck("body", hostv1.ConnectivityCheckSpec{
+			Selector: "site=berlin",
+			Target:   backend.URL,
+			Interval: interval,
+			Expect:   hostv1.CheckExpectations{BodyRegex: "down"},
+		}),
+		newConnectivityCheck("tcp", hostv1.ConnectivityCheckSpec{
+			Selector: "site=berlin",
+			Target:   "tcp://127.0.0.1:1",
+			Interval: interval,
+		}),
+		// the rules of baz deny the target, it is not dialed
+		newConnectivityCheck("denied", hostv1.ConnectivityCheckSpec{ClientID: "baz", Target: "tcp://127.0.0.1:1", Interval: interval}),
+		newConnectivityCheck("offline", hostv1.ConnectivityCheckSpec{ClientID: "bar", Target: backend.URL, Interval: interval}),
+		newConnectivityCheck("invalid", hostv1.ConnectivityCheckSpec{ClientID: "foo", Target: "ftp://10.0.0.5"}),
+	)
+	self := "10.1.1.1:8123"
+	c := NewController(kubefake.NewSimpleClientset(), hostClient, server.Server, server.Registry, self)
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+
+	server.Connect("foo", http.Header{session.LABELS_HEADER: []string{"site=berlin"}})
+	server.ConnectWith("baz", http.Header{session.ALLOW_HEADER: []string{"tcp:10.*:80"}}, func(string, string) bool { return false })
+	if err := c.Run(stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+
+	want := map[string]string{"http": hostv1.Passing, "body": hostv1.Failing, "tcp": hostv1.Failing, "denied": hostv1.Failing, "offline": hostv1.Unknown, "invalid": hostv1.Unknown}
+	statuses := map[string]hostv1.ConnectivityCheckStatus{}
+	err := wait.Poll(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		for name := range want {
+			cc, err := hostClient.HostmanagerV1().ConnectivityChecks("default").Get(name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			if cc.Status.State == "" {
+				return false, nil
+			}
+			statuses[name] = cc.Status
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("statuses not written: %v %+v", err, statuses)
+	}
+	for name, state := range want {
+		if statuses[name].State != state {
+			t.Errorf("%s: expected %s, got %+v", name, state, statuses[name])
+		}
+	}
+	if clients := statuses["http"].Clients; len(clients) != 1 || clients[0].ClientID != "foo" || clients[0].Host != self || clients[0].StatusCode != 200 {
+		t.Errorf("unexpected results %+v", clients)
+	}
+	if len(statuses["http"].History) != 1 {
+		t.Errorf("unexpected history %+v", statuses["http"].History)
+	}
+	if !strings.Contains(statuses["tcp"].Message, "connection refused") || !strings.Contains(statuses["invalid"].Message, "ftp://10.0.0.5") {
+		t.Errorf("unexpected messages %q %q", statuses["tcp"].Message, statuses["invalid"].Message)
+	}
+	if !strings.Contains(statuses["denied"].Message, "not allowed") || !server.HasSession("baz") {
+		t.Errorf("expected the denied target not dialed, got %q", statuses["denied"].Message)
+	}
+
+	events := map[string]bool{}
+	for len(events) < 3 {
+		select {
+		case event := <-recorder.Events:
+			events[event] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected three events, got %v", events)
+		}
+	}
+	for event := range events {
+		if !strings.HasPrefix(event, "Warning "+REASON_FAILED+" client foo: ") && !strings.HasPrefix(event, "Warning "+REASON_FAILED+" client baz: ") {
+			t.Errorf("unexpected event %s", event)
+		}
+	}
+}
+
+func TestTCPConfirm(t *testing.T) {
+	// a target accepting connections without sending anything
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			// kept open until the test ends
+			defer conn.Close()
+		}
+	}()
+
+	server := sessiontest.NewServer(t).Start(nil)
+	// a real agent confirms the dials with its udp relay
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	go agent.New(agent.Options{
+		Servers: []string{"ws" + strings.TrimPrefix(server.Front.URL, "http") + "/connect"},
+		ID:      "relayed",
+		Logger:  logger,
+	}).Run(ctx)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) { return server.HasSession("relayed"), nil }); err != nil {
+		t.Fatalf("agent not connected")
+	}
+	server.Connect("raw", nil)
+
+	for _, test := range []struct {
+		clientID string
+		target   string
+		passed   bool
+		message  string
+	}{
+		{clientID: "relayed", target: silent.Addr().String(), passed: true},
+		{clientID: "relayed", target: "127.0.0.1:1", message: "connection refused"},
+		// without relay an expired check is not a passed one
+		{clientID: "raw", target: silent.Addr().String(), message: "not confirmed"},
+		{clientID: "raw", target: "127.0.0.1:1", message: "connection refused"},
+	} {
+		chk, err := parse(newConnectivityCheck("tcp", hostv1.ConnectivityCheckSpec{
+			ClientID: test.clientID,
+			Target:   "tcp://" + test.target,
+			Timeout:  &metav1.Duration{Duration: 200 * time.Millisecond},
+		}))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		result := chk.run(test.clientID, server.Dialer(test.clientID, chk.timeout), server.Registry.UDPRelay(test.clientID))
+		if result.Passed != test.passed || !strings.Contains(result.Message, test.message) {
+			t.Errorf("%s %s: expected passed %v with %q, got %+v", test.clientID, test.target, test.passed, test.message, result)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	status := hostv1.ConnectivityCheckStatus{
+		Clients: []hostv1.CheckResult{
+			{ClientID: "a", Host: "h1", Time: metav1.Now(), Passed: true},
+			{ClientID: "b", Host: "h2", Time: old, Passed: false},
+			{ClientID: "c", Host: "self", Time: metav1.Now(), Passed: false},
+		},
+	}
+	for i := 0; i < HISTORY; i++ {
+		status.History = append(status.History, hostv1.CheckResult{ClientID: "a"})
+	}
+	merged := merge(status, "self", []hostv1.CheckResult{{ClientID: "d", Host: "self", Time: metav1.Now(), Passed: true}}, nil, time.Minute)
+	if len(merged.Clients) != 2 || merged.Clients[0].ClientID != "a" || merged.Clients[1].ClientID != "d" {
+		t.Errorf("unexpected clients %+v", merged.Clients)
+	}
+	if merged.State != hostv1.Passing || len(merged.History) != HISTORY || merged.History[0].ClientID != "d" {
+		t.Errorf("unexpected status %+v", merged)
+	}
+}
@@ -2,27 +2,21 @@ package pkg
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"github.com/rancher/remotedialer"
-	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
 	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
-	hostclientset "hostmanager/pkg/generated/clientset/versioned"
-	hostscheme "hostmanager/pkg/generated/clientset/versioned/scheme"
-	hostinformers "hostmanager/pkg/generated/informers/externalversions"
-	hostlisters "hostmanager/pkg/generated/listers/hostmanager/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"hostmanager/pkg/discovery"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 )
@@ -34,17 +28,19 @@ const (
 
 	MessageResourceSynced = "host synced successfully"
 
-	HOST_CRD_NAMESPACE = "default"
-	MAX_PEER_NUM       = 10
-	HOST_CONFIG_PATH   = "./.kube/config"
+	// ExitPeerSignal buffer size, it does not limit the number of peers
+	EXIT_PEER_SIGNAL_SIZE = 64
+	HOST_CONFIG_PATH      = "./.kube/config"
+
+	// every RESYNC_PERIOD all desired and actual peers are reconciled again, so missed events are repaired
+	RESYNC_PERIOD = 30 * time.Second
+	// a peer without session after PEER_MAX_RETRIES rate limited retries is removed and added again
+	PEER_MAX_RETRIES = 5
 )
 
-// Controller is the controller implementation for Host resources
+// Controller is the controller implementation for hostmanager peers
 type Controller struct {
-	// hostclientset is a clientset for our own API group
-	hostclientset    hostclientset.Interface
-	hostLister       hostlisters.HostLister
-	hostSynced       cache.InformerSynced
+	discovery        discovery.Discovery
 	workqueue        workqueue.RateLimitingInterface
 	ExitPeerSignal   chan string
 	exitSignal       chan struct{}
@@ -53,41 +49,44 @@ type Controller struct {
 	HostToken        string
 	rserver          *remotedialer.Server
 	rserverServerUrl string
+	// Fanout is the number of peers to connect with, 0 connects with all of them (full mesh).
+	// Clients of the other peers are reached through the discovery Directory.
+	Fanout int
+
+	// peers are the peers added to rserver, remotedialer does not expose them
+	peersLock sync.Mutex
+	peers     map[string]discovery.Peer
+	// connected counts the peer sessions each peer connected back to us with, HasSession
+	// of rserver only knows the clients of the peers
+	connected map[string]int
+	// cordoned is the last Cordon call and draining is set by Drain, they are used when the
+	// discovery does not list this hostmanager
+	cordoned bool
+	draining bool
 }
 
-// NewController returns a new host controller
-func NewController(exitSignal <-chan struct{}, wg *sync.WaitGroup, rserver *remotedialer.Server, serverPort string) *Controller {
-
-	// 处理入参
-	cfg, err := clientcmd.BuildConfigFromFlags("", HOST_CONFIG_PATH)
-	if err != nil {
-		klog.Fatalf("Error building kubeconfig: %s", err.Error())
-	}
-
-	hostClient, err := hostclientset.NewForConfig(cfg)
-	if err != nil {
-		klog.Fatalf("Error building example clientset: %s", err.Error())
+// NewController returns a new host controller, which keeps the remotedialer peers of rserver
+// in line with the peers found by disc. An empty token generates a random one.
+func NewController(exitSignal <-chan struct{}, wg *sync.WaitGroup, rserver *remotedialer.Server, serverPort string, disc discovery.Discovery, token string) *Controller {
+	if token == "" {
+		token = RandToken(16)
 	}
 
-	hostInformerFactory := hostinformers.NewSharedInformerFactoryWithOptions(hostClient, time.Second, hostinformers.WithNamespace(HOST_CRD_NAMESPACE))
-	hostinformer := hostInformerFactory.Hostmanager().V1().Hosts()
-	utilruntime.Must(hostscheme.AddToScheme(scheme.Scheme))
-
 	controller := &Controller{
-		hostclientset:  hostClient,
-		hostLister:     hostinformer.Lister(),
-		hostSynced:     hostinformer.Informer().HasSynced,
-		workqueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Hosts"),
-		ExitPeerSignal: make(chan string, MAX_PEER_NUM),
-		HostToken:      RandToken(16),
+		discovery:      disc,
+		workqueue:      workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute), "Hosts"),
+		ExitPeerSignal: make(chan string, EXIT_PEER_SIGNAL_SIZE),
+		HostToken:      token,
 		rserver:        rserver,
+		peers:          map[string]discovery.Peer{},
+		connected:      map[string]int{},
 	}
 
 	controller.LocalIp = getFirtIP()
 	if controller.LocalIp == "" {
 		klog.Fatalf("Error cannot get host IP")
 	}
-	if osname, err := os.Hostname(); err != nil {
+	if osname, err := os.Hostname(); err == nil {
 		controller.LocalHostname = osname
 	} else {
 		klog.Error("cannot get hostname")
@@ -99,42 +98,38 @@ func NewController(exitSignal <-chan struct{}, wg *sync.WaitGroup, rserver *remo
 	controller.rserver.PeerToken = controller.HostToken
 
 	klog.Info("Setting up event handlers")
-	// Set up an event handler for when Host resources change
-	hostinformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			host := obj.(*hostmanagerv1.Host)
-			if host.Spec.HostAddress != controller.rserverServerUrl {
-				if !controller.rserver.HasSession(host.Spec.HostAddress) {
-					klog.Infof("host[%s] added. spec:%+v. add peer", host.Name, host.Spec)
-					controller.rserver.AddPeer(fmt.Sprintf("ws://%s/connect", host.Spec.HostAddress), host.Spec.HostAddress, host.Spec.HostToken)
-				} else {
-					klog.Errorf("host[%s] added. spec:%+v session already exist", host.Name, host.Spec)
-				}
-			}
-			//controller.enqueueHost(obj)
+	// Set up an event handler for when peers change
+	controller.discovery.Start(discovery.PeerEventHandlerFuncs{
+		AddFunc: func(peer discovery.Peer) {
+			klog.Infof("peer[%s] added. %+v", peer.ID, peer)
+			controller.enqueueHost(peer)
+			controller.enqueueSelection()
 		},
-		UpdateFunc: func(old, new interface{}) {
-			oldHost := old.(*hostmanagerv1.Host)
-			newHost := new.(*hostmanagerv1.Host)
-			if oldHost.ResourceVersion == newHost.ResourceVersion {
-				//版本一致，就表示没有实际更新的操作，立即返回
-				return
-			} else if oldHost.Spec.HostStatus == hostmanagerv1.UnAvailable && newHost.Spec.HostStatus == hostmanagerv1.Available {
-				klog.Infof("host[%s] status from %s to %s", newHost.Name, hostmanagerv1.UnAvailable, hostmanagerv1.Available)
-			} else if oldHost.Spec.HostStatus == hostmanagerv1.Available && newHost.Spec.HostStatus == hostmanagerv1.UnAvailable {
-				klog.Infof("host[%s] status from %s to %s", newHost.Name, hostmanagerv1.Available, hostmanagerv1.UnAvailable)
+		UpdateFunc: func(oldPeer, newPeer discovery.Peer) {
+			if oldPeer.ID != newPeer.ID {
+				// the old address is no longer desired, its peer has to be torn down
+				klog.Infof("peer address from %s to %s", oldPeer.ID, newPeer.ID)
+				controller.enqueueHostForDelete(oldPeer)
+			} else if oldPeer.Token != newPeer.Token || oldPeer.URL != newPeer.URL {
+				klog.Infof("peer[%s] token or url changed", newPeer.ID)
+			}
+			if oldPeer.Cordoned != newPeer.Cordoned {
+				klog.Infof("peer[%s] cordoned %t", newPeer.ID, newPeer.Cordoned)
 			}
-			//controller.enqueueHost(new)
+			if oldPeer.Status == hostv1.UnAvailable && newPeer.Status == hostv1.Available {
+				klog.Infof("peer[%s] status from %s to %s", newPeer.ID, hostv1.UnAvailable, hostv1.Available)
+			} else if oldPeer.Status == hostv1.Available && newPeer.Status == hostv1.UnAvailable {
+				klog.Infof("peer[%s] status from %s to %s", newPeer.ID, hostv1.Available, hostv1.UnAvailable)
+			}
+			controller.enqueueHost(newPeer)
 		},
-		DeleteFunc: func(obj interface{}) {
-			host := obj.(*hostmanagerv1.Host)
-			klog.Infof("host[%s] deleted. spec:%+v del peer", host.Name, host.Spec)
-			controller.rserver.RemovePeer(host.Spec.HostAddress)
-			//controller.enqueueHostForDelete(obj)
+		DeleteFunc: func(peer discovery.Peer) {
+			klog.Infof("peer[%s] deleted. %+v", peer.ID, peer)
+			controller.enqueueHostForDelete(peer)
+			controller.enqueueSelection()
 		},
-	})
-	hostInformerFactory.Start(exitSignal)
-	controller.updateHostStatus(controller.rserverServerUrl, hostv1.Available)
+	}, exitSignal)
+	controller.discovery.SetStatus(controller.LocalPeer(), hostv1.Available)
 
 	//wait for peer exit singnal ExitSignal
 	go func(exitSignal <-chan struct{}, wg *sync.WaitGroup) {
@@ -145,19 +140,97 @@ func NewController(exitSignal <-chan struct{}, wg *sync.WaitGroup, rserver *remo
 				break EXITSIGNAL
 			case peerid := <-controller.ExitPeerSignal:
 				klog.Infof("ExitSignal set peer:[%s] %s", peerid, hostv1.UnAvailable)
-				controller.updateHostStatus(peerid, hostv1.UnAvailable)
+				controller.discovery.SetStatus(discovery.Peer{ID: peerid}, hostv1.UnAvailable)
 				//default:
 				//	klog.Infof("2 second pass")
 				//	time.Sleep(time.Second * 2)
 			}
 		}
 		klog.Infof("hostmanager signal process ended.")
-		controller.deleteHost(strings.Replace(controller.rserverServerUrl, ":", "-", 1))
 		wg.Done()
 	}(exitSignal, wg)
 	return controller
 }
 
+// Drain marks this hostmanager Draining, so peers and agents stop picking it
+func (c *Controller) Drain() error {
+	c.peersLock.Lock()
+	c.draining = true
+	c.peersLock.Unlock()
+	klog.Infof("set peer:[%s] %s", c.rserverServerUrl, hostv1.Draining)
+	return c.discovery.SetStatus(c.LocalPeer(), hostv1.Draining)
+}
+
+// Shutdown withdraws this hostmanager from the discovery, it is the last step of a shutdown
+func (c *Controller) Shutdown() error {
+	return c.discovery.Remove(c.LocalPeer())
+}
+
+// Cordon cordons or uncordons this hostmanager: new agent sessions are refused and peers
+// stop routing new requests to it, existing sessions keep working
+func (c *Controller) Cordon(cordoned bool) error {
+	c.peersLock.Lock()
+	c.cordoned = cordoned
+	c.peersLock.Unlock()
+	return c.discovery.SetCordoned(c.LocalPeer(), cordoned)
+}
+
+// Cordoned returns true if this hostmanager is cordoned, by Cordon or on its discovery record
+func (c *Controller) Cordoned() bool {
+	if peer, ok, err := c.discovery.Get(c.rserverServerUrl); err == nil && ok {
+		return peer.Cordoned
+	}
+	c.peersLock.Lock()
+	defer c.peersLock.Unlock()
+	return c.cordoned
+}
+
+// Hosts returns the schedulable hostmanagers, including this one unless it is cordoned or draining
+func (c *Controller) Hosts() []discovery.Peer {
+	peers, err := c.discovery.Peers()
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list peers: %s", err.Error()))
+	}
+	var hosts []discovery.Peer
+	listed := false
+	for _, peer := range peers {
+		if peer.ID == c.rserverServerUrl {
+			listed = true
+		}
+		if peer.Schedulable() {
+			hosts = append(hosts, peer)
+		}
+	}
+	if !listed {
+		c.peersLock.Lock()
+		if !c.cordoned && !c.draining {
+			hosts = append(hosts, c.LocalPeer())
+		}
+		c.peersLock.Unlock()
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ID < hosts[j].ID })
+	return hosts
+}
+
+// Alternatives returns the connect urls of the other schedulable hostmanagers,
+// which agents refused here can connect to instead
+func (c *Controller) Alternatives() []string {
+	var urls []string
+	for _, host := range c.Hosts() {
+		if host.ID != c.rserverServerUrl {
+			urls = append(urls, host.URL)
+		}
+	}
+	return urls
+}
+
+// LocalPeer returns this hostmanager as a peer
+func (c *Controller) LocalPeer() discovery.Peer {
+	peer := discovery.NewPeer(c.rserverServerUrl, c.HostToken)
+	peer.Info = fmt.Sprintf("OS:[%s],Arch:[%s],CPUS:[%d]", runtime.GOOS, runtime.GOARCH, runtime.GOMAXPROCS(0))
+	return peer
+}
+
 //get first ip, not lookback
 func getFirtIP() string {
 	address := ""
@@ -181,74 +254,12 @@ func RandToken(num int) string {
 	return fmt.Sprintf("%x", b)
 }
 
-func (c *Controller) deleteHost(peerid string) {
-	_, err := c.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Get(peerid, metav1.GetOptions{})
-	if err != nil && errors.IsNotFound(err) {
-		klog.Infof("delete peer:[%s] already deleted", peerid)
-	} else if err == nil {
-		//delete
-		if err = c.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Delete(peerid, &metav1.DeleteOptions{}); err != nil {
-			klog.Errorf("delete peer:[%s] fail:%s", peerid, err.Error())
-		} else {
-			klog.Infof("delete peer:[%s] success", peerid)
-		}
-	} else {
-		klog.Errorf("get peer:[%s] fail:%s", peerid, err.Error())
-	}
-}
-
-func (c *Controller) updateHostStatus(peerid, status string) {
-	hostcrdname := strings.Replace(peerid, ":", "-", 1)
-	host, err := c.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Get(strings.Replace(hostcrdname, ":", "-", 1), metav1.GetOptions{})
-	if err != nil && errors.IsNotFound(err) {
-		klog.Infof("create peer:[%s] to be %s", hostcrdname, status)
-		if _, err := c.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Create(&hostv1.Host{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      hostcrdname,
-				Namespace: HOST_CRD_NAMESPACE,
-			},
-			Spec: hostv1.HostSpec{
-				HostAddress: peerid,
-				HostStatus:  status,
-				HostToken:   c.HostToken,
-				HostInfo:    fmt.Sprintf("OS:[%s],Arch:[%s],CPUS:[%d]", runtime.GOOS, runtime.GOARCH, runtime.GOMAXPROCS(0)),
-			},
-		}); err != nil {
-			klog.Errorf("create peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
-		} else {
-			klog.Infof("create peer:[%s] to be %s success", hostcrdname, status)
-		}
-	} else if err == nil {
-		//update
-		if _, err = c.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Update(&hostv1.Host{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:            hostcrdname,
-				Namespace:       HOST_CRD_NAMESPACE,
-				ResourceVersion: host.ResourceVersion,
-			},
-			Spec: hostv1.HostSpec{
-				HostAddress: peerid,
-				HostStatus:  status,
-				HostToken:   c.HostToken,
-				HostInfo:    fmt.Sprintf("OS:[%s],Arch:[%s],CPUS:[%d]", runtime.GOOS, runtime.GOARCH, runtime.GOMAXPROCS(0)),
-			},
-		}); err != nil {
-			klog.Errorf("update peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
-		} else {
-			klog.Infof("update peer:[%s] to be %s success", hostcrdname, status)
-		}
-	} else {
-		klog.Errorf("get peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
-	}
-}
-
 //在此处开始controller的业务
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
-	defer c.workqueue.ShutDown()
 
 	klog.Info("开始controller业务，开始一次缓存数据同步")
-	if ok := cache.WaitForCacheSync(stopCh, c.hostSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.discovery.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -256,10 +267,15 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
+	go wait.Until(c.enqueueAll, RESYNC_PERIOD, stopCh)
 
 	klog.Info("worker已经启动")
-	//<-stopCh
-	//klog.Info("worker已经结束")
+	// Run does not block, the workqueue lives until stopCh is closed
+	go func() {
+		<-stopCh
+		c.workqueue.ShutDown()
+		klog.Info("worker已经结束")
+	}()
 
 	return nil
 }
@@ -292,11 +308,13 @@ func (c *Controller) processNextWorkItem() bool {
 		}
 		// 在syncHandler中处理业务
 		if err := c.syncHandler(key); err != nil {
-			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+			// 失败的key限速重新入队列
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
 
 		c.workqueue.Forget(obj)
-		klog.Infof("Successfully synced '%s'", key)
+		klog.V(4).Infof("Successfully synced '%s'", key)
 		return nil
 	}(obj)
 
@@ -308,60 +326,175 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
-// 处理
+// 处理: 对比peer的期望状态(discovery)与实际状态(rserver中已添加的peer及其session)，并根据差异新增、删除或者重新添加
 func (c *Controller) syncHandler(key string) error {
-	// Convert the namespace/name string into a distinct namespace and name
-	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	// 本机不作为peer
+	if key == c.rserverServerUrl {
+		return nil
+	}
+
+	// 从缓存中取期望状态
+	desired, exists, err := c.desiredPeer(key)
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		utilruntime.HandleError(fmt.Errorf("failed to get peer by: %s", key))
+
+		return err
+	}
+
+	c.peersLock.Lock()
+	actual, added := c.peers[key]
+	c.peersLock.Unlock()
+
+	// peer被删除了
+	if !exists {
+		if added {
+			klog.Infof("peer[%s] not desired, remove peer", key)
+			c.removePeer(key)
+		}
 		return nil
 	}
 
-	// 从缓存中取对象
-	host, err := c.hostLister.Hosts(namespace).Get(name)
+	if !added {
+		klog.Infof("peer[%s] desired, add peer %s", key, desired.URL)
+		c.addPeer(desired)
+	} else if actual.URL != desired.URL || actual.Token != desired.Token {
+		klog.Infof("peer[%s] changed from %s to %s, add peer again", key, actual.URL, desired.URL)
+		c.removePeer(key)
+		c.addPeer(desired)
+	}
+
+	// the peer connects back to us once it has added us too, which is the session seen from here
+	if !c.peerConnected(key) {
+		if c.workqueue.NumRequeues(key) >= PEER_MAX_RETRIES {
+			klog.Errorf("peer[%s] still has no session after %d retries, add peer again", key, PEER_MAX_RETRIES)
+			c.workqueue.Forget(key)
+			c.removePeer(key)
+			c.addPeer(desired)
+		}
+		return fmt.Errorf("peer[%s] has no session yet", key)
+	}
+
+	return nil
+}
+
+// desiredPeer returns the peer with id if it exists and is selected by the fanout
+func (c *Controller) desiredPeer(id string) (discovery.Peer, bool, error) {
+	if c.Fanout <= 0 {
+		return c.discovery.Get(id)
+	}
+	peers, err := c.discovery.Peers()
 	if err != nil {
-		// 如果Host对象被删除了，就会走到这里，所以应该在这里加入执行
-		if errors.IsNotFound(err) {
-			klog.Infof("Host对象被删除，请在这里执行实际的删除业务: %s/%s ...", namespace, name)
+		return discovery.Peer{}, false, err
+	}
+	for _, peer := range peers {
+		if peer.ID == id && discovery.Linked(c.rserverServerUrl, id, peers, c.Fanout) {
+			return peer, true, nil
+		}
+	}
+	return discovery.Peer{}, false, nil
+}
 
-			return nil
+// PeerHandler wraps the /connect handler to record the peers connected to it, a peer
+// counts once it authenticates with the token it was added with
+func (c *Controller) PeerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id, token := req.Header.Get(remotedialer.ID), req.Header.Get(remotedialer.Token)
+		c.peersLock.Lock()
+		peer, added := c.peers[id]
+		added = added && id != "" && token != "" && peer.Token == token
+		if added {
+			c.connected[id]++
+		}
+		c.peersLock.Unlock()
+		if !added {
+			next.ServeHTTP(rw, req)
+			return
 		}
 
-		utilruntime.HandleError(fmt.Errorf("failed to list host by: %s/%s", namespace, name))
+		klog.Infof("peer[%s] connected from %s", id, req.RemoteAddr)
+		c.workqueue.Add(id)
+		defer func() {
+			c.peersLock.Lock()
+			if c.connected[id]--; c.connected[id] <= 0 {
+				delete(c.connected, id)
+			}
+			c.peersLock.Unlock()
+			klog.Infof("peer[%s] disconnected", id)
+			// the peer is checked again, it has to connect back
+			c.workqueue.Add(id)
+		}()
+		// the remotedialer session is served until it is closed
+		next.ServeHTTP(rw, req)
+	})
+}
 
-		return err
+// Authenticate returns true if token is the token of the peer with id, e.g. of a hostmanager forwarding
+// a request here. Any listed peer counts, also one the fanout does not link us with.
+func (c *Controller) Authenticate(id, token string) bool {
+	if id == "" || token == "" || id == c.rserverServerUrl {
+		return false
+	}
+	peer, ok, err := c.discovery.Get(id)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to get peer %s: %s", id, err.Error()))
+		return false
 	}
+	return ok && subtle.ConstantTimeCompare([]byte(peer.Token), []byte(token)) == 1
+}
 
-	klog.Infof("这里是host对象的期望状态: %#v ...", host)
-	klog.Infof("实际状态是从业务层面得到的，此处应该去的实际状态，与期望状态做对比，并根据差异做出响应(新增或者删除)")
+// peerConnected returns true if the peer with id has a session with us
+func (c *Controller) peerConnected(id string) bool {
+	c.peersLock.Lock()
+	defer c.peersLock.Unlock()
+	return c.connected[id] > 0
+}
 
-	return nil
+func (c *Controller) addPeer(peer discovery.Peer) {
+	c.rserver.AddPeer(peer.URL, peer.ID, peer.Token)
+	c.peersLock.Lock()
+	c.peers[peer.ID] = peer
+	c.peersLock.Unlock()
 }
 
-// 数据先放入缓存，再入队列
-func (c *Controller) enqueueHost(obj interface{}) {
-	var key string
-	var err error
-	// 将对象放入缓存
-	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-		utilruntime.HandleError(err)
+func (c *Controller) removePeer(id string) {
+	c.rserver.RemovePeer(id)
+	c.peersLock.Lock()
+	delete(c.peers, id)
+	c.peersLock.Unlock()
+}
+
+// enqueueAll 将所有期望的以及已添加的peer放入队列
+func (c *Controller) enqueueAll() {
+	peers, err := c.discovery.Peers()
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to list peers: %s", err.Error()))
 		return
 	}
+	for _, peer := range peers {
+		c.workqueue.Add(peer.ID)
+	}
+	c.peersLock.Lock()
+	for id := range c.peers {
+		c.workqueue.Add(id)
+	}
+	c.peersLock.Unlock()
+}
 
+// enqueueSelection 有fanout时，peer的增删可能改变其它peer是否被选中，所以全部放入队列
+func (c *Controller) enqueueSelection() {
+	if c.Fanout > 0 {
+		c.enqueueAll()
+	}
+}
+
+// 数据入队列
+func (c *Controller) enqueueHost(peer discovery.Peer) {
 	// 将key放入队列
-	c.workqueue.AddRateLimited(key)
+	c.workqueue.Add(peer.ID)
 }
 
 // 删除操作
-func (c *Controller) enqueueHostForDelete(obj interface{}) {
-	var key string
-	var err error
-	// 从缓存中删除指定对象
-	key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-	if err != nil {
-		utilruntime.HandleError(err)
-		return
-	}
+func (c *Controller) enqueueHostForDelete(peer discovery.Peer) {
 	//再将key放入队列
-	c.workqueue.AddRateLimited(key)
+	c.workqueue.Add(peer.ID)
 }
@@ -0,0 +1,314 @@
+package pkg
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/generated/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newHost(address, token string) *hostv1.Host {
+	return &hostv1.Host{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      discovery.HostName(address),
+			Namespace: discovery.HOST_CRD_NAMESPACE,
+		},
+		Spec: hostv1.HostSpec{
+			HostAddress: address,
+			HostStatus:  hostv1.Available,
+			HostToken:   token,
+		},
+	}
+}
+
+func newTestController(t *testing.T, stopCh chan struct{}, hosts ...*hostv1.Host) (*Controller, *fake.Clientset) {
+	var objects []runtime.Object
+	for _, host := range hosts {
+		objects = append(objects, host)
+	}
+	client := fake.NewSimpleClientset(objects...)
+	rserver := remotedialer.New(func(*http.Request) (string, bool, error) { return "", false, nil }, remotedialer.DefaultErrorWriter)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c := NewController(stopCh, wg, rserver, ":8123", discovery.NewCRDDiscovery(client), "")
+	if err := c.Run(2, stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+	return c, client
+}
+
+// waitForPeer waits until the added peer with id satisfies cond, or is absent when cond is nil
+func waitForPeer(t *testing.T, c *Controller, id string, cond func(discovery.Peer) bool) {
+	err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		c.peersLock.Lock()
+		defer c.peersLock.Unlock()
+		peer, ok := c.peers[id]
+		if cond == nil {
+			return !ok, nil
+		}
+		return ok && cond(peer), nil
+	})
+	if err != nil {
+		c.peersLock.Lock()
+		defer c.peersLock.Unlock()
+		t.Fatalf("peer %s not as expected, peers: %+v", id, c.peers)
+	}
+}
+
+func TestUpdateHostAddress(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	host := newHost("10.1.1.1:8123", "token1")
+	c, client := newTestController(t, stopCh, host)
+
+	waitForPeer(t, c, "10.1.1.1:8123", func(peer discovery.Peer) bool { return peer.Token == "token1" })
+
+	host = host.DeepCopy()
+	// the fake clientset does not bump the resource version, unchanged versions are ignored
+	host.ResourceVersion = "2"
+	host.Spec.HostAddress = "10.1.1.2:8123"
+	if _, err := client.HostmanagerV1().Hosts(discovery.HOST_CRD_NAMESPACE).Update(host); err != nil {
+		t.Fatalf("update host: %v", err)
+	}
+
+	waitForPeer(t, c, "10.1.1.2:8123", func(peer discovery.Peer) bool {
+		return peer.URL == "ws://10.1.1.2:8123/connect" && peer.Token == "token1"
+	})
+	waitForPeer(t, c, "10.1.1.1:8123", nil)
+}
+
+func TestUpdateHostToken(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	host := newHost("10.1.1.1:8123", "token1")
+	c, client := newTestController(t, stopCh, host)
+
+	waitForPeer(t, c, "10.1.1.1:8123", func(peer discovery.Peer) bool { return peer.Token == "token1" })
+
+	host = host.DeepCopy()
+	// the fake clientset does not bump the resource version, unchanged versions are ignored
+	host.ResourceVersion = "2"
+	host.Spec.HostToken = "token2"
+	if _, err := client.HostmanagerV1().Hosts(discovery.HOST_CRD_NAMESPACE).Update(host); err != nil {
+		t.Fatalf("update host: %v", err)
+	}
+
+	waitForPeer(t, c, "10.1.1.1:8123", func(peer discovery.Peer) bool { return peer.Token == "token2" })
+}
+
+func TestAuthenticate(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c, _ := newTestController(t, stopCh, newHost("10.1.1.1:8123", "token1"))
+	waitForPeer(t, c, "10.1.1.1:8123", func(peer discovery.Peer) bool { return peer.Token == "token1" })
+
+	for _, test := range []struct {
+		id, token string
+		want      bool
+	}{
+		{id: "10.1.1.1:8123", token: "token1", want: true},
+		{id: "10.1.1.1:8123", token: "token2", want: false},
+		{id: "10.1.1.1:8123", token: "", want: false},
+		{id: "10.1.1.9:8123", token: "token1", want: false},
+		// this host does not forward to itself
+		{id: c.rserverServerUrl, token: c.HostToken, want: false},
+	} {
+		if got := c.Authenticate(test.id, test.token); got != test.want {
+			t.Errorf("%s %q: expected %v, got %v", test.id, test.token, test.want, got)
+		}
+	}
+}
+
+func TestDeleteHost(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	host := newHost("10.1.1.1:8123", "token1")
+	c, client := newTestController(t, stopCh, host)
+
+	waitForPeer(t, c, "10.1.1.1:8123", func(peer discovery.Peer) bool { return true })
+
+	if err := client.HostmanagerV1().Hosts(discovery.HOST_CRD_NAMESPACE).Delete(host.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete host: %v", err)
+	}
+
+	waitForPeer(t, c, "10.1.1.1:8123", nil)
+}
+
+func TestLocalHostIsNotPeer(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c, client := newTestController(t, stopCh)
+
+	self, err := client.HostmanagerV1().Hosts(discovery.HOST_CRD_NAMESPACE).Get(discovery.HostName(c.rserverServerUrl), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("local host not created: %v", err)
+	}
+	if self.Spec.HostStatus != hostv1.Available || self.Spec.HostToken != c.HostToken {
+		t.Errorf("unexpected local host spec %+v", self.Spec)
+	}
+
+	c.enqueueAll()
+	time.Sleep(100 * time.Millisecond)
+	c.peersLock.Lock()
+	defer c.peersLock.Unlock()
+	if len(c.peers) != 0 {
+		t.Errorf("expected no peers, got %+v", c.peers)
+	}
+}
+
+func TestCordon(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	c, client := newTestController(t, stopCh, newHost("10.1.1.1:8123", "token1"))
+
+	if err := c.Cordon(true); err != nil {
+		t.Fatalf("cordon: %v", err)
+	}
+	self, err := client.HostmanagerV1().Hosts(discovery.HOST_CRD_NAMESPACE).Get(discovery.HostName(c.rserverServerUrl), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get local host: %v", err)
+	}
+	if !self.Spec.Cordoned || self.Spec.HostStatus != hostv1.Available {
+		t.Errorf("unexpected local host spec %+v", self.Spec)
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) { return c.Cordoned(), nil }); err != nil {
+		t.Fatalf("controller not cordoned")
+	}
+	if alternatives := c.Alternatives(); len(alternatives) != 1 || alternatives[0] != "ws://10.1.1.1:8123/connect" {
+		t.Errorf("unexpected alternatives %v", alternatives)
+	}
+}
+
+// newPeerControllers runs n controllers sharing a crd discovery, each one with a real remotedialer
+// server listening on its peer address
+func newPeerControllers(t *testing.T, stopCh chan struct{}, n, fanout int) ([]*Controller, []*remotedialer.Server) {
+	client := fake.NewSimpleClientset()
+	var controllers []*Controller
+	var servers []*remotedialer.Server
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(getFirtIP(), "0"))
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		rserver := remotedialer.New(func(*http.Request) (string, bool, error) { return "", false, nil }, remotedialer.DefaultErrorWriter)
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c := NewController(stopCh, wg, rserver, ":"+port, discovery.NewCRDDiscovery(client), "")
+		c.Fanout = fanout
+		server := &http.Server{Handler: c.PeerHandler(rserver)}
+		go server.Serve(ln)
+		go func() {
+			<-stopCh
+			server.Close()
+		}()
+		controllers = append(controllers, c)
+		servers = append(servers, rserver)
+	}
+	for _, c := range controllers {
+		if err := c.Run(2, stopCh); err != nil {
+			t.Fatalf("run controller: %v", err)
+		}
+	}
+	return controllers, servers
+}
+
+// TestPeerSessions connects two controllers with real remotedialer servers, each one has to
+// see the session the other one connected back with
+func TestPeerSessions(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	controllers, servers := newPeerControllers(t, stopCh, 2, 0)
+
+	a, b := controllers[0], controllers[1]
+	err := wait.Poll(50*time.Millisecond, 20*time.Second, func() (bool, error) {
+		return a.peerConnected(b.rserverServerUrl) && b.peerConnected(a.rserverServerUrl), nil
+	})
+	if err != nil {
+		t.Fatalf("peers not connected, %s: %v, %s: %v", a.rserverServerUrl, a.connected, b.rserverServerUrl, b.connected)
+	}
+	// a peer session is no client session, HasSession does not see it
+	if servers[0].HasSession(b.rserverServerUrl) || servers[1].HasSession(a.rserverServerUrl) {
+		t.Errorf("peer reported as client session")
+	}
+
+	// a wrong token is not the peer, whatever the next handler does with it
+	req := httptest.NewRequest(http.MethodGet, "/connect", nil)
+	req.Header.Set(remotedialer.ID, b.rserverServerUrl)
+	req.Header.Set(remotedialer.Token, "wrong")
+	counted := false
+	a.PeerHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		a.peersLock.Lock()
+		counted = a.connected[b.rserverServerUrl] > 1
+		a.peersLock.Unlock()
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if counted {
+		t.Errorf("connection with a wrong token counted as peer session")
+	}
+}
+
+// TestMeshSessions runs a partial mesh, the linked hosts have to establish sessions in both
+// directions and the others none at all
+func TestMeshSessions(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	const hosts, fanout = 6, 2
+	controllers, _ := newPeerControllers(t, stopCh, hosts, fanout)
+
+	var peers []discovery.Peer
+	for _, c := range controllers {
+		peers = append(peers, c.LocalPeer())
+	}
+	established := func(a, b *Controller) bool {
+		return a.peerConnected(b.rserverServerUrl) && b.peerConnected(a.rserverServerUrl)
+	}
+	links := 0
+	err := wait.Poll(100*time.Millisecond, 30*time.Second, func() (bool, error) {
+		links = 0
+		for i, a := range controllers {
+			for _, b := range controllers[i+1:] {
+				if discovery.Linked(a.rserverServerUrl, b.rserverServerUrl, peers, fanout) {
+					if !established(a, b) {
+						return false, nil
+					}
+					links++
+				}
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("linked hosts not connected")
+	}
+	if links >= hosts*(hosts-1)/2 {
+		t.Fatalf("%d links of %d hosts with fanout %d is a full mesh", links, hosts, fanout)
+	}
+
+	for i, a := range controllers {
+		if n := len(discovery.SelectPeers(a.rserverServerUrl, peers, fanout)); n < fanout {
+			t.Errorf("host %s has %d peers, expected at least %d", a.rserverServerUrl, n, fanout)
+		}
+		for _, b := range controllers[i+1:] {
+			if discovery.Linked(a.rserverServerUrl, b.rserverServerUrl, peers, fanout) {
+				continue
+			}
+			a.peersLock.Lock()
+			_, added := a.peers[b.rserverServerUrl]
+			a.peersLock.Unlock()
+			if added || a.peerConnected(b.rserverServerUrl) || b.peerConnected(a.rserverServerUrl) {
+				t.Errorf("hosts %s and %s are not linked but connected", a.rserverServerUrl, b.rserverServerUrl)
+			}
+		}
+	}
+}
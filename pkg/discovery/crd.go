@@ -0,0 +1,318 @@
+package discovery
+
+import (
+	"fmt"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	hostscheme "hostmanager/pkg/generated/clientset/versioned/scheme"
+	hostinformers "hostmanager/pkg/generated/informers/externalversions"
+	hostlisters "hostmanager/pkg/generated/listers/hostmanager/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	HOST_CRD_NAMESPACE = "default"
+
+	hostAddressIndex = "hostAddress"
+	hostClientsIndex = "hostClients"
+)
+
+// CRDDiscovery discovers peers from the Host custom resources, and publishes the local host as one.
+type CRDDiscovery struct {
+	hostclientset       hostclientset.Interface
+	hostInformerFactory hostinformers.SharedInformerFactory
+	hostInformer        cache.SharedIndexInformer
+	hostLister          hostlisters.HostLister
+}
+
+// NewCRDDiscovery returns a Discovery backed by the Host CRD in HOST_CRD_NAMESPACE.
+func NewCRDDiscovery(hostClient hostclientset.Interface) *CRDDiscovery {
+	utilruntime.Must(hostscheme.AddToScheme(scheme.Scheme))
+
+	hostInformerFactory := hostinformers.NewSharedInformerFactoryWithOptions(hostClient, time.Second, hostinformers.WithNamespace(HOST_CRD_NAMESPACE))
+	hostinformer := hostInformerFactory.Hostmanager().V1().Hosts()
+	// a Host is named after its address, but the address may be edited afterwards
+	utilruntime.Must(hostinformer.Informer().AddIndexers(cache.Indexers{
+		hostAddressIndex: func(obj interface{}) ([]string, error) {
+			return []string{obj.(*hostv1.Host).Spec.HostAddress}, nil
+		},
+		hostClientsIndex: func(obj interface{}) ([]string, error) {
+			return obj.(*hostv1.Host).Status.Clients, nil
+		},
+	}))
+
+	return &CRDDiscovery{
+		hostclientset:       hostClient,
+		hostInformerFactory: hostInformerFactory,
+		hostInformer:        hostinformer.Informer(),
+		hostLister:          hostinformer.Lister(),
+	}
+}
+
+// HostName returns the Host object name of a peer id, ip:port becomes ip-port.
+func HostName(peerid string) string {
+	return strings.Replace(peerid, ":", "-", 1)
+}
+
+func hostToPeer(host *hostv1.Host) Peer {
+	peer := NewPeer(host.Spec.HostAddress, host.Spec.HostToken)
+	peer.Status = host.Spec.HostStatus
+	peer.Info = host.Spec.HostInfo
+	peer.Cordoned = host.Spec.Cordoned
+	return peer
+}
+
+func (d *CRDDiscovery) Start(handler PeerEventHandler, stopCh <-chan struct{}) {
+	d.hostInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			handler.OnAdd(hostToPeer(obj.(*hostv1.Host)))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldHost := old.(*hostv1.Host)
+			newHost := new.(*hostv1.Host)
+			if oldHost.ResourceVersion == newHost.ResourceVersion {
+				//版本一致，就表示没有实际更新的操作，立即返回
+				return
+			}
+			handler.OnUpdate(hostToPeer(oldHost), hostToPeer(newHost))
+		},
+		DeleteFunc: func(obj interface{}) {
+			host, ok := obj.(*hostv1.Host)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					utilruntime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+					return
+				}
+				if host, ok = tombstone.Obj.(*hostv1.Host); !ok {
+					utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+					return
+				}
+			}
+			handler.OnDelete(hostToPeer(host))
+		},
+	})
+	d.hostInformerFactory.Start(stopCh)
+}
+
+func (d *CRDDiscovery) HasSynced() bool {
+	return d.hostInformer.HasSynced()
+}
+
+func (d *CRDDiscovery) Peers() ([]Peer, error) {
+	hosts, err := d.hostLister.Hosts(HOST_CRD_NAMESPACE).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(hosts))
+	for _, host := range hosts {
+		peers = append(peers, hostToPeer(host))
+	}
+	return peers, nil
+}
+
+func (d *CRDDiscovery) Get(id string) (Peer, bool, error) {
+	objs, err := d.hostInformer.GetIndexer().ByIndex(hostAddressIndex, id)
+	if err != nil {
+		return Peer{}, false, err
+	}
+	for _, obj := range objs {
+		if host := obj.(*hostv1.Host); host.Namespace == HOST_CRD_NAMESPACE {
+			return hostToPeer(host), true, nil
+		}
+	}
+	return Peer{}, false, nil
+}
+
+// SetStatus creates or updates the Host of peer. Only the status, and the token and info
+// when they are set in peer, are changed on an existing Host. A conflict with another writer,
+// or a Host created meanwhile, is retried.
+func (d *CRDDiscovery) SetStatus(peer Peer, status string) error {
+	hostcrdname := HostName(peer.ID)
+	hosts := d.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE)
+	retriable := func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		host, err := hosts.Get(hostcrdname, metav1.GetOptions{})
+		if err != nil && errors.IsNotFound(err) {
+			klog.Infof("create peer:[%s] to be %s", hostcrdname, status)
+			info := peer.Info
+			if info == "" {
+				info = fmt.Sprintf("OS:[%s],Arch:[%s],CPUS:[%d]", runtime.GOOS, runtime.GOARCH, runtime.GOMAXPROCS(0))
+			}
+			if _, err := hosts.Create(&hostv1.Host{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      hostcrdname,
+					Namespace: HOST_CRD_NAMESPACE,
+				},
+				Spec: hostv1.HostSpec{
+					HostAddress: peer.ID,
+					HostStatus:  status,
+					HostToken:   peer.Token,
+					HostInfo:    info,
+				},
+			}); err != nil {
+				klog.Errorf("create peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
+				return err
+			}
+			klog.Infof("create peer:[%s] to be %s success", hostcrdname, status)
+		} else if err == nil {
+			//update
+			host = host.DeepCopy()
+			host.Spec.HostAddress = peer.ID
+			host.Spec.HostStatus = status
+			if peer.Token != "" {
+				host.Spec.HostToken = peer.Token
+			}
+			if peer.Info != "" {
+				host.Spec.HostInfo = peer.Info
+			}
+			if _, err = hosts.Update(host); err != nil {
+				klog.Errorf("update peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
+				return err
+			}
+			klog.Infof("update peer:[%s] to be %s success", hostcrdname, status)
+		} else {
+			klog.Errorf("get peer:[%s] to be %s fail:%s", hostcrdname, status, err.Error())
+			return err
+		}
+		return nil
+	})
+}
+
+// SetCordoned sets the cordoned field of the Host of peer, which can as well be edited directly.
+func (d *CRDDiscovery) SetCordoned(peer Peer, cordoned bool) error {
+	hostcrdname := HostName(peer.ID)
+	hosts := d.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		host, err := hosts.Get(hostcrdname, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if host.Spec.Cordoned == cordoned {
+			return nil
+		}
+		host = host.DeepCopy()
+		host.Spec.Cordoned = cordoned
+		_, err = hosts.Update(host)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("set peer:[%s] cordoned %t fail:%s", hostcrdname, cordoned, err.Error())
+		return err
+	}
+	klog.Infof("set peer:[%s] cordoned %t success", hostcrdname, cordoned)
+	return nil
+}
+
+// PublishSessions sets the sessions, and their client ids, in the status of the Host of peer.
+func (d *CRDDiscovery) PublishSessions(peer Peer, sessions []hostv1.ClientSession) error {
+	hostcrdname := HostName(peer.ID)
+	hosts := d.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE)
+	var clients []string
+	for _, session := range sessions {
+		if len(clients) == 0 || clients[len(clients)-1] != session.ClientID {
+			clients = append(clients, session.ClientID)
+		}
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		host, err := hosts.Get(hostcrdname, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(host.Status.Clients, clients) && equality.Semantic.DeepEqual(host.Status.Sessions, sessions) {
+			return nil
+		}
+		host = host.DeepCopy()
+		host.Status.Clients = clients
+		host.Status.Sessions = sessions
+		if _, err = hosts.UpdateStatus(host); err != nil {
+			return err
+		}
+		klog.Infof("publish peer:[%s] clients %v", hostcrdname, clients)
+		return nil
+	})
+}
+
+func (d *CRDDiscovery) Owners(clientID string) ([]Peer, error) {
+	objs, err := d.hostInformer.GetIndexer().ByIndex(hostClientsIndex, clientID)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(objs))
+	for _, obj := range objs {
+		if host := obj.(*hostv1.Host); host.Namespace == HOST_CRD_NAMESPACE {
+			peers = append(peers, hostToPeer(host))
+		}
+	}
+	return peers, nil
+}
+
+func (d *CRDDiscovery) Sessions(clientID string) ([]PeerSession, error) {
+	objs, err := d.hostInformer.GetIndexer().ByIndex(hostClientsIndex, clientID)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []PeerSession
+	for _, obj := range objs {
+		host := obj.(*hostv1.Host)
+		if host.Namespace != HOST_CRD_NAMESPACE {
+			continue
+		}
+		for _, session := range host.Status.Sessions {
+			if session.ClientID == clientID {
+				sessions = append(sessions, PeerSession{Peer: hostToPeer(host), Session: session})
+			}
+		}
+	}
+	return sessions, nil
+}
+
+func (d *CRDDiscovery) Select(selector labels.Selector) ([]PeerSession, error) {
+	hosts, err := d.hostLister.Hosts(HOST_CRD_NAMESPACE).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var sessions []PeerSession
+	for _, host := range hosts {
+		for _, session := range host.Status.Sessions {
+			if selector.Matches(labels.Set(session.Labels)) {
+				sessions = append(sessions, PeerSession{Peer: hostToPeer(host), Session: session})
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// Remove deletes the Host of peer.
+func (d *CRDDiscovery) Remove(peer Peer) error {
+	hostcrdname := HostName(peer.ID)
+	hosts := d.hostclientset.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE)
+	_, err := hosts.Get(hostcrdname, metav1.GetOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		klog.Infof("delete peer:[%s] already deleted", hostcrdname)
+	} else if err == nil {
+		//delete
+		if err = hosts.Delete(hostcrdname, &metav1.DeleteOptions{}); err != nil {
+			klog.Errorf("delete peer:[%s] fail:%s", hostcrdname, err.Error())
+			return err
+		}
+		klog.Infof("delete peer:[%s] success", hostcrdname)
+	} else {
+		klog.Errorf("get peer:[%s] fail:%s", hostcrdname, err.Error())
+		return err
+	}
+	return nil
+}
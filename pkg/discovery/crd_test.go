@@ -0,0 +1,42 @@
+package discovery
+
+import (
+	"testing"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/generated/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSetStatusRetriesConflicts(t *testing.T) {
+	peer := NewPeer("10.0.0.1:8123", "token")
+	client := fake.NewSimpleClientset()
+	d := NewCRDDiscovery(client)
+	if err := d.SetStatus(peer, hostv1.Available); err != nil {
+		t.Fatalf("create host: %v", err)
+	}
+
+	// another writer updates the host between our get and update, twice
+	conflicts := 2
+	client.PrependReactor("update", "hosts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, errors.NewConflict(schema.GroupResource{Resource: "hosts"}, HostName(peer.ID), nil)
+	})
+	if err := d.SetStatus(peer, hostv1.Draining); err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+	host, err := client.HostmanagerV1().Hosts(HOST_CRD_NAMESPACE).Get(HostName(peer.ID), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get host: %v", err)
+	}
+	if conflicts != 0 || host.Spec.HostStatus != hostv1.Draining {
+		t.Errorf("status %s after %d conflicts left", host.Spec.HostStatus, conflicts)
+	}
+}
@@ -0,0 +1,244 @@
+package discovery
+
+import (
+	"fmt"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	CRD       = "crd"
+	STATIC    = "static"
+	DNS       = "dns"
+	ENDPOINTS = "endpoints"
+)
+
+// Peer is another hostmanager instance that we keep a remotedialer peer connection with.
+type Peer struct {
+	// ID is the remotedialer peer id, which is the ip:port the peer serves on.
+	ID     string
+	URL    string
+	Token  string
+	Status string
+	Info   string
+	// Cordoned peers refuse new agent sessions and get no new requests routed to them
+	Cordoned bool
+}
+
+// NewPeer returns a peer serving /connect on address.
+func NewPeer(address, token string) Peer {
+	return Peer{
+		ID:    address,
+		URL:   fmt.Sprintf("ws://%s/connect", address),
+		Token: token,
+	}
+}
+
+// Schedulable returns true if new agent sessions and requests may go to the peer.
+// Read only backends do not know the status of their peers, it is empty then.
+func (p Peer) Schedulable() bool {
+	return !p.Cordoned && (p.Status == "" || p.Status == hostv1.Available)
+}
+
+// PeerEventHandler is notified when the peers known by a Discovery change.
+type PeerEventHandler interface {
+	OnAdd(peer Peer)
+	OnUpdate(oldPeer, newPeer Peer)
+	OnDelete(peer Peer)
+}
+
+// PeerEventHandlerFuncs is an adaptor to let you easily specify as many or
+// as few of the notification functions as you want while still implementing
+// PeerEventHandler.
+type PeerEventHandlerFuncs struct {
+	AddFunc    func(peer Peer)
+	UpdateFunc func(oldPeer, newPeer Peer)
+	DeleteFunc func(peer Peer)
+}
+
+func (r PeerEventHandlerFuncs) OnAdd(peer Peer) {
+	if r.AddFunc != nil {
+		r.AddFunc(peer)
+	}
+}
+
+func (r PeerEventHandlerFuncs) OnUpdate(oldPeer, newPeer Peer) {
+	if r.UpdateFunc != nil {
+		r.UpdateFunc(oldPeer, newPeer)
+	}
+}
+
+func (r PeerEventHandlerFuncs) OnDelete(peer Peer) {
+	if r.DeleteFunc != nil {
+		r.DeleteFunc(peer)
+	}
+}
+
+// Discovery finds the other hostmanager instances.
+type Discovery interface {
+	// Start watches the backend until stopCh is closed, delivering peer changes to handler.
+	Start(handler PeerEventHandler, stopCh <-chan struct{})
+	// HasSynced returns true once the first list of peers has been loaded.
+	HasSynced() bool
+	// Peers returns all known peers, including the local one if it is published.
+	Peers() ([]Peer, error)
+	// Get returns the peer with the given id.
+	Get(id string) (Peer, bool, error)
+	// SetStatus publishes peer with status. Read only backends ignore it.
+	SetStatus(peer Peer, status string) error
+	// SetCordoned cordons or uncordons peer. Read only backends only keep it locally.
+	SetCordoned(peer Peer, cordoned bool) error
+	// Remove withdraws a peer published by SetStatus. Read only backends ignore it.
+	Remove(peer Peer) error
+}
+
+// Directory records which peers the tunnel clients are connected to, so a request for a
+// client can be forwarded to its owner instead of every peer being connected with every other.
+type Directory interface {
+	// PublishSessions records the tunnel client sessions on peer.
+	PublishSessions(peer Peer, sessions []hostv1.ClientSession) error
+	// Owners returns the peers clientID is connected to.
+	Owners(clientID string) ([]Peer, error)
+	// Sessions returns the sessions of clientID on all peers.
+	Sessions(clientID string) ([]PeerSession, error)
+	// Select returns the sessions on all peers whose labels match selector.
+	Select(selector labels.Selector) ([]PeerSession, error)
+}
+
+// PeerSession is a tunnel client session on a peer
+type PeerSession struct {
+	Peer    Peer
+	Session hostv1.ClientSession
+}
+
+// peerStore keeps the last list of peers of a backend that can only list,
+// and turns a new list into add/update/delete events.
+type peerStore struct {
+	sync.RWMutex
+	peers   map[string]Peer
+	synced  bool
+	handler PeerEventHandler
+	// cordoned are the peers cordoned by SetCordoned, the backend knows nothing about it
+	cordoned map[string]bool
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{
+		peers:    map[string]Peer{},
+		cordoned: map[string]bool{},
+	}
+}
+
+func (s *peerStore) setHandler(handler PeerEventHandler) {
+	s.Lock()
+	defer s.Unlock()
+	s.handler = handler
+}
+
+// replace sets the peers to list and notifies the handler of the difference.
+func (s *peerStore) replace(list []Peer) {
+	s.Lock()
+	old := s.peers
+	s.peers = make(map[string]Peer, len(list))
+	for _, peer := range list {
+		peer.Cordoned = s.cordoned[peer.ID]
+		s.peers[peer.ID] = peer
+	}
+	s.synced = true
+	current := s.peers
+	handler := s.handler
+	s.Unlock()
+
+	s.notify(old, current, handler)
+}
+
+// notify delivers the difference between the old and current peers to handler
+func (s *peerStore) notify(old, current map[string]Peer, handler PeerEventHandler) {
+	if handler == nil {
+		return
+	}
+	for id, peer := range current {
+		if oldPeer, ok := old[id]; !ok {
+			klog.Infof("discovery peer[%s] added", id)
+			handler.OnAdd(peer)
+		} else if oldPeer != peer {
+			klog.Infof("discovery peer[%s] updated", id)
+			handler.OnUpdate(oldPeer, peer)
+		}
+	}
+	for id, peer := range old {
+		if _, ok := current[id]; !ok {
+			klog.Infof("discovery peer[%s] deleted", id)
+			handler.OnDelete(peer)
+		}
+	}
+}
+
+func (s *peerStore) HasSynced() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.synced
+}
+
+func (s *peerStore) Peers() ([]Peer, error) {
+	s.RLock()
+	defer s.RUnlock()
+	peers := make([]Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+func (s *peerStore) Get(id string) (Peer, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	peer, ok := s.peers[id]
+	return peer, ok, nil
+}
+
+func (s *peerStore) SetStatus(peer Peer, status string) error {
+	return nil
+}
+
+func (s *peerStore) SetCordoned(peer Peer, cordoned bool) error {
+	s.Lock()
+	if cordoned {
+		s.cordoned[peer.ID] = true
+	} else {
+		delete(s.cordoned, peer.ID)
+	}
+	old := s.peers
+	current := make(map[string]Peer, len(old))
+	for id, p := range old {
+		p.Cordoned = s.cordoned[id]
+		current[id] = p
+	}
+	s.peers = current
+	handler := s.handler
+	s.Unlock()
+
+	s.notify(old, current, handler)
+	return nil
+}
+
+func (s *peerStore) Remove(peer Peer) error {
+	return nil
+}
+
+// poll lists the peers every interval until stopCh is closed.
+func (s *peerStore) poll(name string, list func() ([]Peer, error), interval time.Duration, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		peers, err := list()
+		if err != nil {
+			klog.Errorf("%s discovery list peers fail:%s", name, err.Error())
+			return
+		}
+		s.replace(peers)
+	}, interval, stopCh)
+}
@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParsePeers(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		peers []Peer
+		err   string
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# peers\n\n   \n  # indented comment\n",
+		},
+		{
+			name:  "default url",
+			input: "10.0.0.1:8123 secret\n",
+			peers: []Peer{{ID: "10.0.0.1:8123", URL: "ws://10.0.0.1:8123/connect", Token: "secret"}},
+		},
+		{
+			name:  "explicit url and surrounding spaces",
+			input: "  10.0.0.1:8123   secret   wss://hm-1.example.com/connect  \n10.0.0.2:8123 other\n",
+			peers: []Peer{
+				{ID: "10.0.0.1:8123", URL: "wss://hm-1.example.com/connect", Token: "secret"},
+				{ID: "10.0.0.2:8123", URL: "ws://10.0.0.2:8123/connect", Token: "other"},
+			},
+		},
+		{
+			name:  "missing token",
+			input: "# peers\n10.0.0.1:8123\n",
+			err:   "peers:2:",
+		},
+		{
+			name:  "too many fields",
+			input: "10.0.0.1:8123 secret ws://10.0.0.1:8123/connect extra\n",
+			err:   "peers:1:",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			peers, err := parsePeers("peers", strings.NewReader(c.input))
+			if c.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse fail:%s", err)
+			}
+			if !reflect.DeepEqual(peers, c.peers) {
+				t.Fatalf("peers %v, expected %v", peers, c.peers)
+			}
+		})
+	}
+}
+
+// recorder records the peer events as "add:<id>", "update:<id>:<token>" and "delete:<id>"
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) OnAdd(peer Peer) {
+	r.events = append(r.events, "add:"+peer.ID)
+}
+
+func (r *recorder) OnUpdate(oldPeer, newPeer Peer) {
+	r.events = append(r.events, "update:"+newPeer.ID+":"+newPeer.Token)
+}
+
+func (r *recorder) OnDelete(peer Peer) {
+	r.events = append(r.events, "delete:"+peer.ID)
+}
+
+func (r *recorder) take() []string {
+	events := r.events
+	r.events = nil
+	sort.Strings(events)
+	return events
+}
+
+func TestPeerStoreReplace(t *testing.T) {
+	store := newPeerStore()
+	events := &recorder{}
+	store.setHandler(events)
+	if store.HasSynced() {
+		t.Fatalf("synced before the first list")
+	}
+
+	a, b, c := NewPeer("10.0.0.1:8123", "a"), NewPeer("10.0.0.2:8123", "b"), NewPeer("10.0.0.3:8123", "c")
+	store.replace([]Peer{a, b})
+	if !store.HasSynced() {
+		t.Fatalf("not synced after the first list")
+	}
+	if got, expected := events.take(), []string{"add:10.0.0.1:8123", "add:10.0.0.2:8123"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("events %v, expected %v", got, expected)
+	}
+
+	// the same list again is no change
+	store.replace([]Peer{b, a})
+	if got := events.take(); len(got) != 0 {
+		t.Fatalf("unchanged list notified %v", got)
+	}
+
+	// b changes its token, a is gone and c is new
+	b2 := b
+	b2.Token = "b2"
+	store.replace([]Peer{b2, c})
+	expected := []string{"add:10.0.0.3:8123", "delete:10.0.0.1:8123", "update:10.0.0.2:8123:b2"}
+	if got := events.take(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("events %v, expected %v", got, expected)
+	}
+	if _, ok, _ := store.Get(a.ID); ok {
+		t.Fatalf("deleted peer %s still known", a.ID)
+	}
+	if peer, _, _ := store.Get(b.ID); peer.Token != "b2" {
+		t.Fatalf("peer %s token %q, expected b2", b.ID, peer.Token)
+	}
+
+	// a cordon survives the next list and the list keeps the cordon
+	store.SetCordoned(c, true)
+	if got, expected := events.take(), []string{"update:10.0.0.3:8123:c"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("events %v, expected %v", got, expected)
+	}
+	store.replace([]Peer{b2, c})
+	if got := events.take(); len(got) != 0 {
+		t.Fatalf("unchanged list of a cordoned peer notified %v", got)
+	}
+	if peer, _, _ := store.Get(c.ID); !peer.Cordoned {
+		t.Fatalf("peer %s lost its cordon", c.ID)
+	}
+
+	store.replace(nil)
+	expected = []string{"delete:10.0.0.2:8123", "delete:10.0.0.3:8123"}
+	if got := events.take(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("events %v, expected %v", got, expected)
+	}
+	if peers, _ := store.Peers(); len(peers) != 0 {
+		t.Fatalf("peers %v left after an empty list", peers)
+	}
+}
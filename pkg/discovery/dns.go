@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSDiscovery resolves the peers from DNS. A name like _connect._tcp.hostmanager.default.svc
+// is looked up as SRV records, any other name as A records (e.g. a headless Service) with every
+// address serving on port. All peers share token.
+type DNSDiscovery struct {
+	*peerStore
+	name     string
+	port     string
+	token    string
+	interval time.Duration
+}
+
+func NewDNSDiscovery(name, port, token string, interval time.Duration) *DNSDiscovery {
+	return &DNSDiscovery{
+		peerStore: newPeerStore(),
+		name:      name,
+		port:      strings.TrimPrefix(port, ":"),
+		token:     token,
+		interval:  interval,
+	}
+}
+
+func (d *DNSDiscovery) Start(handler PeerEventHandler, stopCh <-chan struct{}) {
+	d.setHandler(handler)
+	d.poll(DNS, d.list, d.interval, stopCh)
+}
+
+func (d *DNSDiscovery) list() ([]Peer, error) {
+	if strings.HasPrefix(d.name, "_") {
+		return d.listSRV()
+	}
+	addrs, err := net.LookupHost(d.name)
+	if err != nil {
+		return nil, err
+	}
+	var peers []Peer
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			peers = append(peers, NewPeer(net.JoinHostPort(addr, d.port), d.token))
+		}
+	}
+	return peers, nil
+}
+
+func (d *DNSDiscovery) listSRV() ([]Peer, error) {
+	_, srvs, err := net.LookupSRV("", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+	var peers []Peer
+	for _, srv := range srvs {
+		// peer ids are ip:port, so the srv target has to be resolved
+		addrs, err := net.LookupHost(srv.Target)
+		if err != nil {
+			return nil, fmt.Errorf("lookup srv target %s: %v", srv.Target, err)
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+				peers = append(peers, NewPeer(net.JoinHostPort(addr, strconv.Itoa(int(srv.Port))), d.token))
+				break
+			}
+		}
+	}
+	return peers, nil
+}
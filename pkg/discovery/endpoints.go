@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// EndpointsDiscovery discovers the peers from the ready addresses of a Kubernetes Endpoints,
+// usually the one of the Service in front of hostmanager. The port is the Endpoints port named
+// port, or port itself when it is a number. All peers share token.
+type EndpointsDiscovery struct {
+	*peerStore
+	kubeclientset kubernetes.Interface
+	namespace     string
+	name          string
+	port          string
+	token         string
+}
+
+func NewEndpointsDiscovery(kubeClient kubernetes.Interface, namespace, name, port, token string) *EndpointsDiscovery {
+	return &EndpointsDiscovery{
+		peerStore:     newPeerStore(),
+		kubeclientset: kubeClient,
+		namespace:     namespace,
+		name:          name,
+		port:          strings.TrimPrefix(port, ":"),
+		token:         token,
+	}
+}
+
+func (d *EndpointsDiscovery) Start(handler PeerEventHandler, stopCh <-chan struct{}) {
+	d.setHandler(handler)
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(d.kubeclientset, 30*time.Second,
+		informers.WithNamespace(d.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", d.name).String()
+		}))
+	informerFactory.Core().V1().Endpoints().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			d.replace(d.endpointsToPeers(obj.(*corev1.Endpoints)))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			d.replace(d.endpointsToPeers(new.(*corev1.Endpoints)))
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.Infof("endpoints %s/%s deleted", d.namespace, d.name)
+			d.replace(nil)
+		},
+	})
+	informerFactory.Start(stopCh)
+	go func() {
+		if cache.WaitForCacheSync(stopCh, informerFactory.Core().V1().Endpoints().Informer().HasSynced) {
+			// the endpoints may not exist yet, which is still a synced empty list
+			if !d.peerStore.HasSynced() {
+				d.replace(nil)
+			}
+		}
+	}()
+}
+
+func (d *EndpointsDiscovery) endpointsToPeers(endpoints *corev1.Endpoints) []Peer {
+	var peers []Peer
+	for _, subset := range endpoints.Subsets {
+		port := d.port
+		for _, p := range subset.Ports {
+			if p.Name == d.port {
+				port = strconv.Itoa(int(p.Port))
+				break
+			}
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			klog.Errorf("endpoints %s/%s has no port %s", d.namespace, d.name, d.port)
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			peers = append(peers, NewPeer(net.JoinHostPort(addr.IP, port), d.token))
+		}
+	}
+	return peers
+}
@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"sort"
+)
+
+// SelectPeers returns the peers self keeps a remotedialer connection with. With fanout 0 that is
+// every peer (full mesh). Otherwise every host picks the fanout peers with the highest rendezvous
+// hash of the pair, and a pair is linked when either side picks the other, so both sides agree on
+// the link and every host has at least fanout peers. The selection is sorted by id.
+func SelectPeers(self string, peers []Peer, fanout int) []Peer {
+	var selected []Peer
+	for _, peer := range peers {
+		if peer.ID != self && Linked(self, peer.ID, peers, fanout) {
+			selected = append(selected, peer)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].ID < selected[j].ID })
+	return selected
+}
+
+// Linked returns true if the hosts a and b keep a connection with each other
+func Linked(a, b string, peers []Peer, fanout int) bool {
+	if a == b {
+		return false
+	}
+	if fanout <= 0 {
+		return true
+	}
+	return picks(a, b, peers, fanout) || picks(b, a, peers, fanout)
+}
+
+// picks returns true if peer, one of peers, is one of the fanout peers self picks. It counts the peers
+// scoring higher for self instead of sorting them, Linked is called for every peer on every sync.
+func picks(self, peer string, peers []Peer, fanout int) bool {
+	score := rendezvousScore(self, peer)
+	higher := 0
+	for _, p := range peers {
+		if p.ID == self || p.ID == peer || rendezvousScore(self, p.ID) <= score {
+			continue
+		}
+		if higher++; higher >= fanout {
+			return false
+		}
+	}
+	return true
+}
+
+// topPeers returns the fanout peers with the highest score for self, all of them with fanout 0
+func topPeers(self string, peers []Peer, fanout int) []Peer {
+	type scoredPeer struct {
+		score uint64
+		peer  Peer
+	}
+	scored := make([]scoredPeer, 0, len(peers))
+	for _, peer := range peers {
+		if peer.ID != self {
+			scored = append(scored, scoredPeer{rendezvousScore(self, peer.ID), peer})
+		}
+	}
+	if fanout > 0 && len(scored) > fanout {
+		sort.Slice(scored, func(i, j int) bool {
+			return scored[i].score > scored[j].score
+		})
+		scored = scored[:fanout]
+	}
+	selected := make([]Peer, len(scored))
+	for i := range scored {
+		selected[i] = scored[i].peer
+	}
+	return selected
+}
+
+// rendezvousScore scores the unordered pair, both hosts compute the same score for it
+func rendezvousScore(self, peer string) uint64 {
+	if peer < self {
+		self, peer = peer, self
+	}
+	// fnv-1a, inlined as it is computed for every pair of hosts
+	x := uint64(14695981039346656037)
+	for _, s := range []string{self, "\x00", peer} {
+		for i := 0; i < len(s); i++ {
+			x ^= uint64(s[i])
+			x *= 1099511628211
+		}
+	}
+	// fnv alone hardly mixes addresses that only differ at the end
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testPeers(n int) []Peer {
+	peers := make([]Peer, n)
+	for i := range peers {
+		peers[i] = NewPeer(fmt.Sprintf("10.0.%d.%d:8123", i/250, i%250+1), "token")
+	}
+	return peers
+}
+
+func TestSelectPeers(t *testing.T) {
+	peers := testPeers(100)
+	self := peers[0].ID
+
+	all := SelectPeers(self, peers, 0)
+	if len(all) != 99 {
+		t.Fatalf("full mesh selected %d peers, expected 99", len(all))
+	}
+	for _, peer := range all {
+		if peer.ID == self {
+			t.Fatalf("self selected")
+		}
+	}
+
+	selected := SelectPeers(self, peers, 5)
+	if len(selected) < 5 {
+		t.Fatalf("selected %d peers, expected at least 5", len(selected))
+	}
+	// the selection only depends on the peer set, not on its order
+	reversed := make([]Peer, len(peers))
+	for i, peer := range peers {
+		reversed[len(peers)-1-i] = peer
+	}
+	for i, peer := range SelectPeers(self, reversed, 5) {
+		if peer != selected[i] {
+			t.Fatalf("selection changed with peer order: %v != %v", peer, selected[i])
+		}
+	}
+}
+
+func TestSelectPeersSymmetric(t *testing.T) {
+	peers := testPeers(50)
+	for _, fanout := range []int{1, 3, 5} {
+		linked := map[string]map[string]bool{}
+		for _, self := range peers {
+			linked[self.ID] = map[string]bool{}
+			selected := SelectPeers(self.ID, peers, fanout)
+			if len(selected) < fanout {
+				t.Fatalf("fanout %d: %s selected %d peers", fanout, self.ID, len(selected))
+			}
+			for _, peer := range selected {
+				linked[self.ID][peer.ID] = true
+			}
+		}
+		conns := 0
+		for a := range linked {
+			for b := range linked[a] {
+				if !linked[b][a] {
+					t.Fatalf("fanout %d: %s selects %s, but not the other way round", fanout, a, b)
+				}
+				if !Linked(a, b, peers, fanout) {
+					t.Fatalf("fanout %d: %s and %s selected but not linked", fanout, a, b)
+				}
+				conns++
+			}
+		}
+		// every host picks fanout peers, a link picked by both sides counts once
+		if conns > 2*fanout*len(peers) {
+			t.Fatalf("fanout %d: %d connections", fanout, conns)
+		}
+	}
+}
+
+func TestPicks(t *testing.T) {
+	peers := testPeers(100)
+	for _, self := range peers[:10] {
+		top := map[string]bool{}
+		for _, peer := range topPeers(self.ID, peers, 5) {
+			top[peer.ID] = true
+		}
+		for _, peer := range peers {
+			if peer.ID != self.ID && picks(self.ID, peer.ID, peers, 5) != top[peer.ID] {
+				t.Fatalf("%s picks %s: expected %v", self.ID, peer.ID, top[peer.ID])
+			}
+		}
+	}
+}
+
+// links returns the number of distinct connections of the mesh, every host keeps one per selected peer
+func links(peers []Peer, fanout int) int {
+	if fanout <= 0 {
+		return len(peers) * (len(peers) - 1) / 2
+	}
+	pairs := map[[2]string]bool{}
+	for _, self := range peers {
+		for _, peer := range topPeers(self.ID, peers, fanout) {
+			pair := [2]string{self.ID, peer.ID}
+			if pair[1] < pair[0] {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+			pairs[pair] = true
+		}
+	}
+	return len(pairs)
+}
+
+func BenchmarkSelectPeers(b *testing.B) {
+	for _, hosts := range []int{100, 500, 1000} {
+		peers := testPeers(hosts)
+		for _, fanout := range []int{0, 5} {
+			connections := links(peers, fanout)
+			b.Run(fmt.Sprintf("hosts=%d/fanout=%d", hosts, fanout), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					SelectPeers(peers[i%hosts].ID, peers, fanout)
+				}
+				b.ReportMetric(float64(connections), "links")
+				b.ReportMetric(float64(2*connections)/float64(hosts), "links/host")
+			})
+			// desiredPeer checks one peer per sync of the controller
+			b.Run(fmt.Sprintf("hosts=%d/fanout=%d/Linked", hosts, fanout), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					Linked(peers[i%hosts].ID, peers[(i+1)%hosts].ID, peers, fanout)
+				}
+			})
+		}
+	}
+}
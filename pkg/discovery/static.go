@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// StaticDiscovery reads the peers from a file, one peer per line:
+//
+//	<address> <token> [url]
+//
+// the url defaults to ws://<address>/connect. Empty lines and lines starting with # are skipped.
+// The file is read again every interval, so peers can be changed without a restart.
+type StaticDiscovery struct {
+	*peerStore
+	path     string
+	interval time.Duration
+}
+
+func NewStaticDiscovery(path string, interval time.Duration) *StaticDiscovery {
+	return &StaticDiscovery{
+		peerStore: newPeerStore(),
+		path:      path,
+		interval:  interval,
+	}
+}
+
+func (d *StaticDiscovery) Start(handler PeerEventHandler, stopCh <-chan struct{}) {
+	d.setHandler(handler)
+	d.poll(STATIC, d.list, d.interval, stopCh)
+}
+
+func (d *StaticDiscovery) list() ([]Peer, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePeers(d.path, f)
+}
+
+func parsePeers(name string, r io.Reader) ([]Peer, error) {
+	var peers []Peer
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Fields(text)
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%s:%d: expect <address> <token> [url]", name, line)
+		}
+		peer := NewPeer(parts[0], parts[1])
+		if len(parts) == 3 {
+			peer.URL = parts[2]
+		}
+		peers = append(peers, peer)
+	}
+	return peers, scanner.Err()
+}
@@ -0,0 +1,239 @@
+package dns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"hostmanager/pkg/forward"
+	"hostmanager/pkg/proxy"
+	"k8s.io/klog"
+)
+
+const (
+	// TTL of the answers, clients come and go
+	TTL = 5
+	// UDP_SIZE is the largest query read
+	UDP_SIZE = 512
+)
+
+// Records are the names hostmanager answers for
+type Records interface {
+	// Reachable returns true if the client is connected to any host
+	Reachable(clientID string) bool
+	// Ports returns the ports hostmanager listens on for the client
+	Ports(clientID string) []int
+	// Hosts returns other names of hostmanager, e.g. the hosts of the TunnelRoutes
+	Hosts() []string
+}
+
+// Server answers in zone <svc>.<clientid>.<zone> and <clientid>.<zone> with the address of hostmanager when
+// the client is connected, and SRV queries for <clientid>.<zone> with the ports listening for the client
+type Server struct {
+	zone    string
+	ip      net.IP
+	records Records
+}
+
+func NewServer(zone string, ip net.IP, records Records) *Server {
+	return &Server{
+		zone:    strings.ToLower(strings.TrimSuffix(zone, ".")) + ".",
+		ip:      ip,
+		records: records,
+	}
+}
+
+// ListenAndServe serves the queries on udp addr until the listener fails
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve serves the queries on conn until it is closed
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, UDP_SIZE)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := s.handle(buf[:n])
+		if err != nil {
+			klog.Errorf("dns query from %s fail:%s", addr, err.Error())
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			klog.Errorf("dns answer to %s fail:%s", addr, err.Error())
+		}
+	}
+}
+
+func (s *Server) handle(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	answer := &answer{header: dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		RecursionDesired: header.RecursionDesired,
+	}}
+	if header.OpCode != 0 || q.Class != dnsmessage.ClassINET {
+		answer.header.RCode = dnsmessage.RCodeNotImplemented
+	} else {
+		s.resolve(q, answer)
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, UDP_SIZE), answer.header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, rr := range answer.answers {
+		if err := rr(&b); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	for _, rr := range answer.additionals {
+		if err := rr(&b); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// answer collects the header and records of the answer to a question
+type answer struct {
+	header      dnsmessage.Header
+	answers     []func(*dnsmessage.Builder) error
+	additionals []func(*dnsmessage.Builder) error
+}
+
+// resolve answers q, the zone is not case sensitive, the client id keeps the case of the question
+func (s *Server) resolve(q dnsmessage.Question, a *answer) {
+	original := q.Name.String()
+	name := strings.ToLower(original)
+	inZone := name == s.zone || strings.HasSuffix(name, "."+s.zone)
+	if !inZone && !s.isHost(name) {
+		a.header.RCode = dnsmessage.RCodeRefused
+		return
+	}
+	a.header.Authoritative = true
+	if !inZone || name == s.zone {
+		a.address(q.Name, q.Type, s.ip)
+		return
+	}
+
+	labels := strings.Split(original[:len(original)-len(s.zone)-1], ".")
+	clientID := labels[len(labels)-1]
+	if !s.records.Reachable(clientID) {
+		a.header.RCode = dnsmessage.RCodeNameError
+		return
+	}
+	if q.Type == dnsmessage.TypeSRV && len(labels) == 1 {
+		ports := s.records.Ports(clientID)
+		sort.Ints(ports)
+		for _, port := range ports {
+			srv := &dnsmessage.SRVResource{Target: q.Name, Port: uint16(port)}
+			a.answers = append(a.answers, func(b *dnsmessage.Builder) error {
+				return b.SRVResource(header(q.Name, dnsmessage.TypeSRV), *srv)
+			})
+		}
+		if len(ports) > 0 {
+			a.additional(q.Name, s.ip)
+		}
+		return
+	}
+	a.address(q.Name, q.Type, s.ip)
+}
+
+// isHost returns true if name is one of the other names of hostmanager
+func (s *Server) isHost(name string) bool {
+	for _, host := range s.records.Hosts() {
+		if strings.ToLower(strings.TrimSuffix(host, "."))+"." == name {
+			return true
+		}
+	}
+	return false
+}
+
+// address answers A or AAAA queries for name with ip, the other types have no answer
+func (a *answer) address(name dnsmessage.Name, qtype dnsmessage.Type, ip net.IP) {
+	if rr := addressResource(name, qtype, ip); rr != nil {
+		a.answers = append(a.answers, rr)
+	}
+}
+
+func (a *answer) additional(name dnsmessage.Name, ip net.IP) {
+	qtype := dnsmessage.TypeA
+	if ip.To4() == nil {
+		qtype = dnsmessage.TypeAAAA
+	}
+	a.additionals = append(a.additionals, addressResource(name, qtype, ip))
+}
+
+func addressResource(name dnsmessage.Name, qtype dnsmessage.Type, ip net.IP) func(*dnsmessage.Builder) error {
+	if ip4 := ip.To4(); ip4 != nil && qtype == dnsmessage.TypeA {
+		rr := dnsmessage.AResource{}
+		copy(rr.A[:], ip4)
+		return func(b *dnsmessage.Builder) error { return b.AResource(header(name, qtype), rr) }
+	}
+	if ip.To4() == nil && len(ip) == net.IPv6len && qtype == dnsmessage.TypeAAAA {
+		rr := dnsmessage.AAAAResource{}
+		copy(rr.AAAA[:], ip)
+		return func(b *dnsmessage.Builder) error { return b.AAAAResource(header(name, qtype), rr) }
+	}
+	return nil
+}
+
+func header(name dnsmessage.Name, qtype dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name, Type: qtype, Class: dnsmessage.ClassINET, TTL: TTL}
+}
+
+// records are the clients reachable through the proxy, the ports of the forwards and the hosts of the routes
+type records struct {
+	proxy    *proxy.Proxy
+	forwards *forward.Manager
+}
+
+func NewRecords(p *proxy.Proxy, forwards *forward.Manager) Records {
+	return &records{proxy: p, forwards: forwards}
+}
+
+func (r *records) Reachable(clientID string) bool {
+	return r.proxy.Reachable(clientID)
+}
+
+func (r *records) Ports(clientID string) []int {
+	var ports []int
+	for _, spec := range r.forwards.Listening() {
+		if spec.ClientID == clientID {
+			ports = append(ports, spec.Port)
+		}
+	}
+	return ports
+}
+
+func (r *records) Hosts() []string {
+	return r.proxy.RouteHosts()
+}
@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type fakeRecords struct {
+	clients map[string][]int
+	hosts   []string
+}
+
+func (f fakeRecords) Reachable(clientID string) bool {
+	_, ok := f.clients[clientID]
+	return ok
+}
+
+func (f fakeRecords) Ports(clientID string) []int { return f.clients[clientID] }
+func (f fakeRecords) Hosts() []string             { return f.hosts }
+
+// query sends a question to the server at addr over udp
+func query(t *testing.T, addr net.Addr, name string, qtype dnsmessage.Type) dnsmessage.Message {
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		t.Fatalf("pack %s: %v", name, err)
+	}
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(packed); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, UDP_SIZE)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read answer to %s: %v", name, err)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(buf[:n]); err != nil {
+		t.Fatalf("unpack answer to %s: %v", name, err)
+	}
+	if m.ID != 42 || !m.Response {
+		t.Fatalf("unexpected header %+v", m.Header)
+	}
+	return m
+}
+
+func TestServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+	records := fakeRecords{
+		clients: map[string][]int{"foo": {30022, 30021}, "Bar": nil},
+		hosts:   []string{"app.example.com"},
+	}
+	s := NewServer("tunnel.", net.ParseIP("10.1.1.1"), records)
+	go s.Serve(conn)
+	addr := conn.LocalAddr()
+
+	for _, name := range []string{"foo.tunnel.", "db.foo.TUNNEL.", "a.b.Bar.tunnel.", "app.example.com."} {
+		m := query(t, addr, name, dnsmessage.TypeA)
+		if m.RCode != dnsmessage.RCodeSuccess || !m.Authoritative || len(m.Answers) != 1 {
+			t.Errorf("%s: unexpected answer %+v", name, m)
+			continue
+		}
+		a, ok := m.Answers[0].Body.(*dnsmessage.AResource)
+		if !ok || net.IP(a.A[:]).String() != "10.1.1.1" || m.Answers[0].Header.TTL != TTL {
+			t.Errorf("%s: unexpected A %+v", name, m.Answers[0])
+		}
+	}
+
+	if m := query(t, addr, "db.foo.tunnel.", dnsmessage.TypeAAAA); m.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 0 {
+		t.Errorf("AAAA: unexpected answer %+v", m)
+	}
+	if m := query(t, addr, "db.baz.tunnel.", dnsmessage.TypeA); m.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("disconnected client: expected NXDOMAIN, got %+v", m.Header)
+	}
+	if m := query(t, addr, "example.org.", dnsmessage.TypeA); m.RCode != dnsmessage.RCodeRefused {
+		t.Errorf("other zone: expected REFUSED, got %+v", m.Header)
+	}
+
+	m := query(t, addr, "foo.tunnel.", dnsmessage.TypeSRV)
+	if m.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 2 || len(m.Additionals) != 1 {
+		t.Fatalf("SRV: unexpected answer %+v", m)
+	}
+	for i, port := range []uint16{30021, 30022} {
+		srv, ok := m.Answers[i].Body.(*dnsmessage.SRVResource)
+		if !ok || srv.Port != port || srv.Target.String() != "foo.tunnel." {
+			t.Errorf("SRV %d: unexpected %+v", i, m.Answers[i].Body)
+		}
+	}
+}
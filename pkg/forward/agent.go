@@ -0,0 +1,79 @@
+package forward
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"hostmanager/pkg/session"
+	"k8s.io/klog"
+)
+
+// PortRange are the ports agents may ask to expose, none when empty
+type PortRange struct {
+	Min, Max int
+}
+
+// ParsePortRange parses min-max or a single port, an empty value is the empty range
+func ParsePortRange(value string) (PortRange, error) {
+	if value == "" {
+		return PortRange{}, nil
+	}
+	parts := strings.SplitN(value, "-", 2)
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %s", value)
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range %s", value)
+		}
+	}
+	if min <= 0 || max > 65535 || min > max {
+		return PortRange{}, fmt.Errorf("invalid port range %s", value)
+	}
+	return PortRange{Min: min, Max: max}, nil
+}
+
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Min && port <= r.Max && r.Max > 0
+}
+
+// WatchAgents keeps the AGENT specs of m in line with the exposures asked by the agents connected
+// to registry, until stopCh is closed. Exposures outside of ports are ignored.
+func WatchAgents(m *Manager, registry *session.Registry, ports PortRange, stopCh <-chan struct{}) {
+	changed := make(chan struct{}, 1)
+	registry.Watch(func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-changed:
+			}
+			var specs []Spec
+			for _, s := range registry.Sessions("") {
+				for _, expose := range s.Expose {
+					if !ports.Contains(expose.Port) {
+						klog.Errorf("client[%s] expose port %d not allowed", s.ClientID, expose.Port)
+						continue
+					}
+					specs = append(specs, Spec{
+						Port:     expose.Port,
+						Proto:    expose.Proto,
+						ClientID: s.ClientID,
+						Target:   expose.Target,
+						Name:     fmt.Sprintf("client[%s]", s.ClientID),
+					})
+				}
+			}
+			m.Set(AGENT, specs)
+		}
+	}()
+}
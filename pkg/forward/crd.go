@@ -0,0 +1,55 @@
+package forward
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	hostinformers "hostmanager/pkg/generated/informers/externalversions"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// WatchCRD keeps the CRD specs of m in line with the TunnelListeners of host, or of all hosts,
+// in discovery.HOST_CRD_NAMESPACE until stopCh is closed. The resync retries listeners that failed to open.
+func WatchCRD(m *Manager, hostClient hostclientset.Interface, host string, stopCh <-chan struct{}) {
+	factory := hostinformers.NewSharedInformerFactoryWithOptions(hostClient, 30*time.Second, hostinformers.WithNamespace(discovery.HOST_CRD_NAMESPACE))
+	informer := factory.Hostmanager().V1().TunnelListeners()
+	lister := informer.Lister()
+
+	sync := func() {
+		listeners, err := lister.TunnelListeners(discovery.HOST_CRD_NAMESPACE).List(labels.Everything())
+		if err != nil {
+			klog.Errorf("list tunnel listeners fail:%s", err.Error())
+			return
+		}
+		var specs []Spec
+		for _, l := range listeners {
+			if l.Spec.Host != "" && l.Spec.Host != host {
+				continue
+			}
+			specs = append(specs, specFromCRD(l))
+		}
+		m.Set(CRD, specs)
+	}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { sync() },
+		UpdateFunc: func(interface{}, interface{}) { sync() },
+		DeleteFunc: func(interface{}) { sync() },
+	})
+	factory.Start(stopCh)
+}
+
+func specFromCRD(l *hostv1.TunnelListener) Spec {
+	return Spec{
+		Port:     int(l.Spec.Port),
+		Proto:    strings.ToLower(l.Spec.Protocol),
+		ClientID: l.Spec.ClientID,
+		Target:   l.Spec.Target,
+		Name:     fmt.Sprintf("%s/%s", l.Namespace, l.Name),
+	}
+}
@@ -0,0 +1,277 @@
+package forward
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"k8s.io/klog"
+)
+
+const (
+	// sources of the listener specs, in the order they win a port: TunnelListener, TunnelService, agent
+	CRD     = "crd"
+	SERVICE = "service"
+	AGENT   = "agent"
+
+	// DIAL_TIMEOUT bounds the dial through the tunnel client for an accepted connection
+	DIAL_TIMEOUT = 15 * time.Second
+	// UDP_TIMEOUT is the default idle time after which a udp flow is closed
+	UDP_TIMEOUT = 60 * time.Second
+)
+
+// Spec is a port hostmanager listens on, whose connections go through ClientID to Target
+type Spec struct {
+	Port int
+	// Proto is tcp, the default, or udp
+	Proto    string
+	ClientID string
+	// Target is the host:port the tunnel client connects to, or a unix:///path socket for tcp
+	Target string
+	// Name identifies the spec in logs, e.g. the TunnelListener it comes from
+	Name string
+}
+
+func (s Spec) proto() string {
+	if s.Proto == "" {
+		return "tcp"
+	}
+	return s.Proto
+}
+
+// key identifies a listener, tcp and udp listeners can share a port
+type key struct {
+	proto string
+	port  int
+}
+
+type closer interface {
+	Close() error
+}
+
+// Manager keeps the listeners of the specs set by all sources open
+type Manager struct {
+	sync.Mutex
+	server    *remotedialer.Server
+	sources   map[string][]Spec
+	listeners map[key]closer
+	specs     map[key]Spec
+	closed    bool
+	// UDPTimeout is the idle time after which a udp flow is closed
+	UDPTimeout time.Duration
+	// Allows returns true if the client allows to dial address with proto, only unix sockets are refused
+	// when nil. A denied dial would end the session of the client.
+	Allows func(clientID, proto, address string) bool
+	// UDPRelay returns the address of the udp relay of the client, which keeps the datagram boundaries.
+	// The datagrams are sent raw when nil or empty, up to UDP_SIZE bytes.
+	UDPRelay func(clientID string) string
+	// Limiter limits the tcp connections and the udp flows through every client, unlimited when nil
+	Limiter *limit.Limiter
+}
+
+func NewManager(server *remotedialer.Server) *Manager {
+	return &Manager{
+		server:     server,
+		sources:    map[string][]Spec{},
+		listeners:  map[key]closer{},
+		specs:      map[key]Spec{},
+		UDPTimeout: UDP_TIMEOUT,
+	}
+}
+
+// Set replaces the specs of source, CRD, SERVICE or AGENT, and opens and closes listeners accordingly.
+// When sources ask for the same port the first one wins.
+func (m *Manager) Set(source string, specs []Spec) {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return
+	}
+	m.sources[source] = specs
+
+	desired := map[key]Spec{}
+	for _, name := range []string{CRD, SERVICE, AGENT} {
+		for _, spec := range m.sources[name] {
+			k := key{proto: spec.proto(), port: spec.Port}
+			if other, ok := desired[k]; ok {
+				if other != spec {
+					klog.Errorf("listener %s %s port %d already used by %s", spec.Name, k.proto, spec.Port, other.Name)
+				}
+				continue
+			}
+			desired[k] = spec
+		}
+	}
+
+	for k, l := range m.listeners {
+		if spec, ok := desired[k]; !ok || spec != m.specs[k] {
+			klog.Infof("close listener %s on %s port %d", m.specs[k].Name, k.proto, k.port)
+			l.Close()
+			delete(m.listeners, k)
+			delete(m.specs, k)
+		}
+	}
+	for k, spec := range desired {
+		if _, ok := m.listeners[k]; ok {
+			continue
+		}
+		l, err := m.listen(k, spec)
+		if err != nil {
+			klog.Errorf("open listener %s on %s port %d fail:%s", spec.Name, k.proto, k.port, err.Error())
+			continue
+		}
+		klog.Infof("open listener %s on %s port %d to client[%s] %s", spec.Name, k.proto, k.port, spec.ClientID, spec.Target)
+		m.listeners[k] = l
+		m.specs[k] = spec
+	}
+}
+
+// listen opens and serves the listener of spec
+func (m *Manager) listen(k key, spec Spec) (closer, error) {
+	addr := fmt.Sprintf(":%d", k.port)
+	switch k.proto {
+	case "tcp":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		tl := &listener{Listener: l, spec: spec, server: m.server, allows: m.allows, limiter: m.Limiter}
+		go tl.serve()
+		return tl, nil
+	case "udp":
+		if _, ok := session.UnixSocket(spec.Target); ok {
+			return nil, fmt.Errorf("unix socket target %s is tcp only", spec.Target)
+		}
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		ul := newUDPListener(conn, spec, m.server, m.UDPTimeout)
+		ul.allows = m.allows
+		ul.relay = m.UDPRelay
+		ul.limiter = m.Limiter
+		go ul.serve()
+		return ul, nil
+	}
+	return nil, fmt.Errorf("unknown proto %s", k.proto)
+}
+
+func (m *Manager) allows(clientID, proto, address string) bool {
+	if m.Allows == nil {
+		return proto != "unix"
+	}
+	return m.Allows(clientID, proto, address)
+}
+
+// Listening returns the specs of the open listeners
+func (m *Manager) Listening() []Spec {
+	m.Lock()
+	defer m.Unlock()
+	specs := make([]Spec, 0, len(m.specs))
+	for _, spec := range m.specs {
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// Serving returns true if the listener of spec is open
+func (m *Manager) Serving(spec Spec) bool {
+	m.Lock()
+	defer m.Unlock()
+	k := key{proto: spec.proto(), port: spec.Port}
+	_, ok := m.listeners[k]
+	return ok && m.specs[k] == spec
+}
+
+// Ports returns the ports the specs of source ask for with proto, tcp or udp, and the names of the specs
+func (m *Manager) Ports(source, proto string) map[int]string {
+	m.Lock()
+	defer m.Unlock()
+	ports := map[int]string{}
+	for _, spec := range m.sources[source] {
+		if spec.proto() == proto {
+			ports[spec.Port] = spec.Name
+		}
+	}
+	return ports
+}
+
+// Close closes all listeners, no listener is opened afterwards
+func (m *Manager) Close() {
+	m.Lock()
+	defer m.Unlock()
+	m.closed = true
+	for k, l := range m.listeners {
+		l.Close()
+		delete(m.listeners, k)
+		delete(m.specs, k)
+	}
+	m.sources = map[string][]Spec{}
+}
+
+type listener struct {
+	net.Listener
+	spec    Spec
+	server  *remotedialer.Server
+	allows  func(clientID, proto, address string) bool
+	limiter *limit.Limiter
+}
+
+func (l *listener) serve() {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// closed
+			return
+		}
+		go l.forward(conn)
+	}
+}
+
+// forward pipes conn with a connection to the target dialed through the tunnel client
+func (l *listener) forward(conn net.Conn) {
+	defer conn.Close()
+	proto, address := "tcp", l.spec.Target
+	if socket, ok := session.UnixSocket(l.spec.Target); ok {
+		proto, address = "unix", socket
+	}
+	if !l.allows(l.spec.ClientID, proto, address) {
+		klog.Errorf("listener %s %s %s not allowed by client[%s]", l.spec.Name, proto, address, l.spec.ClientID)
+		return
+	}
+	caller, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if l.limiter != nil {
+		release, err := l.limiter.Acquire(l.spec.ClientID, caller)
+		if err != nil {
+			klog.Errorf("listener %s connection from %s refused:%s", l.spec.Name, conn.RemoteAddr(), err.Error())
+			return
+		}
+		defer release()
+	}
+	remote, err := l.server.Dial(l.spec.ClientID, DIAL_TIMEOUT, proto, address)
+	if err != nil {
+		klog.Errorf("listener %s dial %s through client[%s] fail:%s", l.spec.Name, l.spec.Target, l.spec.ClientID, err.Error())
+		return
+	}
+	defer remote.Close()
+	if l.limiter != nil {
+		remote = l.limiter.Conn(l.spec.ClientID, caller, remote)
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	// one direction ended, the deferred closes end the other
+	<-done
+}
@@ -0,0 +1,290 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hostmanager/pkg/agent"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session/sessiontest"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestParsePortRange(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  PortRange
+		err   bool
+	}{
+		{value: "", want: PortRange{}},
+		{value: "30000-30100", want: PortRange{Min: 30000, Max: 30100}},
+		{value: "30022", want: PortRange{Min: 30022, Max: 30022}},
+		{value: "0-10", err: true},
+		{value: "30100-30000", err: true},
+		{value: "1-65536", err: true},
+		{value: "a-b", err: true},
+		{value: "30000-", err: true},
+	} {
+		got, err := ParsePortRange(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected error %v, got %v", test.value, test.err, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: expected %+v, got %+v", test.value, test.want, got)
+		}
+	}
+	if (PortRange{}).Contains(0) {
+		t.Errorf("the empty range contains no port")
+	}
+	if r := (PortRange{Min: 10, Max: 20}); !r.Contains(10) || !r.Contains(20) || r.Contains(21) {
+		t.Errorf("%+v: bounds are included", r)
+	}
+}
+
+// freePort returns a port free for both tcp and udp
+func freePort(t *testing.T) int {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		if c, err := net.ListenPacket("udp", l.Addr().String()); err == nil {
+			c.Close()
+			return port
+		}
+	}
+	t.Fatalf("no free port")
+	return 0
+}
+
+func TestSetPrecedence(t *testing.T) {
+	m := NewManager(nil)
+	defer m.Close()
+	port := freePort(t)
+	crd := Spec{Port: port, ClientID: "a", Target: "127.0.0.1:1", Name: "crd"}
+	service := Spec{Port: port, ClientID: "b", Target: "127.0.0.1:2", Name: "service"}
+	agentSpec := Spec{Port: port, ClientID: "c", Target: "127.0.0.1:3", Name: "agent"}
+	udp := Spec{Port: port, Proto: "udp", ClientID: "c", Target: "127.0.0.1:3", Name: "agent udp"}
+
+	m.Set(AGENT, []Spec{agentSpec, udp})
+	m.Set(SERVICE, []Spec{service})
+	m.Set(CRD, []Spec{crd})
+	for _, test := range []struct {
+		spec Spec
+		want bool
+	}{{crd, true}, {service, false}, {agentSpec, false}, {udp, true}} {
+		if got := m.Serving(test.spec); got != test.want {
+			t.Errorf("%s: expected serving %v, got %v", test.spec.Name, test.want, got)
+		}
+	}
+	if got := m.Ports(SERVICE, "tcp"); got[port] != "service" {
+		t.Errorf("ports of service: got %v", got)
+	}
+
+	// the port goes to the next source once the winner drops it
+	m.Set(CRD, nil)
+	if !m.Serving(service) || m.Serving(crd) {
+		t.Errorf("service should serve the port without the crd")
+	}
+	m.Set(SERVICE, nil)
+	if !m.Serving(agentSpec) {
+		t.Errorf("agent should serve the port without the crd and service")
+	}
+	if got := len(m.Listening()); got != 2 {
+		t.Errorf("expected 2 listeners, got %d", got)
+	}
+
+	m.Close()
+	if len(m.Listening()) != 0 {
+		t.Errorf("close should close all listeners")
+	}
+	m.Set(CRD, []Spec{crd})
+	if m.Serving(crd) {
+		t.Errorf("no listener should open after close")
+	}
+}
+
+func TestTCPPipe(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	server := sessiontest.NewServer(t).Start(nil)
+	server.Connect("tcp-client", nil)
+	m := NewManager(server.Server)
+	defer m.Close()
+	m.Allows = server.Registry.Allows
+	spec := Spec{Port: freePort(t), ClientID: "tcp-client", Target: backend.Addr().String(), Name: "tcp"}
+	m.Set(CRD, []Spec{spec})
+	if !m.Serving(spec) {
+		t.Fatalf("listener not open")
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(spec.Port)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	want := strings.Repeat("hello ", 2000)
+	go conn.Write([]byte(want))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("expected the echo of %d bytes, got %d different ones", len(want), len(got))
+	}
+}
+
+// udpEcho returns the address of a udp server sending every datagram back, closed when the test ends
+func udpEcho(t *testing.T) string {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+	return backend.LocalAddr().String()
+}
+
+func TestUDPPipe(t *testing.T) {
+	backend := udpEcho(t)
+
+	server := sessiontest.NewServer(t).Start(nil)
+	// a real agent, with the udp relay
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	relayed := agent.New(agent.Options{
+		Servers: []string{"ws" + strings.TrimPrefix(server.Front.URL, "http") + "/connect"},
+		ID:      "relayed",
+		Logger:  logger,
+	})
+	go relayed.Run(ctx)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) { return server.HasSession("relayed"), nil }); err != nil {
+		t.Fatalf("agent not connected")
+	}
+	if server.Registry.UDPRelay("relayed") == "" {
+		t.Fatalf("agent declared no udp relay")
+	}
+	// an agent without relay gets the datagrams raw
+	server.Connect("raw", nil)
+
+	m := NewManager(server.Server)
+	defer m.Close()
+	m.Allows = server.Registry.Allows
+	m.UDPRelay = server.Registry.UDPRelay
+
+	for _, test := range []struct {
+		clientID string
+		sizes    []int
+	}{
+		// the datagrams go back to back, only the framing keeps them apart, the largest would be split raw
+		{clientID: "relayed", sizes: []int{1, 1200, 10000, 3}},
+		{clientID: "raw", sizes: []int{1, 1200, 3}},
+	} {
+		spec := Spec{Port: freePort(t), Proto: "udp", ClientID: test.clientID, Target: backend, Name: test.clientID}
+		m.Set(CRD, []Spec{spec})
+		if !m.Serving(spec) {
+			t.Fatalf("%s: listener not open", test.clientID)
+		}
+		conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(spec.Port)))
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		for i, size := range test.sizes {
+			if _, err := conn.Write(bytes.Repeat([]byte{byte('a' + i)}, size)); err != nil {
+				t.Fatalf("%s: write: %v", test.clientID, err)
+			}
+		}
+		buf := make([]byte, 64*1024)
+		for i, size := range test.sizes {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("%s: read datagram %d: %v", test.clientID, i, err)
+			}
+			if want := bytes.Repeat([]byte{byte('a' + i)}, size); !bytes.Equal(buf[:n], want) {
+				t.Errorf("%s: datagram %d: expected %d bytes of %c, got %d bytes", test.clientID, i, size, 'a'+i, n)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestUDPLimit(t *testing.T) {
+	server := sessiontest.NewServer(t).Start(nil)
+	server.Connect("foo", nil)
+	m := NewManager(server.Server)
+	defer m.Close()
+	// every flow is a stream of its source address
+	m.Limiter = limit.NewLimiter(limit.Limits{}, limit.Limits{Streams: 1})
+	spec := Spec{Port: freePort(t), Proto: "udp", ClientID: "foo", Target: udpEcho(t), Name: "limited"}
+	m.Set(CRD, []Spec{spec})
+
+	echo := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 16)
+		_, err := conn.Read(buf)
+		return err
+	}
+	first, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(spec.Port)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer first.Close()
+	if err := echo(first); err != nil {
+		t.Fatalf("first flow: %v", err)
+	}
+	second, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(spec.Port)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer second.Close()
+	if err := echo(second); err == nil {
+		t.Errorf("second flow of the caller should be refused")
+	}
+	if usage, _, _ := m.Limiter.Usage("foo"); usage.Streams != 1 || usage.Rejected == 0 {
+		t.Errorf("expected 1 stream and rejections, got %+v", usage)
+	}
+}
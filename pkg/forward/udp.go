@@ -0,0 +1,249 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"k8s.io/klog"
+)
+
+const (
+	// UDP_SIZE is the largest datagram forwarded to an agent without a udp relay: remotedialer hands a
+	// message over in reads of at most 4096 bytes with its 21 bytes header, larger datagrams would be split
+	UDP_SIZE = 4096 - 21
+	// UDP_QUEUE is the number of datagrams of a flow queued while it is dialed or its tunnel is busy,
+	// more are dropped
+	UDP_QUEUE = 64
+)
+
+// udpListener forwards the datagrams it receives through the tunnel client. Every source address is a
+// flow with its own tunnel connection to the udp relay of the agent, which keeps the datagram boundaries
+// with session.WriteDatagram frames and sends every datagram from the agent network. An agent without a
+// relay gets the datagrams raw. A flow idle for timeout is closed.
+type udpListener struct {
+	net.PacketConn
+	spec    Spec
+	server  *remotedialer.Server
+	timeout time.Duration
+	// allows returns true if the client allows to dial the target
+	allows func(clientID, proto, address string) bool
+	// relay returns the address of the udp relay of the client, empty if it has none
+	relay func(clientID string) string
+	// limiter counts every flow as a stream of its source, unlimited when nil
+	limiter *limit.Limiter
+
+	sync.Mutex
+	flows map[string]*udpFlow
+}
+
+type udpFlow struct {
+	addr   net.Addr
+	caller string
+	// release returns the stream of the flow to the limiter
+	release func()
+	// relay is the udp relay the flow goes through, the datagrams are sent raw when empty
+	relay string
+	out   chan []byte
+	done  chan struct{}
+	once  sync.Once
+	// lastSeen is guarded by the listener
+	lastSeen time.Time
+}
+
+func (f *udpFlow) close() {
+	f.once.Do(func() { close(f.done) })
+}
+
+func newUDPListener(conn net.PacketConn, spec Spec, server *remotedialer.Server, timeout time.Duration) *udpListener {
+	return &udpListener{
+		PacketConn: conn,
+		spec:       spec,
+		server:     server,
+		timeout:    timeout,
+		flows:      map[string]*udpFlow{},
+	}
+}
+
+func (l *udpListener) serve() {
+	done := make(chan struct{})
+	defer close(done)
+	go l.expire(done)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := l.ReadFrom(buf)
+		if err != nil {
+			// closed
+			l.closeFlows()
+			return
+		}
+		flow, err := l.flow(addr)
+		if err != nil {
+			klog.V(2).Infof("listener %s drop datagram from %s: %s", l.spec.Name, addr, err.Error())
+			continue
+		}
+		if flow.relay == "" && n > UDP_SIZE {
+			klog.Errorf("listener %s drop %d bytes datagram from %s, larger than %d", l.spec.Name, n, addr, UDP_SIZE)
+			continue
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+		select {
+		case flow.out <- datagram:
+		default:
+			klog.V(2).Infof("listener %s drop datagram from %s, queue full", l.spec.Name, addr)
+		}
+	}
+}
+
+// flow returns the flow of addr, a new one is dialed through the tunnel client in the background
+// so a slow dial does not hold the datagrams of the other flows. A new flow beyond the limits is refused.
+func (l *udpListener) flow(addr net.Addr) (*udpFlow, error) {
+	l.Lock()
+	defer l.Unlock()
+	if flow, ok := l.flows[addr.String()]; ok {
+		flow.lastSeen = time.Now()
+		return flow, nil
+	}
+	flow := &udpFlow{
+		addr:     addr,
+		release:  func() {},
+		out:      make(chan []byte, UDP_QUEUE),
+		done:     make(chan struct{}),
+		lastSeen: time.Now(),
+	}
+	flow.caller, _, _ = net.SplitHostPort(addr.String())
+	if l.limiter != nil {
+		release, err := l.limiter.Acquire(l.spec.ClientID, flow.caller)
+		if err != nil {
+			return nil, err
+		}
+		flow.release = release
+	}
+	if l.relay != nil {
+		flow.relay = l.relay(l.spec.ClientID)
+	}
+	l.flows[addr.String()] = flow
+	go l.run(flow)
+	return flow, nil
+}
+
+// run dials the flow and sends its queued datagrams until it is closed
+func (l *udpListener) run(flow *udpFlow) {
+	defer flow.release()
+	defer l.closeFlow(flow)
+	conn, err := l.dial(flow)
+	if err != nil {
+		klog.Errorf("listener %s dial udp %s through client[%s] fail:%s", l.spec.Name, l.spec.Target, l.spec.ClientID, err.Error())
+		return
+	}
+	defer conn.Close()
+	if l.limiter != nil {
+		conn = l.limiter.Conn(l.spec.ClientID, flow.caller, conn)
+	}
+	go l.reply(flow, conn)
+
+	for {
+		select {
+		case <-flow.done:
+			return
+		case datagram := <-flow.out:
+			if flow.relay != "" {
+				err = session.WriteDatagram(conn, datagram)
+			} else {
+				_, err = conn.Write(datagram)
+			}
+			if err != nil {
+				klog.Errorf("listener %s send to udp %s through client[%s] fail:%s", l.spec.Name, l.spec.Target, l.spec.ClientID, err.Error())
+				return
+			}
+		}
+	}
+}
+
+// dial connects the flow to the udp relay of the client, announcing the target in the first frame, or
+// to the target itself when the client has no relay
+func (l *udpListener) dial(flow *udpFlow) (net.Conn, error) {
+	if l.allows != nil && !l.allows(l.spec.ClientID, "udp", l.spec.Target) {
+		return nil, fmt.Errorf("not allowed by client[%s]", l.spec.ClientID)
+	}
+	if flow.relay == "" {
+		return l.server.Dial(l.spec.ClientID, DIAL_TIMEOUT, "udp", l.spec.Target)
+	}
+	conn, err := l.server.Dial(l.spec.ClientID, DIAL_TIMEOUT, "tcp", flow.relay)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.WriteDatagram(conn, []byte(l.spec.Target)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// reply sends the datagrams coming back through the flow to its source
+func (l *udpListener) reply(flow *udpFlow, conn net.Conn) {
+	defer l.closeFlow(flow)
+	buf := make([]byte, 64*1024)
+	for {
+		var n int
+		var err error
+		if flow.relay != "" {
+			n, err = session.ReadDatagram(conn, buf)
+		} else {
+			n, err = conn.Read(buf)
+		}
+		if err != nil {
+			return
+		}
+		l.Lock()
+		flow.lastSeen = time.Now()
+		l.Unlock()
+		if _, err := l.WriteTo(buf[:n], flow.addr); err != nil {
+			return
+		}
+	}
+}
+
+// expire closes the flows idle for the timeout until done is closed
+func (l *udpListener) expire(done <-chan struct{}) {
+	ticker := time.NewTicker(l.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			l.Lock()
+			for addr, flow := range l.flows {
+				if now.Sub(flow.lastSeen) >= l.timeout {
+					flow.close()
+					delete(l.flows, addr)
+				}
+			}
+			l.Unlock()
+		}
+	}
+}
+
+func (l *udpListener) closeFlow(flow *udpFlow) {
+	flow.close()
+	l.Lock()
+	defer l.Unlock()
+	if l.flows[flow.addr.String()] == flow {
+		delete(l.flows, flow.addr.String())
+	}
+}
+
+func (l *udpListener) closeFlows() {
+	l.Lock()
+	defer l.Unlock()
+	for addr, flow := range l.flows {
+		flow.close()
+		delete(l.flows, addr)
+	}
+}
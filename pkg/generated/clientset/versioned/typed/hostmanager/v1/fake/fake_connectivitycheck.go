@@ -0,0 +1,141 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeConnectivityChecks implements ConnectivityCheckInterface
+type FakeConnectivityChecks struct {
+	Fake *FakeHostmanagerV1
+	ns   string
+}
+
+var connectivitychecksResource = schema.GroupVersionResource{Group: "hostmanager.crc.com", Version: "v1", Resource: "connectivitychecks"}
+
+var connectivitychecksKind = schema.GroupVersionKind{Group: "hostmanager.crc.com", Version: "v1", Kind: "ConnectivityCheck"}
+
+// Get takes name of the connectivityCheck, and returns the corresponding connectivityCheck object, and an error if there is any.
+func (c *FakeConnectivityChecks) Get(name string, options v1.GetOptions) (result *hostmanagerv1.ConnectivityCheck, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(connectivitychecksResource, c.ns, name), &hostmanagerv1.ConnectivityCheck{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.ConnectivityCheck), err
+}
+
+// List takes label and field selectors, and returns the list of ConnectivityChecks that match those selectors.
+func (c *FakeConnectivityChecks) List(opts v1.ListOptions) (result *hostmanagerv1.ConnectivityCheckList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(connectivitychecksResource, connectivitychecksKind, c.ns, opts), &hostmanagerv1.ConnectivityCheckList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &hostmanagerv1.ConnectivityCheckList{ListMeta: obj.(*hostmanagerv1.ConnectivityCheckList).ListMeta}
+	for _, item := range obj.(*hostmanagerv1.ConnectivityCheckList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested connectivityChecks.
+func (c *FakeConnectivityChecks) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(connectivitychecksResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a connectivityCheck and creates it.  Returns the server's representation of the connectivityCheck, and an error, if there is any.
+func (c *FakeConnectivityChecks) Create(connectivityCheck *hostmanagerv1.ConnectivityCheck) (result *hostmanagerv1.ConnectivityCheck, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(connectivitychecksResource, c.ns, connectivityCheck), &hostmanagerv1.ConnectivityCheck{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.ConnectivityCheck), err
+}
+
+// Update takes the representation of a connectivityCheck and updates it. Returns the server's representation of the connectivityCheck, and an error, if there is any.
+func (c *FakeConnectivityChecks) Update(connectivityCheck *hostmanagerv1.ConnectivityCheck) (result *hostmanagerv1.ConnectivityCheck, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(connectivitychecksResource, c.ns, connectivityCheck), &hostmanagerv1.ConnectivityCheck{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.ConnectivityCheck), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeConnectivityChecks) UpdateStatus(connectivityCheck *hostmanagerv1.ConnectivityCheck) (*hostmanagerv1.ConnectivityCheck, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(connectivitychecksResource, "status", c.ns, connectivityCheck), &hostmanagerv1.ConnectivityCheck{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.ConnectivityCheck), err
+}
+
+// Delete takes name of the connectivityCheck and deletes it. Returns an error if one occurs.
+func (c *FakeConnectivityChecks) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(connectivitychecksResource, c.ns, name), &hostmanagerv1.ConnectivityCheck{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeConnectivityChecks) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(connectivitychecksResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &hostmanagerv1.ConnectivityCheckList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched connectivityCheck.
+func (c *FakeConnectivityChecks) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *hostmanagerv1.ConnectivityCheck, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(connectivitychecksResource, c.ns, name, pt, data, subresources...), &hostmanagerv1.ConnectivityCheck{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.ConnectivityCheck), err
+}
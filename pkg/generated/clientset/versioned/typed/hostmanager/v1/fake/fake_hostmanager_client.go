@@ -29,10 +29,26 @@ type FakeHostmanagerV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeHostmanagerV1) ConnectivityChecks(namespace string) v1.ConnectivityCheckInterface {
+	return &FakeConnectivityChecks{c, namespace}
+}
+
 func (c *FakeHostmanagerV1) Hosts(namespace string) v1.HostInterface {
 	return &FakeHosts{c, namespace}
 }
 
+func (c *FakeHostmanagerV1) TunnelListeners(namespace string) v1.TunnelListenerInterface {
+	return &FakeTunnelListeners{c, namespace}
+}
+
+func (c *FakeHostmanagerV1) TunnelRoutes(namespace string) v1.TunnelRouteInterface {
+	return &FakeTunnelRoutes{c, namespace}
+}
+
+func (c *FakeHostmanagerV1) TunnelServices(namespace string) v1.TunnelServiceInterface {
+	return &FakeTunnelServices{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeHostmanagerV1) RESTClient() rest.Interface {
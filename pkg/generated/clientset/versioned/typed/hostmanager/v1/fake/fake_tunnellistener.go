@@ -0,0 +1,129 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeTunnelListeners implements TunnelListenerInterface
+type FakeTunnelListeners struct {
+	Fake *FakeHostmanagerV1
+	ns   string
+}
+
+var tunnellistenersResource = schema.GroupVersionResource{Group: "hostmanager.crc.com", Version: "v1", Resource: "tunnellisteners"}
+
+var tunnellistenersKind = schema.GroupVersionKind{Group: "hostmanager.crc.com", Version: "v1", Kind: "TunnelListener"}
+
+// Get takes name of the tunnelListener, and returns the corresponding tunnelListener object, and an error if there is any.
+func (c *FakeTunnelListeners) Get(name string, options v1.GetOptions) (result *hostmanagerv1.TunnelListener, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(tunnellistenersResource, c.ns, name), &hostmanagerv1.TunnelListener{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelListener), err
+}
+
+// List takes label and field selectors, and returns the list of TunnelListeners that match those selectors.
+func (c *FakeTunnelListeners) List(opts v1.ListOptions) (result *hostmanagerv1.TunnelListenerList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(tunnellistenersResource, tunnellistenersKind, c.ns, opts), &hostmanagerv1.TunnelListenerList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &hostmanagerv1.TunnelListenerList{ListMeta: obj.(*hostmanagerv1.TunnelListenerList).ListMeta}
+	for _, item := range obj.(*hostmanagerv1.TunnelListenerList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelListeners.
+func (c *FakeTunnelListeners) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(tunnellistenersResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a tunnelListener and creates it.  Returns the server's representation of the tunnelListener, and an error, if there is any.
+func (c *FakeTunnelListeners) Create(tunnelListener *hostmanagerv1.TunnelListener) (result *hostmanagerv1.TunnelListener, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(tunnellistenersResource, c.ns, tunnelListener), &hostmanagerv1.TunnelListener{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelListener), err
+}
+
+// Update takes the representation of a tunnelListener and updates it. Returns the server's representation of the tunnelListener, and an error, if there is any.
+func (c *FakeTunnelListeners) Update(tunnelListener *hostmanagerv1.TunnelListener) (result *hostmanagerv1.TunnelListener, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(tunnellistenersResource, c.ns, tunnelListener), &hostmanagerv1.TunnelListener{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelListener), err
+}
+
+// Delete takes name of the tunnelListener and deletes it. Returns an error if one occurs.
+func (c *FakeTunnelListeners) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(tunnellistenersResource, c.ns, name), &hostmanagerv1.TunnelListener{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeTunnelListeners) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(tunnellistenersResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &hostmanagerv1.TunnelListenerList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched tunnelListener.
+func (c *FakeTunnelListeners) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *hostmanagerv1.TunnelListener, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(tunnellistenersResource, c.ns, name, pt, data, subresources...), &hostmanagerv1.TunnelListener{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelListener), err
+}
@@ -0,0 +1,141 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeTunnelRoutes implements TunnelRouteInterface
+type FakeTunnelRoutes struct {
+	Fake *FakeHostmanagerV1
+	ns   string
+}
+
+var tunnelroutesResource = schema.GroupVersionResource{Group: "hostmanager.crc.com", Version: "v1", Resource: "tunnelroutes"}
+
+var tunnelroutesKind = schema.GroupVersionKind{Group: "hostmanager.crc.com", Version: "v1", Kind: "TunnelRoute"}
+
+// Get takes name of the tunnelRoute, and returns the corresponding tunnelRoute object, and an error if there is any.
+func (c *FakeTunnelRoutes) Get(name string, options v1.GetOptions) (result *hostmanagerv1.TunnelRoute, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(tunnelroutesResource, c.ns, name), &hostmanagerv1.TunnelRoute{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelRoute), err
+}
+
+// List takes label and field selectors, and returns the list of TunnelRoutes that match those selectors.
+func (c *FakeTunnelRoutes) List(opts v1.ListOptions) (result *hostmanagerv1.TunnelRouteList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(tunnelroutesResource, tunnelroutesKind, c.ns, opts), &hostmanagerv1.TunnelRouteList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &hostmanagerv1.TunnelRouteList{ListMeta: obj.(*hostmanagerv1.TunnelRouteList).ListMeta}
+	for _, item := range obj.(*hostmanagerv1.TunnelRouteList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelRoutes.
+func (c *FakeTunnelRoutes) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(tunnelroutesResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a tunnelRoute and creates it.  Returns the server's representation of the tunnelRoute, and an error, if there is any.
+func (c *FakeTunnelRoutes) Create(tunnelRoute *hostmanagerv1.TunnelRoute) (result *hostmanagerv1.TunnelRoute, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(tunnelroutesResource, c.ns, tunnelRoute), &hostmanagerv1.TunnelRoute{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelRoute), err
+}
+
+// Update takes the representation of a tunnelRoute and updates it. Returns the server's representation of the tunnelRoute, and an error, if there is any.
+func (c *FakeTunnelRoutes) Update(tunnelRoute *hostmanagerv1.TunnelRoute) (result *hostmanagerv1.TunnelRoute, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(tunnelroutesResource, c.ns, tunnelRoute), &hostmanagerv1.TunnelRoute{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelRoute), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeTunnelRoutes) UpdateStatus(tunnelRoute *hostmanagerv1.TunnelRoute) (*hostmanagerv1.TunnelRoute, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(tunnelroutesResource, "status", c.ns, tunnelRoute), &hostmanagerv1.TunnelRoute{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelRoute), err
+}
+
+// Delete takes name of the tunnelRoute and deletes it. Returns an error if one occurs.
+func (c *FakeTunnelRoutes) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(tunnelroutesResource, c.ns, name), &hostmanagerv1.TunnelRoute{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeTunnelRoutes) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(tunnelroutesResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &hostmanagerv1.TunnelRouteList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched tunnelRoute.
+func (c *FakeTunnelRoutes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *hostmanagerv1.TunnelRoute, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(tunnelroutesResource, c.ns, name, pt, data, subresources...), &hostmanagerv1.TunnelRoute{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelRoute), err
+}
@@ -0,0 +1,141 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeTunnelServices implements TunnelServiceInterface
+type FakeTunnelServices struct {
+	Fake *FakeHostmanagerV1
+	ns   string
+}
+
+var tunnelservicesResource = schema.GroupVersionResource{Group: "hostmanager.crc.com", Version: "v1", Resource: "tunnelservices"}
+
+var tunnelservicesKind = schema.GroupVersionKind{Group: "hostmanager.crc.com", Version: "v1", Kind: "TunnelService"}
+
+// Get takes name of the tunnelService, and returns the corresponding tunnelService object, and an error if there is any.
+func (c *FakeTunnelServices) Get(name string, options v1.GetOptions) (result *hostmanagerv1.TunnelService, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(tunnelservicesResource, c.ns, name), &hostmanagerv1.TunnelService{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelService), err
+}
+
+// List takes label and field selectors, and returns the list of TunnelServices that match those selectors.
+func (c *FakeTunnelServices) List(opts v1.ListOptions) (result *hostmanagerv1.TunnelServiceList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(tunnelservicesResource, tunnelservicesKind, c.ns, opts), &hostmanagerv1.TunnelServiceList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &hostmanagerv1.TunnelServiceList{ListMeta: obj.(*hostmanagerv1.TunnelServiceList).ListMeta}
+	for _, item := range obj.(*hostmanagerv1.TunnelServiceList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelServices.
+func (c *FakeTunnelServices) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(tunnelservicesResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a tunnelService and creates it.  Returns the server's representation of the tunnelService, and an error, if there is any.
+func (c *FakeTunnelServices) Create(tunnelService *hostmanagerv1.TunnelService) (result *hostmanagerv1.TunnelService, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(tunnelservicesResource, c.ns, tunnelService), &hostmanagerv1.TunnelService{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelService), err
+}
+
+// Update takes the representation of a tunnelService and updates it. Returns the server's representation of the tunnelService, and an error, if there is any.
+func (c *FakeTunnelServices) Update(tunnelService *hostmanagerv1.TunnelService) (result *hostmanagerv1.TunnelService, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(tunnelservicesResource, c.ns, tunnelService), &hostmanagerv1.TunnelService{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelService), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeTunnelServices) UpdateStatus(tunnelService *hostmanagerv1.TunnelService) (*hostmanagerv1.TunnelService, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(tunnelservicesResource, "status", c.ns, tunnelService), &hostmanagerv1.TunnelService{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelService), err
+}
+
+// Delete takes name of the tunnelService and deletes it. Returns an error if one occurs.
+func (c *FakeTunnelServices) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(tunnelservicesResource, c.ns, name), &hostmanagerv1.TunnelService{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeTunnelServices) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(tunnelservicesResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &hostmanagerv1.TunnelServiceList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched tunnelService.
+func (c *FakeTunnelServices) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *hostmanagerv1.TunnelService, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(tunnelservicesResource, c.ns, name, pt, data, subresources...), &hostmanagerv1.TunnelService{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*hostmanagerv1.TunnelService), err
+}
@@ -18,4 +18,12 @@ limitations under the License.
 
 package v1
 
+type ConnectivityCheckExpansion interface{}
+
 type HostExpansion interface{}
+
+type TunnelListenerExpansion interface{}
+
+type TunnelRouteExpansion interface{}
+
+type TunnelServiceExpansion interface{}
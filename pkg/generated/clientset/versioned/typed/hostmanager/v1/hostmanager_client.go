@@ -27,7 +27,11 @@ import (
 
 type HostmanagerV1Interface interface {
 	RESTClient() rest.Interface
+	ConnectivityChecksGetter
 	HostsGetter
+	TunnelListenersGetter
+	TunnelRoutesGetter
+	TunnelServicesGetter
 }
 
 // HostmanagerV1Client is used to interact with features provided by the hostmanager.crc.com group.
@@ -35,10 +39,26 @@ type HostmanagerV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *HostmanagerV1Client) ConnectivityChecks(namespace string) ConnectivityCheckInterface {
+	return newConnectivityChecks(c, namespace)
+}
+
 func (c *HostmanagerV1Client) Hosts(namespace string) HostInterface {
 	return newHosts(c, namespace)
 }
 
+func (c *HostmanagerV1Client) TunnelListeners(namespace string) TunnelListenerInterface {
+	return newTunnelListeners(c, namespace)
+}
+
+func (c *HostmanagerV1Client) TunnelRoutes(namespace string) TunnelRouteInterface {
+	return newTunnelRoutes(c, namespace)
+}
+
+func (c *HostmanagerV1Client) TunnelServices(namespace string) TunnelServiceInterface {
+	return newTunnelServices(c, namespace)
+}
+
 // NewForConfig creates a new HostmanagerV1Client for the given config.
 func NewForConfig(c *rest.Config) (*HostmanagerV1Client, error) {
 	config := *c
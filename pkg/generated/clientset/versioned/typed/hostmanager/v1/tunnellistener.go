@@ -0,0 +1,174 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+	scheme "hostmanager/pkg/generated/clientset/versioned/scheme"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// TunnelListenersGetter has a method to return a TunnelListenerInterface.
+// A group's client should implement this interface.
+type TunnelListenersGetter interface {
+	TunnelListeners(namespace string) TunnelListenerInterface
+}
+
+// TunnelListenerInterface has methods to work with TunnelListener resources.
+type TunnelListenerInterface interface {
+	Create(*v1.TunnelListener) (*v1.TunnelListener, error)
+	Update(*v1.TunnelListener) (*v1.TunnelListener, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.TunnelListener, error)
+	List(opts metav1.ListOptions) (*v1.TunnelListenerList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelListener, err error)
+	TunnelListenerExpansion
+}
+
+// tunnelListeners implements TunnelListenerInterface
+type tunnelListeners struct {
+	client rest.Interface
+	ns     string
+}
+
+// newTunnelListeners returns a TunnelListeners
+func newTunnelListeners(c *HostmanagerV1Client, namespace string) *tunnelListeners {
+	return &tunnelListeners{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the tunnelListener, and returns the corresponding tunnelListener object, and an error if there is any.
+func (c *tunnelListeners) Get(name string, options metav1.GetOptions) (result *v1.TunnelListener, err error) {
+	result = &v1.TunnelListener{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of TunnelListeners that match those selectors.
+func (c *tunnelListeners) List(opts metav1.ListOptions) (result *v1.TunnelListenerList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.TunnelListenerList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelListeners.
+func (c *tunnelListeners) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a tunnelListener and creates it.  Returns the server's representation of the tunnelListener, and an error, if there is any.
+func (c *tunnelListeners) Create(tunnelListener *v1.TunnelListener) (result *v1.TunnelListener, err error) {
+	result = &v1.TunnelListener{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		Body(tunnelListener).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a tunnelListener and updates it. Returns the server's representation of the tunnelListener, and an error, if there is any.
+func (c *tunnelListeners) Update(tunnelListener *v1.TunnelListener) (result *v1.TunnelListener, err error) {
+	result = &v1.TunnelListener{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		Name(tunnelListener.Name).
+		Body(tunnelListener).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the tunnelListener and deletes it. Returns an error if one occurs.
+func (c *tunnelListeners) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *tunnelListeners) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched tunnelListener.
+func (c *tunnelListeners) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelListener, err error) {
+	result = &v1.TunnelListener{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("tunnellisteners").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
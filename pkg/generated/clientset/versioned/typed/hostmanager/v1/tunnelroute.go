@@ -0,0 +1,191 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+	scheme "hostmanager/pkg/generated/clientset/versioned/scheme"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// TunnelRoutesGetter has a method to return a TunnelRouteInterface.
+// A group's client should implement this interface.
+type TunnelRoutesGetter interface {
+	TunnelRoutes(namespace string) TunnelRouteInterface
+}
+
+// TunnelRouteInterface has methods to work with TunnelRoute resources.
+type TunnelRouteInterface interface {
+	Create(*v1.TunnelRoute) (*v1.TunnelRoute, error)
+	Update(*v1.TunnelRoute) (*v1.TunnelRoute, error)
+	UpdateStatus(*v1.TunnelRoute) (*v1.TunnelRoute, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.TunnelRoute, error)
+	List(opts metav1.ListOptions) (*v1.TunnelRouteList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelRoute, err error)
+	TunnelRouteExpansion
+}
+
+// tunnelRoutes implements TunnelRouteInterface
+type tunnelRoutes struct {
+	client rest.Interface
+	ns     string
+}
+
+// newTunnelRoutes returns a TunnelRoutes
+func newTunnelRoutes(c *HostmanagerV1Client, namespace string) *tunnelRoutes {
+	return &tunnelRoutes{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the tunnelRoute, and returns the corresponding tunnelRoute object, and an error if there is any.
+func (c *tunnelRoutes) Get(name string, options metav1.GetOptions) (result *v1.TunnelRoute, err error) {
+	result = &v1.TunnelRoute{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of TunnelRoutes that match those selectors.
+func (c *tunnelRoutes) List(opts metav1.ListOptions) (result *v1.TunnelRouteList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.TunnelRouteList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelRoutes.
+func (c *tunnelRoutes) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a tunnelRoute and creates it.  Returns the server's representation of the tunnelRoute, and an error, if there is any.
+func (c *tunnelRoutes) Create(tunnelRoute *v1.TunnelRoute) (result *v1.TunnelRoute, err error) {
+	result = &v1.TunnelRoute{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		Body(tunnelRoute).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a tunnelRoute and updates it. Returns the server's representation of the tunnelRoute, and an error, if there is any.
+func (c *tunnelRoutes) Update(tunnelRoute *v1.TunnelRoute) (result *v1.TunnelRoute, err error) {
+	result = &v1.TunnelRoute{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		Name(tunnelRoute.Name).
+		Body(tunnelRoute).
+		Do().
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *tunnelRoutes) UpdateStatus(tunnelRoute *v1.TunnelRoute) (result *v1.TunnelRoute, err error) {
+	result = &v1.TunnelRoute{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		Name(tunnelRoute.Name).
+		SubResource("status").
+		Body(tunnelRoute).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the tunnelRoute and deletes it. Returns an error if one occurs.
+func (c *tunnelRoutes) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *tunnelRoutes) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched tunnelRoute.
+func (c *tunnelRoutes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelRoute, err error) {
+	result = &v1.TunnelRoute{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("tunnelroutes").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
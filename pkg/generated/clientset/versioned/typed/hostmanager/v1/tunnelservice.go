@@ -0,0 +1,191 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+	scheme "hostmanager/pkg/generated/clientset/versioned/scheme"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// TunnelServicesGetter has a method to return a TunnelServiceInterface.
+// A group's client should implement this interface.
+type TunnelServicesGetter interface {
+	TunnelServices(namespace string) TunnelServiceInterface
+}
+
+// TunnelServiceInterface has methods to work with TunnelService resources.
+type TunnelServiceInterface interface {
+	Create(*v1.TunnelService) (*v1.TunnelService, error)
+	Update(*v1.TunnelService) (*v1.TunnelService, error)
+	UpdateStatus(*v1.TunnelService) (*v1.TunnelService, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.TunnelService, error)
+	List(opts metav1.ListOptions) (*v1.TunnelServiceList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelService, err error)
+	TunnelServiceExpansion
+}
+
+// tunnelServices implements TunnelServiceInterface
+type tunnelServices struct {
+	client rest.Interface
+	ns     string
+}
+
+// newTunnelServices returns a TunnelServices
+func newTunnelServices(c *HostmanagerV1Client, namespace string) *tunnelServices {
+	return &tunnelServices{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the tunnelService, and returns the corresponding tunnelService object, and an error if there is any.
+func (c *tunnelServices) Get(name string, options metav1.GetOptions) (result *v1.TunnelService, err error) {
+	result = &v1.TunnelService{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of TunnelServices that match those selectors.
+func (c *tunnelServices) List(opts metav1.ListOptions) (result *v1.TunnelServiceList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.TunnelServiceList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested tunnelServices.
+func (c *tunnelServices) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a tunnelService and creates it.  Returns the server's representation of the tunnelService, and an error, if there is any.
+func (c *tunnelServices) Create(tunnelService *v1.TunnelService) (result *v1.TunnelService, err error) {
+	result = &v1.TunnelService{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		Body(tunnelService).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a tunnelService and updates it. Returns the server's representation of the tunnelService, and an error, if there is any.
+func (c *tunnelServices) Update(tunnelService *v1.TunnelService) (result *v1.TunnelService, err error) {
+	result = &v1.TunnelService{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		Name(tunnelService.Name).
+		Body(tunnelService).
+		Do().
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *tunnelServices) UpdateStatus(tunnelService *v1.TunnelService) (result *v1.TunnelService, err error) {
+	result = &v1.TunnelService{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		Name(tunnelService.Name).
+		SubResource("status").
+		Body(tunnelService).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the tunnelService and deletes it. Returns an error if one occurs.
+func (c *tunnelServices) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *tunnelServices) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched tunnelService.
+func (c *tunnelServices) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.TunnelService, err error) {
+	result = &v1.TunnelService{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("tunnelservices").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
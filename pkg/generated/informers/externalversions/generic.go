@@ -53,8 +53,16 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=hostmanager.crc.com, Version=v1
+	case v1.SchemeGroupVersion.WithResource("connectivitychecks"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Hostmanager().V1().ConnectivityChecks().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("hosts"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Hostmanager().V1().Hosts().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("tunnellisteners"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Hostmanager().V1().TunnelListeners().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("tunnelroutes"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Hostmanager().V1().TunnelRoutes().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("tunnelservices"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Hostmanager().V1().TunnelServices().Informer()}, nil
 
 	}
 
@@ -24,8 +24,16 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ConnectivityChecks returns a ConnectivityCheckInformer.
+	ConnectivityChecks() ConnectivityCheckInformer
 	// Hosts returns a HostInformer.
 	Hosts() HostInformer
+	// TunnelListeners returns a TunnelListenerInformer.
+	TunnelListeners() TunnelListenerInformer
+	// TunnelRoutes returns a TunnelRouteInformer.
+	TunnelRoutes() TunnelRouteInformer
+	// TunnelServices returns a TunnelServiceInformer.
+	TunnelServices() TunnelServiceInformer
 }
 
 type version struct {
@@ -39,7 +47,27 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ConnectivityChecks returns a ConnectivityCheckInformer.
+func (v *version) ConnectivityChecks() ConnectivityCheckInformer {
+	return &connectivityCheckInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // Hosts returns a HostInformer.
 func (v *version) Hosts() HostInformer {
 	return &hostInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// TunnelListeners returns a TunnelListenerInformer.
+func (v *version) TunnelListeners() TunnelListenerInformer {
+	return &tunnelListenerInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// TunnelRoutes returns a TunnelRouteInformer.
+func (v *version) TunnelRoutes() TunnelRouteInformer {
+	return &tunnelRouteInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// TunnelServices returns a TunnelServiceInformer.
+func (v *version) TunnelServices() TunnelServiceInformer {
+	return &tunnelServiceInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
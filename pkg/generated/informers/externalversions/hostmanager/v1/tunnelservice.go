@@ -0,0 +1,89 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	hostmanagerv1 "hostmanager/pkg/apis/hostmanager/v1"
+	versioned "hostmanager/pkg/generated/clientset/versioned"
+	internalinterfaces "hostmanager/pkg/generated/informers/externalversions/internalinterfaces"
+	v1 "hostmanager/pkg/generated/listers/hostmanager/v1"
+	time "time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// TunnelServiceInformer provides access to a shared informer and lister for
+// TunnelServices.
+type TunnelServiceInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1.TunnelServiceLister
+}
+
+type tunnelServiceInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewTunnelServiceInformer constructs a new informer for TunnelService type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewTunnelServiceInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredTunnelServiceInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredTunnelServiceInformer constructs a new informer for TunnelService type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredTunnelServiceInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HostmanagerV1().TunnelServices(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.HostmanagerV1().TunnelServices(namespace).Watch(options)
+			},
+		},
+		&hostmanagerv1.TunnelService{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *tunnelServiceInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredTunnelServiceInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *tunnelServiceInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&hostmanagerv1.TunnelService{}, f.defaultInformer)
+}
+
+func (f *tunnelServiceInformer) Lister() v1.TunnelServiceLister {
+	return v1.NewTunnelServiceLister(f.Informer().GetIndexer())
+}
@@ -18,6 +18,14 @@ limitations under the License.
 
 package v1
 
+// ConnectivityCheckListerExpansion allows custom methods to be added to
+// ConnectivityCheckLister.
+type ConnectivityCheckListerExpansion interface{}
+
+// ConnectivityCheckNamespaceListerExpansion allows custom methods to be added to
+// ConnectivityCheckNamespaceLister.
+type ConnectivityCheckNamespaceListerExpansion interface{}
+
 // HostListerExpansion allows custom methods to be added to
 // HostLister.
 type HostListerExpansion interface{}
@@ -25,3 +33,27 @@ type HostListerExpansion interface{}
 // HostNamespaceListerExpansion allows custom methods to be added to
 // HostNamespaceLister.
 type HostNamespaceListerExpansion interface{}
+
+// TunnelListenerListerExpansion allows custom methods to be added to
+// TunnelListenerLister.
+type TunnelListenerListerExpansion interface{}
+
+// TunnelListenerNamespaceListerExpansion allows custom methods to be added to
+// TunnelListenerNamespaceLister.
+type TunnelListenerNamespaceListerExpansion interface{}
+
+// TunnelRouteListerExpansion allows custom methods to be added to
+// TunnelRouteLister.
+type TunnelRouteListerExpansion interface{}
+
+// TunnelRouteNamespaceListerExpansion allows custom methods to be added to
+// TunnelRouteNamespaceLister.
+type TunnelRouteNamespaceListerExpansion interface{}
+
+// TunnelServiceListerExpansion allows custom methods to be added to
+// TunnelServiceLister.
+type TunnelServiceListerExpansion interface{}
+
+// TunnelServiceNamespaceListerExpansion allows custom methods to be added to
+// TunnelServiceNamespaceLister.
+type TunnelServiceNamespaceListerExpansion interface{}
@@ -0,0 +1,95 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TunnelListenerLister helps list TunnelListeners.
+type TunnelListenerLister interface {
+	// List lists all TunnelListeners in the indexer.
+	List(selector labels.Selector) (ret []*v1.TunnelListener, err error)
+	// TunnelListeners returns an object that can list and get TunnelListeners.
+	TunnelListeners(namespace string) TunnelListenerNamespaceLister
+	TunnelListenerListerExpansion
+}
+
+// tunnelListenerLister implements the TunnelListenerLister interface.
+type tunnelListenerLister struct {
+	indexer cache.Indexer
+}
+
+// NewTunnelListenerLister returns a new TunnelListenerLister.
+func NewTunnelListenerLister(indexer cache.Indexer) TunnelListenerLister {
+	return &tunnelListenerLister{indexer: indexer}
+}
+
+// List lists all TunnelListeners in the indexer.
+func (s *tunnelListenerLister) List(selector labels.Selector) (ret []*v1.TunnelListener, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelListener))
+	})
+	return ret, err
+}
+
+// TunnelListeners returns an object that can list and get TunnelListeners.
+func (s *tunnelListenerLister) TunnelListeners(namespace string) TunnelListenerNamespaceLister {
+	return tunnelListenerNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// TunnelListenerNamespaceLister helps list and get TunnelListeners.
+type TunnelListenerNamespaceLister interface {
+	// List lists all TunnelListeners in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1.TunnelListener, err error)
+	// Get retrieves the TunnelListener from the indexer for a given namespace and name.
+	Get(name string) (*v1.TunnelListener, error)
+	TunnelListenerNamespaceListerExpansion
+}
+
+// tunnelListenerNamespaceLister implements the TunnelListenerNamespaceLister
+// interface.
+type tunnelListenerNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all TunnelListeners in the indexer for a given namespace.
+func (s tunnelListenerNamespaceLister) List(selector labels.Selector) (ret []*v1.TunnelListener, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelListener))
+	})
+	return ret, err
+}
+
+// Get retrieves the TunnelListener from the indexer for a given namespace and name.
+func (s tunnelListenerNamespaceLister) Get(name string) (*v1.TunnelListener, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("tunnellistener"), name)
+	}
+	return obj.(*v1.TunnelListener), nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TunnelRouteLister helps list TunnelRoutes.
+type TunnelRouteLister interface {
+	// List lists all TunnelRoutes in the indexer.
+	List(selector labels.Selector) (ret []*v1.TunnelRoute, err error)
+	// TunnelRoutes returns an object that can list and get TunnelRoutes.
+	TunnelRoutes(namespace string) TunnelRouteNamespaceLister
+	TunnelRouteListerExpansion
+}
+
+// tunnelRouteLister implements the TunnelRouteLister interface.
+type tunnelRouteLister struct {
+	indexer cache.Indexer
+}
+
+// NewTunnelRouteLister returns a new TunnelRouteLister.
+func NewTunnelRouteLister(indexer cache.Indexer) TunnelRouteLister {
+	return &tunnelRouteLister{indexer: indexer}
+}
+
+// List lists all TunnelRoutes in the indexer.
+func (s *tunnelRouteLister) List(selector labels.Selector) (ret []*v1.TunnelRoute, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelRoute))
+	})
+	return ret, err
+}
+
+// TunnelRoutes returns an object that can list and get TunnelRoutes.
+func (s *tunnelRouteLister) TunnelRoutes(namespace string) TunnelRouteNamespaceLister {
+	return tunnelRouteNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// TunnelRouteNamespaceLister helps list and get TunnelRoutes.
+type TunnelRouteNamespaceLister interface {
+	// List lists all TunnelRoutes in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1.TunnelRoute, err error)
+	// Get retrieves the TunnelRoute from the indexer for a given namespace and name.
+	Get(name string) (*v1.TunnelRoute, error)
+	TunnelRouteNamespaceListerExpansion
+}
+
+// tunnelRouteNamespaceLister implements the TunnelRouteNamespaceLister
+// interface.
+type tunnelRouteNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all TunnelRoutes in the indexer for a given namespace.
+func (s tunnelRouteNamespaceLister) List(selector labels.Selector) (ret []*v1.TunnelRoute, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelRoute))
+	})
+	return ret, err
+}
+
+// Get retrieves the TunnelRoute from the indexer for a given namespace and name.
+func (s tunnelRouteNamespaceLister) Get(name string) (*v1.TunnelRoute, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("tunnelroute"), name)
+	}
+	return obj.(*v1.TunnelRoute), nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "hostmanager/pkg/apis/hostmanager/v1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// TunnelServiceLister helps list TunnelServices.
+type TunnelServiceLister interface {
+	// List lists all TunnelServices in the indexer.
+	List(selector labels.Selector) (ret []*v1.TunnelService, err error)
+	// TunnelServices returns an object that can list and get TunnelServices.
+	TunnelServices(namespace string) TunnelServiceNamespaceLister
+	TunnelServiceListerExpansion
+}
+
+// tunnelServiceLister implements the TunnelServiceLister interface.
+type tunnelServiceLister struct {
+	indexer cache.Indexer
+}
+
+// NewTunnelServiceLister returns a new TunnelServiceLister.
+func NewTunnelServiceLister(indexer cache.Indexer) TunnelServiceLister {
+	return &tunnelServiceLister{indexer: indexer}
+}
+
+// List lists all TunnelServices in the indexer.
+func (s *tunnelServiceLister) List(selector labels.Selector) (ret []*v1.TunnelService, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelService))
+	})
+	return ret, err
+}
+
+// TunnelServices returns an object that can list and get TunnelServices.
+func (s *tunnelServiceLister) TunnelServices(namespace string) TunnelServiceNamespaceLister {
+	return tunnelServiceNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// TunnelServiceNamespaceLister helps list and get TunnelServices.
+type TunnelServiceNamespaceLister interface {
+	// List lists all TunnelServices in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1.TunnelService, err error)
+	// Get retrieves the TunnelService from the indexer for a given namespace and name.
+	Get(name string) (*v1.TunnelService, error)
+	TunnelServiceNamespaceListerExpansion
+}
+
+// tunnelServiceNamespaceLister implements the TunnelServiceNamespaceLister
+// interface.
+type tunnelServiceNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all TunnelServices in the indexer for a given namespace.
+func (s tunnelServiceNamespaceLister) List(selector labels.Selector) (ret []*v1.TunnelService, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.TunnelService))
+	})
+	return ret, err
+}
+
+// Get retrieves the TunnelService from the indexer for a given namespace and name.
+func (s tunnelServiceNamespaceLister) Get(name string) (*v1.TunnelService, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("tunnelservice"), name)
+	}
+	return obj.(*v1.TunnelService), nil
+}
@@ -0,0 +1,224 @@
+package ingress
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"hostmanager/pkg/proxy"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// CLASS_ANNOTATION selects the ingress controller of an Ingress
+	CLASS_ANNOTATION = "kubernetes.io/ingress.class"
+
+	// the backend Service of an Ingress is annotated with the tunnel client and the url reached through it
+	CLIENT_ANNOTATION = "hostmanager.crc.com/client-id"
+	TARGET_ANNOTATION = "hostmanager.crc.com/target"
+
+	RESYNC_PERIOD = 30 * time.Second
+	// PREFIX is where the hostmanager router serves the ingress requests forwarded by the other hosts,
+	// for the clients connected to it
+	PREFIX = "/ingress"
+)
+
+// Backend is where the requests of a route go: Target through the tunnel client ClientID
+type Backend struct {
+	ClientID string
+	Target   *url.URL
+	// Name is the namespace/service of the backend, for logs
+	Name string
+}
+
+// route serves the requests for host, any host if empty, whose path starts with path
+type route struct {
+	host    string
+	path    string
+	backend Backend
+}
+
+// Controller serves the Ingresses of its class by proxying their hosts and paths through the tunnel clients
+type Controller struct {
+	proxy    *proxy.Proxy
+	class    string
+	factory  informers.SharedInformerFactory
+	ingress  networkinglisters.IngressLister
+	services corelisters.ServiceLister
+	synced   []cache.InformerSynced
+
+	lock   sync.RWMutex
+	routes []route
+	// defaultBackend serves the requests without route, set by the spec.backend of an Ingress
+	defaultBackend *Backend
+}
+
+func NewController(kubeClient kubernetes.Interface, p *proxy.Proxy, class string) *Controller {
+	factory := informers.NewSharedInformerFactory(kubeClient, RESYNC_PERIOD)
+	ingressInformer := factory.Networking().V1beta1().Ingresses()
+	serviceInformer := factory.Core().V1().Services()
+	c := &Controller{
+		proxy:    p,
+		class:    class,
+		factory:  factory,
+		ingress:  ingressInformer.Lister(),
+		services: serviceInformer.Lister(),
+		synced:   []cache.InformerSynced{ingressInformer.Informer().HasSynced, serviceInformer.Informer().HasSynced},
+	}
+	// routes are few, every change rebuilds them all
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.sync() },
+		UpdateFunc: func(old, new interface{}) { c.sync() },
+		DeleteFunc: func(interface{}) { c.sync() },
+	}
+	ingressInformer.Informer().AddEventHandler(handler)
+	serviceInformer.Informer().AddEventHandler(handler)
+	return c
+}
+
+// Run starts the informers and waits for their caches, it does not block
+func (c *Controller) Run(stopCh <-chan struct{}) error {
+	c.factory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, c.synced...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	c.sync()
+	return nil
+}
+
+// sync rebuilds the routes from the Ingresses of the class
+func (c *Controller) sync() {
+	all, err := c.ingress.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list ingresses fail:%s", err.Error())
+		return
+	}
+	// oldest first, so the oldest Ingress wins a host and path defined twice
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreationTimestamp.Equal(&all[j].CreationTimestamp) {
+			return all[i].CreationTimestamp.Before(&all[j].CreationTimestamp)
+		}
+		return all[i].Namespace+"/"+all[i].Name < all[j].Namespace+"/"+all[j].Name
+	})
+
+	var routes []route
+	var defaultBackend *Backend
+	seen := map[string]bool{}
+	for _, ing := range all {
+		if ing.Annotations[CLASS_ANNOTATION] != c.class {
+			continue
+		}
+		if ing.Spec.Backend != nil && defaultBackend == nil {
+			if backend, err := c.backend(ing.Namespace, ing.Spec.Backend); err != nil {
+				klog.Errorf("ingress:[%s/%s] default backend fail:%s", ing.Namespace, ing.Name, err.Error())
+			} else {
+				defaultBackend = &backend
+			}
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				path := p.Path
+				if path == "" {
+					path = "/"
+				}
+				key := rule.Host + path
+				if seen[key] {
+					klog.Errorf("ingress:[%s/%s] %s already routed by another ingress", ing.Namespace, ing.Name, key)
+					continue
+				}
+				backend, err := c.backend(ing.Namespace, &p.Backend)
+				if err != nil {
+					klog.Errorf("ingress:[%s/%s] %s backend fail:%s", ing.Namespace, ing.Name, key, err.Error())
+					continue
+				}
+				seen[key] = true
+				routes = append(routes, route{host: rule.Host, path: path, backend: backend})
+			}
+		}
+	}
+	// the longest path of a host wins, then the longest path of any host
+	sort.SliceStable(routes, func(i, j int) bool {
+		if (routes[i].host == "") != (routes[j].host == "") {
+			return routes[j].host == ""
+		}
+		return len(routes[i].path) > len(routes[j].path)
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.routes = routes
+	c.defaultBackend = defaultBackend
+}
+
+// backend returns the tunnel client and target annotated on the Service of b
+func (c *Controller) backend(namespace string, b *networkingv1beta1.IngressBackend) (Backend, error) {
+	name := namespace + "/" + b.ServiceName
+	service, err := c.services.Services(namespace).Get(b.ServiceName)
+	if err != nil {
+		return Backend{}, err
+	}
+	return parseBackend(name, service)
+}
+
+func parseBackend(name string, service *corev1.Service) (Backend, error) {
+	clientID := service.Annotations[CLIENT_ANNOTATION]
+	target := service.Annotations[TARGET_ANNOTATION]
+	if clientID == "" || target == "" {
+		return Backend{}, fmt.Errorf("service %s has no %s and %s annotations", name, CLIENT_ANNOTATION, TARGET_ANNOTATION)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return Backend{}, fmt.Errorf("service %s invalid target %s: %s", name, target, err.Error())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Backend{}, fmt.Errorf("service %s target %s is not a http(s) url", name, target)
+	}
+	return Backend{ClientID: clientID, Target: u, Name: name}, nil
+}
+
+// match returns the backend of the request for host and path, the path of a route matches whole segments
+func (c *Controller) match(host, path string) (Backend, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, r := range c.routes {
+		if (r.host == "" || r.host == host) && proxy.HasSegmentPrefix(path, r.path) {
+			return r.backend, true
+		}
+	}
+	if c.defaultBackend != nil {
+		return *c.defaultBackend, true
+	}
+	return Backend{}, false
+}
+
+// ServeHTTP proxies the request to the backend of its host and path, keeping the path. A request whose
+// client is connected to another host goes to PREFIX on that host.
+func (c *Controller) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	backend, ok := c.match(req.Host, req.URL.Path)
+	if !ok {
+		http.Error(rw, fmt.Sprintf("no ingress for %s%s", req.Host, req.URL.Path), http.StatusNotFound)
+		return
+	}
+
+	klog.Infof("INGRESS %s %s%s to client[%s] %s", req.Method, req.Host, req.URL.Path, backend.ClientID, backend.Target)
+	route := proxy.Route{Name: "ingress " + backend.Name, ClientID: backend.ClientID, Target: backend.Target}
+	c.proxy.ServeRoute(rw, req, route, PREFIX+req.URL.RequestURI())
+}
@@ -0,0 +1,186 @@
+package ingress
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/proxy"
+	"hostmanager/pkg/session/sessiontest"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newService(name, clientID, target string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{CLIENT_ANNOTATION: clientID, TARGET_ANNOTATION: target},
+		},
+	}
+}
+
+func newIngress(name, class string, rules ...networkingv1beta1.IngressRule) *networkingv1beta1.Ingress {
+	return &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{CLASS_ANNOTATION: class},
+		},
+		Spec: networkingv1beta1.IngressSpec{Rules: rules},
+	}
+}
+
+func newRule(host string, paths map[string]string) networkingv1beta1.IngressRule {
+	rule := networkingv1beta1.IngressRule{Host: host}
+	rule.HTTP = &networkingv1beta1.HTTPIngressRuleValue{}
+	for path, service := range paths {
+		rule.HTTP.Paths = append(rule.HTTP.Paths, networkingv1beta1.HTTPIngressPath{
+			Path:    path,
+			Backend: networkingv1beta1.IngressBackend{ServiceName: service},
+		})
+	}
+	return rule
+}
+
+func TestMatch(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	client := fake.NewSimpleClientset(
+		newService("web", "foo", "http://10.0.0.5:8080"),
+		newService("api", "bar", "https://10.0.0.6"),
+		newService("plain", "", ""),
+		newIngress("app", "hostmanager",
+			newRule("app.example.com", map[string]string{"/": "web", "/api": "api"}),
+			newRule("", map[string]string{"/plain": "plain"}),
+		),
+		newIngress("other", "nginx", newRule("other.example.com", map[string]string{"/": "web"})),
+	)
+	server := sessiontest.NewServer(t)
+	c := NewController(client, proxy.New(server.Server, server.Registry, nil, nil), "hostmanager")
+	if err := c.Run(stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+
+	tests := []struct {
+		host, path string
+		clientID   string
+		target     string
+	}{
+		{"app.example.com", "/index.html", "foo", "http://10.0.0.5:8080"},
+		{"app.example.com:80", "/api/v1", "bar", "https://10.0.0.6"},
+		{"app.example.com", "/api", "bar", "https://10.0.0.6"},
+		// a path matches whole segments
+		{"app.example.com", "/apiary", "foo", "http://10.0.0.5:8080"},
+		// the service has no annotations
+		{"app.example.com", "/plain", "foo", "http://10.0.0.5:8080"},
+		{"any.example.com", "/plain", "", ""},
+		// another class
+		{"other.example.com", "/", "", ""},
+	}
+	for _, test := range tests {
+		backend, ok := c.match(test.host, test.path)
+		if test.clientID == "" {
+			if ok {
+				t.Errorf("%s%s: unexpected backend %+v", test.host, test.path, backend)
+			}
+			continue
+		}
+		if !ok || backend.ClientID != test.clientID || backend.Target.String() != test.target {
+			t.Errorf("%s%s: expected client %s %s, got %+v", test.host, test.path, test.clientID, test.target, backend)
+		}
+	}
+}
+
+// owners is a client directory with the owner of every client
+type owners map[string]string
+
+func (o owners) PublishSessions(discovery.Peer, []hostv1.ClientSession) error { return nil }
+
+func (o owners) Owners(clientID string) ([]discovery.Peer, error) {
+	if host, ok := o[clientID]; ok {
+		return []discovery.Peer{discovery.NewPeer(host, "")}, nil
+	}
+	return nil, nil
+}
+
+func (o owners) Sessions(string) ([]discovery.PeerSession, error)        { return nil, nil }
+func (o owners) Select(labels.Selector) ([]discovery.PeerSession, error) { return nil, nil }
+
+func TestServeHTTP(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		fmt.Fprintf(rw, "%s %s %s %s", req.Method, req.URL.RequestURI(), req.Header.Get("X-Forwarded-Host"), body)
+	}))
+	defer backend.Close()
+	client := fake.NewSimpleClientset(
+		newService("web", "foo", backend.URL),
+		newService("api", "bar", backend.URL+"/v2"),
+		newIngress("app", "hostmanager", newRule("app.example.com", map[string]string{"/": "web", "/api": "api"})),
+	)
+
+	// bar is connected to the owner, which serves the ingress requests forwarded to it under PREFIX
+	owner := sessiontest.NewServer(t)
+	ownerIngress := NewController(client, proxy.New(owner.Server, owner.Registry, nil, nil), "hostmanager")
+	if err := ownerIngress.Run(stopCh); err != nil {
+		t.Fatalf("run owner controller: %v", err)
+	}
+	router := mux.NewRouter()
+	router.PathPrefix(PREFIX + "/").Handler(http.StripPrefix(PREFIX, ownerIngress))
+	ownerHost := strings.TrimPrefix(owner.Start(router).Front.URL, "http://")
+	owner.Connect("bar", nil)
+
+	server := sessiontest.NewServer(t)
+	server.PeerID = "127.0.0.1:1"
+	c := NewController(client, proxy.New(server.Server, server.Registry, owners{"bar": ownerHost}, nil), "hostmanager")
+	if err := c.Run(stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+	front := httptest.NewServer(c)
+	defer front.Close()
+	server.Start(nil)
+	server.Connect("foo", nil)
+
+	do := func(method, host, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, front.URL+path, strings.NewReader(body))
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s%s: %v", method, host, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	for _, test := range []struct {
+		method, host, path, body string
+		code                     int
+		want                     string
+	}{
+		{http.MethodGet, "app.example.com", "/index.html?x=1", "", http.StatusOK, "GET /index.html?x=1 app.example.com "},
+		// through the client connected to the owner
+		{http.MethodPost, "app.example.com", "/api/v1", "hello", http.StatusOK, "POST /v2/api/v1 app.example.com hello"},
+		{http.MethodGet, "app.example.com", "/apiary", "", http.StatusOK, "GET /apiary app.example.com "},
+		{http.MethodGet, "other.example.com", "/", "", http.StatusNotFound, ""},
+	} {
+		code, body := do(test.method, test.host, test.path, test.body)
+		if code != test.code || (test.want != "" && body != test.want) {
+			t.Errorf("%s %s%s: expected %d %q, got %d %q", test.method, test.host, test.path, test.code, test.want, code, body)
+		}
+	}
+}
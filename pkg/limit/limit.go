@@ -0,0 +1,351 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+)
+
+// IDLE_TIMEOUT is how long the limits and usage of a client or caller without stream are kept
+const IDLE_TIMEOUT = time.Minute
+
+// Limits bound the use of a tunnel client, a zero limit is unlimited
+type Limits struct {
+	// Streams is the most concurrent requests and connections
+	Streams int
+	// Rate is the most new streams per second
+	Rate float64
+	// Bandwidth is the most bytes per second, both directions together
+	Bandwidth int64
+}
+
+// ParseLimits parses streams=10,rate=5,bandwidth=1048576, the missing limits are unlimited
+func ParseLimits(value string) (Limits, error) {
+	var l Limits
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return Limits{}, fmt.Errorf("invalid limit %s, expected name=value", item)
+		}
+		var err error
+		switch parts[0] {
+		case "streams":
+			l.Streams, err = strconv.Atoi(parts[1])
+			if err == nil && l.Streams < 0 {
+				err = fmt.Errorf("negative")
+			}
+		case "rate":
+			l.Rate, err = strconv.ParseFloat(parts[1], 64)
+			if err == nil && l.Rate < 0 {
+				err = fmt.Errorf("negative")
+			}
+		case "bandwidth":
+			l.Bandwidth, err = strconv.ParseInt(parts[1], 10, 64)
+			if err == nil && l.Bandwidth < 0 {
+				err = fmt.Errorf("negative")
+			}
+		default:
+			return Limits{}, fmt.Errorf("unknown limit %s, expected streams, rate or bandwidth", parts[0])
+		}
+		if err != nil {
+			return Limits{}, fmt.Errorf("invalid limit %s: %s", item, err.Error())
+		}
+	}
+	return l, nil
+}
+
+// String formats l as ParseLimits parses it
+func (l Limits) String() string {
+	var items []string
+	if l.Streams > 0 {
+		items = append(items, fmt.Sprintf("streams=%d", l.Streams))
+	}
+	if l.Rate > 0 {
+		items = append(items, "rate="+strconv.FormatFloat(l.Rate, 'g', -1, 64))
+	}
+	if l.Bandwidth > 0 {
+		items = append(items, fmt.Sprintf("bandwidth=%d", l.Bandwidth))
+	}
+	return strings.Join(items, ",")
+}
+
+// Min returns the stricter of l and o for every limit
+func (l Limits) Min(o Limits) Limits {
+	if o.Streams > 0 && (l.Streams == 0 || o.Streams < l.Streams) {
+		l.Streams = o.Streams
+	}
+	if o.Rate > 0 && (l.Rate == 0 || o.Rate < l.Rate) {
+		l.Rate = o.Rate
+	}
+	if o.Bandwidth > 0 && (l.Bandwidth == 0 || o.Bandwidth < l.Bandwidth) {
+		l.Bandwidth = o.Bandwidth
+	}
+	return l
+}
+
+// Error is returned when a limit is reached
+type Error struct {
+	// Reason is streams or rate
+	Reason string
+	// Caller is true when the limit of the caller is reached, not the one of the client
+	Caller bool
+	Limits Limits
+}
+
+func (e *Error) Error() string {
+	who := "client"
+	if e.Caller {
+		who = "caller"
+	}
+	return fmt.Sprintf("%s %s limit reached, limits %s", who, e.Reason, e.Limits)
+}
+
+// Usage is the use of a tunnel client since hostmanager started
+type Usage struct {
+	// Streams are in progress
+	Streams  int
+	Requests int64
+	Rejected int64
+	Bytes    int64
+}
+
+// Limiter enforces the limits of every tunnel client, and of every caller through every client
+type Limiter struct {
+	client Limits
+	caller Limits
+	// Declared returns the limits the agent of a client declared, they are enforced when stricter
+	Declared func(clientID string) Limits
+
+	sync.Mutex
+	clients map[string]*bucket
+	// callers are by clientID/caller
+	callers map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	limits   Limits
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	usage    Usage
+	lastUsed time.Time
+}
+
+func NewLimiter(client, caller Limits) *Limiter {
+	return &Limiter{
+		client:  client,
+		caller:  caller,
+		clients: map[string]*bucket{},
+		callers: map[string]*bucket{},
+	}
+}
+
+// Limits returns the limits enforced on clientID
+func (l *Limiter) Limits(clientID string) Limits {
+	if l.Declared == nil {
+		return l.client
+	}
+	return l.client.Min(l.Declared(clientID))
+}
+
+// bucket returns the bucket of key, reset when its limits changed
+func (l *Limiter) bucket(buckets map[string]*bucket, key string, limits Limits, now time.Time) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{}
+		buckets[key] = b
+	}
+	if !ok || b.limits != limits {
+		b.limits = limits
+		b.requests, b.bytes = nil, nil
+		if limits.Rate > 0 {
+			// a second of requests at once
+			b.requests = rate.NewLimiter(rate.Limit(limits.Rate), int(math.Ceil(limits.Rate)))
+		}
+		if limits.Bandwidth > 0 {
+			b.bytes = rate.NewLimiter(rate.Limit(limits.Bandwidth), int(limits.Bandwidth))
+		}
+	}
+	b.lastUsed = now
+	return b
+}
+
+// Acquire takes a stream of caller through clientID, release returns it. The error is an *Error when
+// a limit is reached.
+func (l *Limiter) Acquire(clientID, caller string) (release func(), err error) {
+	limits := l.Limits(clientID)
+	now := time.Now()
+
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now)
+	client := l.bucket(l.clients, clientID, limits, now)
+	callerKey := clientID + "/" + caller
+	callerBucket := l.bucket(l.callers, callerKey, l.caller, now)
+
+	switch {
+	case client.limits.Streams > 0 && client.usage.Streams >= client.limits.Streams:
+		err = &Error{Reason: "streams", Limits: client.limits}
+	case callerBucket.limits.Streams > 0 && callerBucket.usage.Streams >= callerBucket.limits.Streams:
+		err = &Error{Reason: "streams", Caller: true, Limits: callerBucket.limits}
+	case callerBucket.requests != nil && !callerBucket.requests.AllowN(now, 1):
+		err = &Error{Reason: "rate", Caller: true, Limits: callerBucket.limits}
+	case client.requests != nil && !client.requests.AllowN(now, 1):
+		err = &Error{Reason: "rate", Limits: client.limits}
+	}
+	if err != nil {
+		client.usage.Rejected++
+		callerBucket.usage.Rejected++
+		rejectedTotal.WithLabelValues(clientID, err.(*Error).Reason).Inc()
+		return nil, err
+	}
+
+	for _, b := range []*bucket{client, callerBucket} {
+		b.usage.Streams++
+		b.usage.Requests++
+	}
+	streams.WithLabelValues(clientID).Inc()
+	requestsTotal.WithLabelValues(clientID).Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Lock()
+			defer l.Unlock()
+			for _, b := range []*bucket{client, callerBucket} {
+				b.usage.Streams--
+				b.lastUsed = time.Now()
+			}
+			streams.WithLabelValues(clientID).Dec()
+		})
+	}, nil
+}
+
+// sweep drops the idle clients, with their metrics, and callers once every IDLE_TIMEOUT, with the lock held
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < IDLE_TIMEOUT {
+		return
+	}
+	l.swept = now
+	for clientID, b := range l.clients {
+		if b.usage.Streams == 0 && now.Sub(b.lastUsed) >= IDLE_TIMEOUT {
+			delete(l.clients, clientID)
+			streams.DeleteLabelValues(clientID)
+			requestsTotal.DeleteLabelValues(clientID)
+			bytesTotal.DeleteLabelValues(clientID)
+			for _, reason := range []string{"streams", "rate"} {
+				rejectedTotal.DeleteLabelValues(clientID, reason)
+			}
+		}
+	}
+	for key, b := range l.callers {
+		if b.usage.Streams == 0 && now.Sub(b.lastUsed) >= IDLE_TIMEOUT {
+			delete(l.callers, key)
+		}
+	}
+}
+
+// Conn limits the bandwidth of conn, a stream of caller through clientID, and counts its bytes
+func (l *Limiter) Conn(clientID, caller string, conn net.Conn) net.Conn {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	client := l.bucket(l.clients, clientID, l.Limits(clientID), now)
+	callerBucket := l.bucket(l.callers, clientID+"/"+caller, l.caller, now)
+	c := &throttled{Conn: conn, count: func(n int) {
+		l.Lock()
+		client.usage.Bytes += int64(n)
+		callerBucket.usage.Bytes += int64(n)
+		l.Unlock()
+		bytesTotal.WithLabelValues(clientID).Add(float64(n))
+	}}
+	for _, b := range []*bucket{client, callerBucket} {
+		if b.bytes != nil {
+			c.limiters = append(c.limiters, b.bytes)
+		}
+	}
+	return c
+}
+
+// Usage returns the use of clientID and the limits enforced on it, ok is false when it was not used
+// within IDLE_TIMEOUT
+func (l *Limiter) Usage(clientID string) (usage Usage, limits Limits, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.clients[clientID]
+	if !ok {
+		return Usage{}, Limits{}, false
+	}
+	return b.usage, b.limits, true
+}
+
+// Status returns the use of clientID for its ClientSession status, nil when it was not used within IDLE_TIMEOUT
+func (l *Limiter) Status(clientID string) *hostv1.ClientUsage {
+	usage, limits, ok := l.Usage(clientID)
+	if !ok {
+		return nil
+	}
+	return &hostv1.ClientUsage{
+		Streams:  int32(usage.Streams),
+		Requests: usage.Requests,
+		Rejected: usage.Rejected,
+		Bytes:    usage.Bytes,
+		Limits:   limits.String(),
+	}
+}
+
+// Throttle limits the bandwidth of conn to bytesPerSecond, both directions together
+func Throttle(conn net.Conn, bytesPerSecond int64) net.Conn {
+	if bytesPerSecond <= 0 {
+		return conn
+	}
+	return &throttled{Conn: conn, limiters: []*rate.Limiter{rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))}}
+}
+
+// throttled waits for the bandwidth of its limiters before returning the bytes read and writing
+type throttled struct {
+	net.Conn
+	limiters []*rate.Limiter
+	count    func(n int)
+}
+
+func (c *throttled) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.wait(n)
+	return n, err
+}
+
+func (c *throttled) Write(b []byte) (int, error) {
+	c.wait(len(b))
+	return c.Conn.Write(b)
+}
+
+func (c *throttled) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	if c.count != nil {
+		c.count(n)
+	}
+	for _, limiter := range c.limiters {
+		// WaitN fails above the burst, a second of bandwidth
+		for left := n; left > 0; {
+			chunk := left
+			if chunk > limiter.Burst() {
+				chunk = limiter.Burst()
+			}
+			limiter.WaitN(context.Background(), chunk)
+			left -= chunk
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package limit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseLimits(t *testing.T) {
+	l, err := ParseLimits("streams=10, rate=2.5,bandwidth=1024")
+	if err != nil || l != (Limits{Streams: 10, Rate: 2.5, Bandwidth: 1024}) {
+		t.Fatalf("unexpected limits %+v %v", l, err)
+	}
+	if l.String() != "streams=10,rate=2.5,bandwidth=1024" {
+		t.Errorf("unexpected string %s", l.String())
+	}
+	for _, value := range []string{"streams", "streams=-1", "rate=x", "burst=1"} {
+		if _, err := ParseLimits(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+	if min := l.Min(Limits{Streams: 20, Rate: 1}); min != (Limits{Streams: 10, Rate: 1, Bandwidth: 1024}) {
+		t.Errorf("unexpected min %+v", min)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(Limits{Streams: 3}, Limits{Streams: 2, Rate: 2})
+	l.Declared = func(clientID string) Limits {
+		if clientID == "bar" {
+			return Limits{Streams: 1}
+		}
+		return Limits{}
+	}
+
+	first, err := l.Acquire("foo", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := l.Acquire("foo", "10.0.0.1"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := l.Acquire("foo", "10.0.0.1"); err == nil || !err.(*Error).Caller || err.(*Error).Reason != "streams" {
+		t.Errorf("expected the caller streams limit, got %v", err)
+	}
+	if _, err := l.Acquire("foo", "10.0.0.2"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := l.Acquire("foo", "10.0.0.3"); err == nil || err.(*Error).Caller {
+		t.Errorf("expected the client streams limit, got %v", err)
+	}
+	first()
+	first()
+	if _, err := l.Acquire("foo", "10.0.0.1"); err == nil || err.(*Error).Reason != "rate" {
+		t.Errorf("expected the caller rate limit, got %v", err)
+	}
+
+	if _, err := l.Acquire("bar", "10.0.0.1"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := l.Acquire("bar", "10.0.0.2"); err == nil {
+		t.Errorf("expected the declared streams limit")
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := l.Conn("foo", "10.0.0.1", server)
+	go conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	status := l.Status("foo")
+	if status == nil || status.Streams != 2 || status.Requests != 3 || status.Rejected != 3 || status.Bytes != 5 || status.Limits != "streams=3" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if l.Status("baz") != nil {
+		t.Errorf("unused client has a status")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := NewLimiter(Limits{Streams: 1}, Limits{})
+	busy, err := l.Acquire("sweep-busy", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer busy()
+	idle, err := l.Acquire("sweep-idle", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := l.Acquire("sweep-idle", "10.0.0.2"); err == nil {
+		t.Fatalf("expected the streams limit")
+	}
+	idle()
+	series := testutil.CollectAndCount(requestsTotal) + testutil.CollectAndCount(rejectedTotal)
+
+	// the clients idle for IDLE_TIMEOUT are forgotten with their metrics, the one with a stream is kept
+	l.Lock()
+	l.sweep(time.Now().Add(IDLE_TIMEOUT))
+	l.Unlock()
+	if _, _, ok := l.Usage("sweep-idle"); ok {
+		t.Errorf("idle client kept")
+	}
+	if _, _, ok := l.Usage("sweep-busy"); !ok {
+		t.Errorf("busy client dropped")
+	}
+	if got := testutil.CollectAndCount(requestsTotal) + testutil.CollectAndCount(rejectedTotal); got != series-2 {
+		t.Errorf("expected the 2 series of the idle client dropped, %d left of %d", got, series)
+	}
+	if len(l.callers) != 1 {
+		t.Errorf("expected the caller of the busy client only, got %d", len(l.callers))
+	}
+}
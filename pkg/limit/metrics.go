@@ -0,0 +1,30 @@
+package limit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	streams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "hostmanager",
+		Name:      "client_streams",
+		Help:      "Streams in progress through the tunnel client",
+	}, []string{"client"})
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hostmanager",
+		Name:      "client_requests_total",
+		Help:      "Streams accepted through the tunnel client",
+	}, []string{"client"})
+	rejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hostmanager",
+		Name:      "client_rejected_total",
+		Help:      "Streams rejected by a limit of the tunnel client or of the caller",
+	}, []string{"client", "reason"})
+	bytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hostmanager",
+		Name:      "client_bytes_total",
+		Help:      "Bytes sent and received through the tunnel client",
+	}, []string{"client"})
+)
+
+func init() {
+	prometheus.MustRegister(streams, requestsTotal, rejectedTotal, bytesTotal)
+}
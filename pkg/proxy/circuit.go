@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"hostmanager/pkg/breaker"
+	"k8s.io/klog"
+)
+
+// allow checks the circuits of clientKey and of target through it. When one is open it answers 503 and
+// ok is false, otherwise done reports the result of the request and the deferred release lets it go without
+// one when it is not sent.
+func (p *Proxy) allow(rw http.ResponseWriter, req *http.Request, clientKey, target string) (done func(code int, err error), release func(), ok bool) {
+	if p.Breakers == nil {
+		return func(int, error) {}, func() {}, true
+	}
+	done, release, err := p.Breakers.Allow(clientKey, target)
+	if err != nil {
+		klog.Infof("OPEN %s %s through client[%s]: %s", req.Method, req.URL.Path, clientKey, err.Error())
+		if open, ok := err.(*breaker.OpenError); ok {
+			rw.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(open.RetryAfter.Seconds())))
+		}
+		writeError(rw, http.StatusServiceUnavailable, err)
+		return nil, nil, false
+	}
+	return done, release, true
+}
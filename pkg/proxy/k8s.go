@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"hostmanager/pkg/discovery"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// the user and groups of the caller, set by the authenticating proxy in front of hostmanager, see ClusterCallers
+	USER_HEADER  = "X-Remote-User"
+	GROUP_HEADER = "X-Remote-Group"
+
+	// CLUSTER_PREFIX is the path of the API server of a cluster, /k8s/clusters/{id}
+	CLUSTER_PREFIX = "/k8s/clusters/"
+)
+
+// Cluster serves /k8s/clusters/{id}{path} with the API server of the kubernetes cluster id, through an
+// agent running in it. The request is authenticated with the service account of the agent, which
+// impersonates the caller named by UserHeader and GroupHeader. Their values are only trusted from the
+// authenticating proxies of ClusterCallers, and from the peers forwarding a request they got from one.
+func (p *Proxy) Cluster(rw http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	if !p.forwarded(req) && !p.clusterCaller(req) {
+		klog.Infof("CLUSTER %s %s %s refused from %s", id, req.Method, req.URL.Path, req.RemoteAddr)
+		writeError(rw, http.StatusForbidden, fmt.Errorf("%s is not an authenticating proxy", req.RemoteAddr))
+		return
+	}
+	user := req.Header.Get(p.UserHeader)
+	if user == "" {
+		writeError(rw, http.StatusUnauthorized, fmt.Errorf("no user in %s", p.UserHeader))
+		return
+	}
+
+	sessions := p.registry.Clusters(id)
+	if len(sessions) == 0 {
+		if !p.forwarded(req) {
+			if owner, ok := p.clusterOwner(id); ok {
+				p.forward(owner, rw, req, req.URL.RequestURI())
+				return
+			}
+		}
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("no agent of cluster %s is connected", id))
+		return
+	}
+	s := sessions[len(sessions)-1]
+	cluster := s.Cluster
+
+	pool := x509.NewCertPool()
+	if len(cluster.CACert) > 0 && !pool.AppendCertsFromPEM(cluster.CACert) {
+		writeError(rw, http.StatusBadGateway, fmt.Errorf("invalid CA of cluster %s", id))
+		return
+	}
+	host, _, _ := net.SplitHostPort(cluster.Address)
+
+	var groups []string
+	for _, value := range req.Header[http.CanonicalHeaderKey(p.GroupHeader)] {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	if !p.permit(rw, s.ClientID, "tcp", cluster.Address) {
+		return
+	}
+	release, ok := p.acquire(rw, req, s.ClientID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	klog.Infof("CLUSTER %s %s %s as %s through client[%s]", id, req.Method, req.URL.Path, user, s.ClientID)
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL.Scheme = "https"
+			out.URL.Host = cluster.Address
+			out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(out.URL.Path, CLUSTER_PREFIX+id), "/")
+			out.URL.RawPath = ""
+			out.Host = cluster.Address
+			// the caller can not choose its identity
+			for key := range out.Header {
+				if strings.HasPrefix(key, "Impersonate-") {
+					out.Header.Del(key)
+				}
+			}
+			out.Header.Del(p.UserHeader)
+			out.Header.Del(p.GroupHeader)
+			dropForwarding(out.Header)
+			out.Header.Set("Authorization", "Bearer "+cluster.Token)
+			out.Header.Set("Impersonate-User", user)
+			for _, group := range groups {
+				out.Header.Add("Impersonate-Group", group)
+			}
+		},
+		Transport: &http.Transport{
+			// no idle timeout, watches are quiet
+			Dial:              p.dialer(req, s.ClientID, p.Timeouts.Dial),
+			TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: host},
+			DisableKeepAlives: true,
+		},
+		// watches stream
+		FlushInterval: -1,
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			klog.Errorf("CLUSTER ERR %s %s through client[%s]: %v", id, req.URL.Path, s.ClientID, err)
+			writeError(rw, http.StatusBadGateway, err)
+		},
+	}
+	proxy.ServeHTTP(rw, req)
+}
+
+// clusterCaller returns true if req comes straight from one of the ClusterCallers
+func (p *Proxy) clusterCaller(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range p.ClusterCallers {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterOwner returns a host an agent of cluster id is connected to
+func (p *Proxy) clusterOwner(id string) (owner discovery.Peer, found bool) {
+	if p.directory == nil {
+		return owner, false
+	}
+	sessions, err := p.directory.Select(labels.Everything())
+	if err != nil {
+		klog.Errorf("lookup agents of cluster %s fail:%s", id, err.Error())
+		return owner, false
+	}
+	for _, s := range sessions {
+		if s.Session.Cluster != id || s.Peer.ID == p.server.PeerID {
+			continue
+		}
+		if !found || ownerRank(s.Peer) < ownerRank(owner) {
+			owner, found = s.Peer, true
+		}
+	}
+	return owner, found
+}
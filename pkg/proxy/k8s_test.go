@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"hostmanager/pkg/session"
+	"hostmanager/pkg/session/sessiontest"
+)
+
+func TestCluster(t *testing.T) {
+	apiserver := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(rw, "%s %s %s %s %v", req.URL.RequestURI(), req.Header.Get("Authorization"),
+			req.Header.Get("Impersonate-User"), req.Header.Get(USER_HEADER), req.Header["Impersonate-Group"])
+	}))
+	defer apiserver.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiserver.Certificate().Raw})
+
+	server := sessiontest.NewServer(t)
+	p := New(server.Server, server.Registry, nil, nil)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	p.ClusterCallers = []*net.IPNet{loopback}
+	p.Authenticate = func(peerID, token string) bool { return peerID == "10.0.0.2:8123" && token == "peer-token" }
+	router := mux.NewRouter().UseEncodedPath()
+	router.PathPrefix(CLUSTER_PREFIX + "{id}").HandlerFunc(p.Cluster)
+	front := server.Start(router).Front
+
+	cluster := session.Cluster{ID: "east", Address: strings.TrimPrefix(apiserver.URL, "https://"), Token: "sa-token", CACert: ca}
+	value, err := cluster.Header()
+	if err != nil {
+		t.Fatalf("encode cluster: %v", err)
+	}
+	server.Connect("foo", http.Header{session.CLUSTER_HEADER: []string{value}})
+
+	get := func(path, user string, header ...string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		if user != "" {
+			req.Header.Set(USER_HEADER, user)
+		}
+		req.Header.Set(GROUP_HEADER, "dev, ops")
+		req.Header.Set("Impersonate-User", "system:admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/k8s/clusters/east/api/v1/pods?watch=1", "alice")
+	if code != http.StatusOK || body != "/api/v1/pods?watch=1 Bearer sa-token alice  [dev ops]" {
+		t.Errorf("unexpected response %d %q", code, body)
+	}
+	if code, _ := get("/k8s/clusters/east/api", ""); code != http.StatusUnauthorized {
+		t.Errorf("no user: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := get("/k8s/clusters/west/api", "alice"); code != http.StatusServiceUnavailable {
+		t.Errorf("unknown cluster: expected %d, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	// the user headers of other callers are not trusted, but those of a request forwarded by a peer are
+	_, other, _ := net.ParseCIDR("10.0.3.0/24")
+	p.ClusterCallers = []*net.IPNet{other}
+	if code, _ := get("/k8s/clusters/east/api", "alice"); code != http.StatusForbidden {
+		t.Errorf("untrusted caller: expected %d, got %d", http.StatusForbidden, code)
+	}
+	if code, _ := get("/k8s/clusters/east/api", "alice", FORWARDED_HEADER, "10.0.0.9:8123", PEER_TOKEN_HEADER, "peer-token"); code != http.StatusForbidden {
+		t.Errorf("unknown peer: expected %d, got %d", http.StatusForbidden, code)
+	}
+	if code, body := get("/k8s/clusters/east/api", "alice", FORWARDED_HEADER, "10.0.0.2:8123", PEER_TOKEN_HEADER, "peer-token"); code != http.StatusOK || !strings.Contains(body, " alice ") {
+		t.Errorf("forwarded by a peer: %d %q", code, body)
+	}
+}
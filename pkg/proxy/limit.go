@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"k8s.io/klog"
+)
+
+// CALLER_HEADER keeps the caller of a request forwarded to the owner of a client, whose limits it counts against
+const CALLER_HEADER = "X-Hostmanager-Caller"
+
+// caller returns who the limits of the callers apply to, the address of the caller. A request forwarded by
+// a peer keeps the caller the peer saw.
+func (p *Proxy) caller(req *http.Request) string {
+	if p.forwarded(req) {
+		if caller := req.Header.Get(CALLER_HEADER); caller != "" {
+			return caller
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// acquire takes a stream of the caller through clientKey. When a limit is reached it answers 429 and
+// ok is false.
+func (p *Proxy) acquire(rw http.ResponseWriter, req *http.Request, clientKey string) (release func(), ok bool) {
+	if p.Limiter == nil {
+		return func() {}, true
+	}
+	release, err := p.Limiter.Acquire(clientKey, p.caller(req))
+	if err != nil {
+		klog.Infof("LIMIT %s %s through client[%s] from %s: %s", req.Method, req.URL.Path, clientKey, p.caller(req), err.Error())
+		rw.Header().Set("Retry-After", "1")
+		writeError(rw, http.StatusTooManyRequests, err)
+		return nil, false
+	}
+	return release, true
+}
+
+// dialer dials through clientKey, the connections are limited to the bandwidth of the client and the caller.
+// The addresses the client does not allow are not dialed, e.g. the location of a redirect.
+func (p *Proxy) dialer(req *http.Request, clientKey string, deadline time.Duration) remotedialer.Dialer {
+	return func(network, address string) (net.Conn, error) {
+		if !p.registry.Allows(clientKey, network, address) {
+			return nil, fmt.Errorf("client %s does not allow %s %s", clientKey, network, address)
+		}
+		conn, err := p.dial(clientKey, deadline, network, address)
+		if err != nil || p.Limiter == nil {
+			return conn, err
+		}
+		return p.Limiter.Conn(clientKey, p.caller(req), conn), nil
+	}
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"hostmanager/pkg/breaker"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// pool balancing, the next member in turn or the one with the fewest outstanding requests
+	ROUND_ROBIN       = "roundrobin"
+	LEAST_OUTSTANDING = "leastoutstanding"
+)
+
+// Pools are groups of tunnel clients, selected by label. A pool without a configured selector
+// has the clients with the session.POOL_LABEL label set to its name.
+type Pools struct {
+	sync.Mutex
+	balance   string
+	selectors map[string]labels.Selector
+	// next is the round robin position of every pool
+	next map[string]int
+	// outstanding are the requests in progress through every client
+	outstanding map[string]int
+}
+
+func NewPools(balance string) (*Pools, error) {
+	if balance != ROUND_ROBIN && balance != LEAST_OUTSTANDING {
+		return nil, fmt.Errorf("unknown pool balance %s", balance)
+	}
+	return &Pools{
+		balance:     balance,
+		selectors:   map[string]labels.Selector{},
+		next:        map[string]int{},
+		outstanding: map[string]int{},
+	}, nil
+}
+
+// Add configures pool name to have the clients matching selector
+func (p *Pools) Add(name string, selector labels.Selector) {
+	p.Lock()
+	defer p.Unlock()
+	p.selectors[name] = selector
+}
+
+func (p *Pools) selector(name string) labels.Selector {
+	p.Lock()
+	defer p.Unlock()
+	if selector, ok := p.selectors[name]; ok {
+		return selector
+	}
+	return labels.SelectorFromSet(labels.Set{session.POOL_LABEL: name})
+}
+
+// order returns the members of pool name in the order to try them
+func (p *Pools) order(name string, members []string) []string {
+	sort.Strings(members)
+	p.Lock()
+	defer p.Unlock()
+	start := p.next[name] % len(members)
+	p.next[name]++
+	ordered := append(members[start:], members[:start]...)
+	if p.balance == LEAST_OUTSTANDING {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return p.outstanding[ordered[i]] < p.outstanding[ordered[j]]
+		})
+	}
+	return ordered
+}
+
+func (p *Pools) acquire(clientKey string) {
+	p.Lock()
+	defer p.Unlock()
+	p.outstanding[clientKey]++
+}
+
+func (p *Pools) release(clientKey string) {
+	p.Lock()
+	defer p.Unlock()
+	if p.outstanding[clientKey]--; p.outstanding[clientKey] <= 0 {
+		delete(p.outstanding, clientKey)
+	}
+}
+
+// Pool serves /pool/{name}/{scheme}/{host}{path} through a member of the pool. The method, body and
+// headers are forwarded. When the dial through a member fails an idempotent request is retried on
+// the next one. Without a member reachable from here, the request goes to a host owning one.
+func (p *Proxy) Pool(rw http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+	selector := p.pools.selector(name)
+
+	members := p.poolMembers(selector)
+	if len(members) == 0 {
+		if !p.forwarded(req) {
+			if remote, ok := p.selectRemote(selector); ok {
+				p.forward(remote.Peer, rw, req, req.URL.RequestURI())
+				return
+			}
+		}
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("pool %s has no member", name))
+		return
+	}
+
+	url, network, address, err := target(vars)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	t, err := p.timeouts(req, Timeouts{})
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	req, cancel := withTimeout(req, t)
+	defer cancel()
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
+
+	// the members not allowing the target are skipped, 403 when none does
+	err = fmt.Errorf("no member of pool %s allows %s %s", name, network, address)
+	code := http.StatusForbidden
+	for _, member := range p.pools.order(name, members) {
+		if !p.registry.Allows(member, network, address) {
+			continue
+		}
+		// a member at its limits or with an open circuit is skipped, 429 or 503 when all of them are
+		done, skip := func(int, error) {}, func() {}
+		if p.Breakers != nil {
+			if done, skip, err = p.Breakers.Allow(member, address); err != nil {
+				code = http.StatusServiceUnavailable
+				continue
+			}
+		}
+		var release func()
+		if p.Limiter != nil {
+			if release, err = p.Limiter.Acquire(member, p.caller(req)); err != nil {
+				// the request did not reach the member, it is no result of its circuits
+				skip()
+				code = http.StatusTooManyRequests
+				continue
+			}
+		}
+		code = http.StatusBadGateway
+		var conn net.Conn
+		if conn, err = p.dialer(req, member, t.Dial)(network, address); err != nil {
+			klog.Errorf("POOL %s dial %s through client[%s] fail:%s", name, address, member, err.Error())
+			done(0, err)
+			if release != nil {
+				release()
+			}
+			if !idempotent(req.Method) {
+				break
+			}
+			continue
+		}
+		klog.Infof("POOL %s %s %s through client[%s]", name, req.Method, url, member)
+		p.pools.acquire(member)
+		done(p.roundTrip(idle(conn, t.Idle), rw, req, url, t))
+		p.pools.release(member)
+		if release != nil {
+			release()
+		}
+		return
+	}
+	switch code {
+	case http.StatusTooManyRequests:
+		rw.Header().Set("Retry-After", "1")
+	case http.StatusServiceUnavailable:
+		if open, ok := err.(*breaker.OpenError); ok {
+			rw.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(open.RetryAfter.Seconds())))
+		}
+	}
+	writeError(rw, code, err)
+}
+
+// poolMembers returns the clients matching selector that can be dialed from here, the degraded ones
+// only when all of them are
+func (p *Proxy) poolMembers(selector labels.Selector) []string {
+	seen := map[string]bool{}
+	var members, degraded []string
+	add := func(clientID string, isDegraded bool) {
+		if !seen[clientID] && p.server.HasSession(clientID) {
+			if isDegraded {
+				degraded = append(degraded, clientID)
+			} else {
+				members = append(members, clientID)
+			}
+		}
+		seen[clientID] = true
+	}
+	for _, s := range p.registry.Select(selector) {
+		add(s.ClientID, s.Degraded())
+	}
+	if p.directory != nil {
+		sessions, err := p.directory.Select(selector)
+		if err != nil {
+			klog.Errorf("select clients %s fail:%s", selector.String(), err.Error())
+		}
+		for _, s := range sessions {
+			add(s.Session.ClientID, s.Session.Health != nil && s.Session.Health.State == session.DEGRADED)
+		}
+	}
+	if len(members) == 0 {
+		return degraded
+	}
+	return members
+}
+
+// roundTrip sends the request for url on conn and copies the response, it returns the status code of the
+// target or the error of the request
+func (p *Proxy) roundTrip(conn net.Conn, rw http.ResponseWriter, req *http.Request, url string, t Timeouts) (int, error) {
+	defer conn.Close()
+	dialed := false
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				if dialed {
+					return nil, fmt.Errorf("connection to %s already used", addr)
+				}
+				dialed = true
+				return conn, nil
+			},
+			ResponseHeaderTimeout: t.ResponseHeader,
+			DisableKeepAlives:     true,
+		},
+		// redirects are for the caller to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	outReq, err := http.NewRequest(req.Method, url, req.Body)
+	if err != nil {
+		// not a response of the target, the circuit must not count it as one
+		writeError(rw, http.StatusBadRequest, err)
+		return http.StatusBadRequest, err
+	}
+	outReq.Header = req.Header.Clone()
+	dropForwarding(outReq.Header)
+	outReq.ContentLength = req.ContentLength
+
+	resp, err := client.Do(outReq.WithContext(req.Context()))
+	if err != nil {
+		klog.Errorf("POOL ERR %s: %v", url, err)
+		writeError(rw, timeoutCode(req, err, http.StatusBadGateway), err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		rw.Header()[key] = values
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"hostmanager/pkg/session"
+	"hostmanager/pkg/session/sessiontest"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestPoolsOrder(t *testing.T) {
+	members := func() []string { return []string{"c", "a", "b"} }
+
+	rr, err := NewPools(ROUND_ROBIN)
+	if err != nil {
+		t.Fatalf("new pools: %v", err)
+	}
+	for _, want := range [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}} {
+		if got := rr.order("web", members()); !reflect.DeepEqual(got, want) {
+			t.Errorf("round robin: expected %v, got %v", want, got)
+		}
+	}
+	// every pool takes its own turns
+	if got := rr.order("db", members()); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("round robin of another pool: got %v", got)
+	}
+
+	lo, err := NewPools(LEAST_OUTSTANDING)
+	if err != nil {
+		t.Fatalf("new pools: %v", err)
+	}
+	lo.acquire("a")
+	lo.acquire("b")
+	lo.acquire("b")
+	if got := lo.order("web", members()); !reflect.DeepEqual(got, []string{"c", "a", "b"}) {
+		t.Errorf("least outstanding: got %v", got)
+	}
+	lo.release("b")
+	lo.release("b")
+	// the ties keep the round robin order
+	if got := lo.order("web", members()); !reflect.DeepEqual(got, []string{"b", "c", "a"}) {
+		t.Errorf("least outstanding after release: got %v", got)
+	}
+
+	if _, err := NewPools("random"); err == nil {
+		t.Errorf("unknown balance: expected an error")
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for method, want := range map[string]bool{
+		http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true, http.MethodTrace: true,
+		http.MethodPut: true, http.MethodDelete: true, http.MethodPost: false, http.MethodPatch: false,
+	} {
+		if got := idempotent(method); got != want {
+			t.Errorf("%s: expected %v, got %v", method, want, got)
+		}
+	}
+}
+
+func TestRoundTripInvalidURL(t *testing.T) {
+	conn, _ := net.Pipe()
+	rw := httptest.NewRecorder()
+	// a request that can not be sent is no healthy response for the circuit of the member
+	code, err := (&Proxy{}).roundTrip(conn, rw, httptest.NewRequest(http.MethodGet, "/", nil), "http://[::1", Timeouts{})
+	if code != http.StatusBadRequest || err == nil || rw.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 and an error, got %d %v, answered %d", code, err, rw.Code)
+	}
+}
+
+func TestPool(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		fmt.Fprintf(rw, "%s %s %s %s", req.Method, req.URL.RequestURI(), req.Header.Get("X-Test"), body)
+	}))
+	defer backend.Close()
+	address := strings.TrimPrefix(backend.URL, "http://")
+
+	pools, err := NewPools(ROUND_ROBIN)
+	if err != nil {
+		t.Fatalf("new pools: %v", err)
+	}
+	pools.Add("site", labels.SelectorFromSet(labels.Set{"site": "berlin"}))
+	server := sessiontest.NewServer(t)
+	p := New(server.Server, server.Registry, nil, pools)
+	router := mux.NewRouter()
+	router.HandleFunc("/pool/{name}/{scheme}/{host}{path:.*}", p.Pool)
+	front := server.Start(router).Front
+
+	// the dials of the clients in failing fail, the others go through the tunnel
+	var lock sync.Mutex
+	var dialed []string
+	failing := map[string]bool{}
+	p.dial = func(clientKey string, deadline time.Duration, proto, address string) (net.Conn, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		dialed = append(dialed, clientKey)
+		if failing[clientKey] {
+			return nil, fmt.Errorf("session of %s lost", clientKey)
+		}
+		return server.Dial(clientKey, deadline, proto, address)
+	}
+	attempts := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts := dialed
+		dialed = nil
+		return attempts
+	}
+
+	server.Connect("a", http.Header{session.LABELS_HEADER: []string{"pool=web,site=berlin"}})
+	server.Connect("b", http.Header{session.LABELS_HEADER: []string{"pool=web"}})
+	server.Connect("c", http.Header{session.LABELS_HEADER: []string{"site=paris"}})
+
+	do := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, front.URL+path, strings.NewReader(body))
+		req.Header.Set("X-Test", "yes")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	// the method, query, headers and body reach the target, the members take turns
+	for _, want := range []string{"a", "b", "a"} {
+		code, body := do(http.MethodPost, "/pool/web/http/"+address+"/echo?x=1", "hello")
+		if code != http.StatusOK || body != "POST /echo?x=1 yes hello" {
+			t.Errorf("pool web: %d %q", code, body)
+		}
+		if got := attempts(); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("pool web: expected a dial through %s, got %v", want, got)
+		}
+	}
+	// a configured selector instead of the pool label
+	if code, _ := do(http.MethodGet, "/pool/site/http/"+address+"/", ""); code != http.StatusOK || !reflect.DeepEqual(attempts(), []string{"a"}) {
+		t.Errorf("pool site: %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/pool/none/http/"+address+"/", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("empty pool: expected %d, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	// an idempotent request is retried on the next member when the dial fails, the others are not
+	lock.Lock()
+	failing["a"] = true
+	lock.Unlock()
+	for i := 0; i < 2; i++ {
+		code, _ := do(http.MethodGet, "/pool/web/http/"+address+"/", "")
+		got := attempts()
+		if code != http.StatusOK || got[len(got)-1] != "b" {
+			t.Errorf("retried GET: %d through %v", code, got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		code, _ := do(http.MethodPost, "/pool/web/http/"+address+"/", "x")
+		got := attempts()
+		if len(got) != 1 || (got[0] == "a") != (code == http.StatusBadGateway) {
+			t.Errorf("POST: %d through %v", code, got)
+		}
+	}
+	lock.Lock()
+	failing["b"] = true
+	lock.Unlock()
+	if code, _ := do(http.MethodGet, "/pool/web/http/"+address+"/", ""); code != http.StatusBadGateway || len(attempts()) != 2 {
+		t.Errorf("all members failing: expected %d", http.StatusBadGateway)
+	}
+}
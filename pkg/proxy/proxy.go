@@ -0,0 +1,365 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/breaker"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	// FORWARDED_HEADER is set on a request forwarded to the owner of a client, which must not forward it again
+	FORWARDED_HEADER = "X-Hostmanager-Forwarded"
+	// PEER_TOKEN_HEADER carries the token of the hostmanager forwarding a request, which authenticates it
+	PEER_TOKEN_HEADER = "X-Hostmanager-Peer-Token"
+	// UNIX_SCHEME sends http requests over a unix socket the client allows, its path is escaped in the host,
+	// e.g. /client/foo/http+unix/%2Fvar%2Frun%2Fdocker.sock/info
+	UNIX_SCHEME = "http+unix"
+)
+
+// Proxy serves requests for tunnel clients through a remotedialer server
+type Proxy struct {
+	server   *remotedialer.Server
+	registry *session.Registry
+	// directory may be nil when the discovery does not support it
+	directory discovery.Directory
+	pools     *Pools
+	forwarder *http.Client
+	// dial reaches address through a client, server.Dial
+	dial func(clientKey string, deadline time.Duration, proto, address string) (net.Conn, error)
+
+	routesLock sync.RWMutex
+	routes     []Route
+
+	// UserHeader and GroupHeader name the caller the cluster API servers impersonate
+	UserHeader  string
+	GroupHeader string
+	// ClusterCallers are the networks of the authenticating proxies trusted to set UserHeader and GroupHeader,
+	// Cluster refuses the other callers, all of them when empty
+	ClusterCallers []*net.IPNet
+	// Limiter limits the streams through every client, unlimited when nil
+	Limiter *limit.Limiter
+	// Breakers fail the requests fast while a client or its target fails, none when nil
+	Breakers *breaker.Breakers
+	// Timeouts are the defaults of the requests, MaxTimeout bounds the ones callers ask for
+	Timeouts   Timeouts
+	MaxTimeout time.Duration
+	// Authenticate checks the id and token of the hostmanager a request was forwarded from. The forwarding
+	// headers of every request are ignored when nil.
+	Authenticate func(peerID, token string) bool
+}
+
+func New(server *remotedialer.Server, registry *session.Registry, directory discovery.Directory, pools *Pools) *Proxy {
+	return &Proxy{
+		server:      server,
+		registry:    registry,
+		directory:   directory,
+		pools:       pools,
+		forwarder:   &http.Client{},
+		dial:        server.Dial,
+		UserHeader:  USER_HEADER,
+		GroupHeader: GROUP_HEADER,
+		Timeouts:    Timeouts{Dial: DIAL_TIMEOUT},
+		MaxTimeout:  MAX_TIMEOUT,
+	}
+}
+
+// Client serves /client/{id}/{scheme}/{host}{path}. A client connected nowhere is refused before its limits
+// and circuits are looked at, they would be kept for any id.
+func (p *Proxy) Client(rw http.ResponseWriter, req *http.Request) {
+	clientKey := mux.Vars(req)["id"]
+	if !p.server.HasSession(clientKey) {
+		if owner, ok := p.owner(clientKey); ok && !p.forwarded(req) {
+			p.forward(owner, rw, req, req.URL.RequestURI())
+			return
+		}
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("client %s is not connected", clientKey))
+		return
+	}
+	p.serve(rw, req, clientKey)
+}
+
+// Select serves /select/{scheme}/{host}{path}?selector=, through any client whose labels match
+// the label selector. Clients connected here are preferred, the others are reached through their owner.
+func (p *Proxy) Select(rw http.ResponseWriter, req *http.Request) {
+	selector, err := parseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	if local := healthy(p.registry.Select(selector)); len(local) > 0 {
+		p.serve(rw, req, local[rand.Intn(len(local))].ClientID)
+		return
+	}
+	remote, ok := p.selectRemote(selector)
+	if !ok {
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("no client matches %s", selector.String()))
+		return
+	}
+	vars := mux.Vars(req)
+	uri := fmt.Sprintf("/client/%s/%s/%s%s?%s", remote.Session.ClientID, vars["scheme"], vars["host"], vars["path"], req.URL.RawQuery)
+	p.forward(remote.Peer, rw, req, uri)
+}
+
+// parseSelector parses a label selector, which must not be empty
+func parseSelector(value string) (labels.Selector, error) {
+	if value == "" {
+		return nil, fmt.Errorf("selector is required")
+	}
+	return labels.Parse(value)
+}
+
+// selectRemote returns a random session matching selector on another host, preferring the best owners
+func (p *Proxy) selectRemote(selector labels.Selector) (discovery.PeerSession, bool) {
+	if p.directory == nil {
+		return discovery.PeerSession{}, false
+	}
+	sessions, err := p.directory.Select(selector)
+	if err != nil {
+		klog.Errorf("select clients %s fail:%s", selector.String(), err.Error())
+		return discovery.PeerSession{}, false
+	}
+	var best []discovery.PeerSession
+	for _, s := range sessions {
+		if s.Peer.ID == p.server.PeerID {
+			continue
+		}
+		if len(best) > 0 && ownerRank(s.Peer) > ownerRank(best[0].Peer) {
+			continue
+		}
+		if len(best) > 0 && ownerRank(s.Peer) < ownerRank(best[0].Peer) {
+			best = best[:0]
+		}
+		best = append(best, s)
+	}
+	if len(best) == 0 {
+		return discovery.PeerSession{}, false
+	}
+	return best[rand.Intn(len(best))], true
+}
+
+// serve sends the request for {scheme}/{host}{path} through the client, the timeout query parameter bounds
+// the request in seconds
+func (p *Proxy) serve(rw http.ResponseWriter, req *http.Request, clientKey string) {
+	t, err := p.timeouts(req, Timeouts{})
+	if t.Request == 0 {
+		t.Request = CLIENT_TIMEOUT
+	}
+	if err == nil && req.URL.Query().Get("timeout") != "" {
+		t.Request, err = p.queryTimeout(req.URL.Query().Get("timeout"))
+	}
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	url, network, address, err := target(mux.Vars(req))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if !p.permit(rw, clientKey, network, address) {
+		return
+	}
+
+	release, ok := p.acquire(rw, req, clientKey)
+	if !ok {
+		return
+	}
+	defer release()
+	done, release, ok := p.allow(rw, req, clientKey, address)
+	if !ok {
+		return
+	}
+	defer release()
+
+	dial := p.dialer(req, clientKey, t.Dial)
+	if network == "unix" {
+		dialer := dial
+		dial = func(string, string) (net.Conn, error) {
+			return dialer(network, address)
+		}
+	}
+	client := &http.Client{Transport: transport(dial, t)}
+	req, cancel := withTimeout(req, t)
+	defer cancel()
+
+	klog.Infof("REQ t=%s %s", t.Request, url)
+
+	// the caller going away cancels the request, which closes the connection through the tunnel
+	outReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	resp, err := client.Do(outReq)
+	if err != nil {
+		done(0, err)
+		klog.Errorf("REQ ERR t=%s %s: %v", t.Request, url, err)
+		writeError(rw, timeoutCode(req, err, http.StatusInternalServerError), err)
+		return
+	}
+	defer resp.Body.Close()
+	done(resp.StatusCode, nil)
+
+	klog.Infof(" REQ OK t=%s %s", t.Request, url)
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+	klog.Infof("REQ DONE t=%s %s", t.Request, url)
+}
+
+// target returns the url of the request for {scheme}/{host}{path}, and the network and address the
+// client dials for it. The UNIX_SCHEME host is the escaped socket path.
+func target(vars map[string]string) (url, network, address string, err error) {
+	scheme, host, path := vars["scheme"], vars["host"], vars["path"]
+	if scheme == UNIX_SCHEME {
+		socket, err := neturl.PathUnescape(host)
+		if err != nil || !strings.HasPrefix(socket, "/") {
+			return "", "", "", fmt.Errorf("invalid unix socket %s", host)
+		}
+		return "http://unix" + path, "unix", socket, nil
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path), "tcp", hostPort(scheme, host), nil
+}
+
+// hostPort returns host with the default port of scheme when it has none
+func hostPort(scheme, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	// an ipv6 address keeps its brackets in a url
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+}
+
+// permit checks that the agents of clientKey allow to dial address with network, a denied dial would end
+// their session. When they do not it answers 403 and returns false.
+func (p *Proxy) permit(rw http.ResponseWriter, clientKey, network, address string) bool {
+	if p.registry.Allows(clientKey, network, address) {
+		return true
+	}
+	klog.Infof("DENY %s %s through client[%s]", network, address, clientKey)
+	writeError(rw, http.StatusForbidden, fmt.Errorf("client %s does not allow %s %s", clientKey, network, address))
+	return false
+}
+
+// owner returns a peer the client is connected to, other than this host
+func (p *Proxy) owner(clientKey string) (discovery.Peer, bool) {
+	if p.directory == nil {
+		return discovery.Peer{}, false
+	}
+	owners, err := p.directory.Owners(clientKey)
+	if err != nil {
+		klog.Errorf("lookup owner of client[%s] fail:%s", clientKey, err.Error())
+		return discovery.Peer{}, false
+	}
+	// prefer Available owners that are not cordoned, a Draining one may already refuse requests.
+	// A cordoned owner still serves its existing sessions, so it is the last resort
+	var best discovery.Peer
+	found := false
+	for _, owner := range owners {
+		if owner.ID == p.server.PeerID {
+			continue
+		}
+		if !found || ownerRank(owner) < ownerRank(best) {
+			best, found = owner, true
+		}
+	}
+	return best, found
+}
+
+// healthy returns the sessions not degraded, all of them when they all are
+func healthy(sessions []session.Session) []session.Session {
+	var ok []session.Session
+	for _, s := range sessions {
+		if !s.Degraded() {
+			ok = append(ok, s)
+		}
+	}
+	if len(ok) == 0 {
+		return sessions
+	}
+	return ok
+}
+
+func ownerRank(owner discovery.Peer) int {
+	rank := 0
+	if owner.Cordoned {
+		rank += 2
+	}
+	if owner.Status != hostv1.Available {
+		rank++
+	}
+	return rank
+}
+
+// forward sends the request for uri to the owner of the client
+func (p *Proxy) forward(owner discovery.Peer, rw http.ResponseWriter, req *http.Request, uri string) {
+	url := fmt.Sprintf("http://%s%s", owner.ID, uri)
+	klog.Infof("FWD %s", url)
+
+	forwardReq, err := http.NewRequest(req.Method, url, req.Body)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	forwardReq.Header = req.Header.Clone()
+	forwardReq.Header.Set(FORWARDED_HEADER, p.server.PeerID)
+	forwardReq.Header.Set(PEER_TOKEN_HEADER, p.server.PeerToken)
+	forwardReq.Header.Set(CALLER_HEADER, p.caller(req))
+	// the owner routes virtual hosts by the Host header
+	forwardReq.Host = req.Host
+
+	resp, err := p.forwarder.Do(forwardReq.WithContext(req.Context()))
+	if err != nil {
+		klog.Errorf("FWD ERR %s: %v", url, err)
+		writeError(rw, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		rw.Header()[key] = values
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+}
+
+// forwarded returns true if req was forwarded by an authenticated peer. Only then is its caller trusted
+// and is it not forwarded again, anyone can set the headers.
+func (p *Proxy) forwarded(req *http.Request) bool {
+	peerID := req.Header.Get(FORWARDED_HEADER)
+	return peerID != "" && p.Authenticate != nil && p.Authenticate(peerID, req.Header.Get(PEER_TOKEN_HEADER))
+}
+
+// dropForwarding removes the forwarding headers from a request going to a target, the token of the peer
+// must not leak
+func dropForwarding(header http.Header) {
+	header.Del(FORWARDED_HEADER)
+	header.Del(PEER_TOKEN_HEADER)
+	header.Del(CALLER_HEADER)
+}
+
+// writeError writes err with code, remotedialer.DefaultErrorWriter writes the body first and so loses the code
+func writeError(rw http.ResponseWriter, code int, err error) {
+	http.Error(rw, err.Error(), code)
+}
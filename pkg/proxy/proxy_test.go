@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/limit"
+	"hostmanager/pkg/session"
+	"hostmanager/pkg/session/sessiontest"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestClientAllow(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	address := strings.TrimPrefix(backend.URL, "http://")
+
+	server := sessiontest.NewServer(t)
+	p := New(server.Server, server.Registry, nil, nil)
+	p.Limiter = limit.NewLimiter(limit.Limits{}, limit.Limits{})
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/client/{id}/{scheme}/{host}{path:.*}", p.Client)
+	front := server.Start(router).Front
+
+	// the agent enforces the rules it declares, like the ones of pkg/agent
+	rules, err := session.ParseRules([]string{"tcp:" + address})
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	server.ConnectWith("foo", http.Header{session.ALLOW_HEADER: []string{"tcp:" + address}}, rules.Allows)
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(front.URL + path)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, path := range []string{
+		"/client/foo/http/127.0.0.1:1/",
+		"/client/foo/http/" + strings.Split(address, ":")[0] + "/",
+		"/client/foo/http+unix/%2Fvar%2Frun%2Fdocker.sock/info",
+	} {
+		if code, _ := get(path); code != http.StatusForbidden {
+			t.Errorf("%s: expected %d, got %d", path, http.StatusForbidden, code)
+		}
+	}
+	if code, body := get("/client/foo/http/" + address + "/"); code != http.StatusOK || body != "ok" {
+		t.Errorf("allowed target: %d %q", code, body)
+	}
+	// the denied requests never reached the agent, which would have ended the session
+	if !server.HasSession("foo") {
+		t.Errorf("session of foo ended")
+	}
+	// a client connected nowhere gets no limits
+	if code, _ := get("/client/random/http/" + address + "/"); code != http.StatusServiceUnavailable {
+		t.Errorf("unknown client: expected %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if _, _, ok := p.Limiter.Usage("random"); ok {
+		t.Errorf("unknown client has limits")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	for _, test := range []struct {
+		scheme, host, path    string
+		url, network, address string
+		err                   bool
+	}{
+		{scheme: "http", host: "10.0.0.1", path: "/api", url: "http://10.0.0.1/api", network: "tcp", address: "10.0.0.1:80"},
+		{scheme: "https", host: "10.0.0.1", path: "/", url: "https://10.0.0.1/", network: "tcp", address: "10.0.0.1:443"},
+		{scheme: "https", host: "10.0.0.1:8443", path: "", url: "https://10.0.0.1:8443", network: "tcp", address: "10.0.0.1:8443"},
+		{scheme: "http", host: "[fd00::1]", path: "/", url: "http://[fd00::1]/", network: "tcp", address: "[fd00::1]:80"},
+		{scheme: UNIX_SCHEME, host: "%2Fvar%2Frun%2Fdocker.sock", path: "/info", url: "http://unix/info", network: "unix", address: "/var/run/docker.sock"},
+		// a unix socket path must be absolute
+		{scheme: UNIX_SCHEME, host: "docker.sock", path: "/info", err: true},
+		{scheme: UNIX_SCHEME, host: "%zz", path: "/info", err: true},
+	} {
+		url, network, address, err := target(map[string]string{"scheme": test.scheme, "host": test.host, "path": test.path})
+		if (err != nil) != test.err {
+			t.Errorf("%s %s: expected error %v, got %v", test.scheme, test.host, test.err, err)
+			continue
+		}
+		if url != test.url || network != test.network || address != test.address {
+			t.Errorf("%s %s%s: expected %s %s %s, got %s %s %s", test.scheme, test.host, test.path,
+				test.url, test.network, test.address, url, network, address)
+		}
+	}
+}
+
+// owners is a client directory with the owner of every client
+type owners map[string]string
+
+func (o owners) PublishSessions(discovery.Peer, []hostv1.ClientSession) error { return nil }
+
+func (o owners) Owners(clientID string) ([]discovery.Peer, error) {
+	if host, ok := o[clientID]; ok {
+		return []discovery.Peer{discovery.NewPeer(host, "")}, nil
+	}
+	return nil, nil
+}
+
+func (o owners) Sessions(string) ([]discovery.PeerSession, error)        { return nil, nil }
+func (o owners) Select(labels.Selector) ([]discovery.PeerSession, error) { return nil, nil }
+
+func TestForwarded(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	address := strings.TrimPrefix(backend.URL, "http://")
+	authenticate := func(peerID, token string) bool { return peerID == "127.0.0.1:1" && token == "secret" }
+
+	// bar is connected to the owner, which trusts the requests forwarded by the other host
+	owner := sessiontest.NewServer(t)
+	ownerProxy := New(owner.Server, owner.Registry, nil, nil)
+	ownerProxy.Authenticate = authenticate
+	ownerRouter := mux.NewRouter().UseEncodedPath()
+	ownerRouter.HandleFunc("/client/{id}/{scheme}/{host}{path:.*}", ownerProxy.Client)
+	ownerHost := strings.TrimPrefix(owner.Start(ownerRouter).Front.URL, "http://")
+	owner.Connect("bar", nil)
+
+	server := sessiontest.NewServer(t)
+	server.PeerID, server.PeerToken = "127.0.0.1:1", "secret"
+	p := New(server.Server, server.Registry, owners{"bar": ownerHost}, nil)
+	p.Authenticate = authenticate
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/client/{id}/{scheme}/{host}{path:.*}", p.Client)
+	front := server.Start(router).Front
+
+	// a caller claiming the request was forwarded does not stop it from reaching the owner
+	req, _ := http.NewRequest(http.MethodGet, front.URL+"/client/bar/http/"+address+"/", nil)
+	req.Header.Set(FORWARDED_HEADER, "10.0.0.9:8123")
+	req.Header.Set(CALLER_HEADER, "10.0.0.10")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("forged forwarded request: expected 200 ok through the owner, got %d %q", resp.StatusCode, body)
+	}
+
+	for _, test := range []struct {
+		name      string
+		forwarded string
+		token     string
+		want      string
+	}{
+		{name: "direct", want: "192.0.2.1"},
+		{name: "forged", forwarded: "10.0.0.9:8123", token: "guess", want: "192.0.2.1"},
+		{name: "unknown peer with the token", forwarded: "10.0.0.9:8123", token: "secret", want: "192.0.2.1"},
+		{name: "peer", forwarded: "127.0.0.1:1", token: "secret", want: "10.0.0.10"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:40000"
+		req.Header.Set(CALLER_HEADER, "10.0.0.10")
+		if test.forwarded != "" {
+			req.Header.Set(FORWARDED_HEADER, test.forwarded)
+			req.Header.Set(PEER_TOKEN_HEADER, test.token)
+		}
+		if got := p.caller(req); got != test.want {
+			t.Errorf("%s: expected caller %s, got %s", test.name, test.want, got)
+		}
+		if got := New(server.Server, server.Registry, nil, nil).caller(req); got != "192.0.2.1" {
+			t.Errorf("%s: without Authenticate expected the remote address, got %s", test.name, got)
+		}
+	}
+
+	header := http.Header{}
+	for _, key := range []string{FORWARDED_HEADER, PEER_TOKEN_HEADER, CALLER_HEADER} {
+		header.Set(key, "x")
+	}
+	dropForwarding(header)
+	if len(header) != 0 {
+		t.Errorf("forwarding headers left for the target: %v", header)
+	}
+}
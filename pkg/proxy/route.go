@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+// Route serves the requests for Host, any host if empty, whose path starts with the segments of PathPrefix, with
+// Target through ClientID or a client matching Selector
+type Route struct {
+	// Name identifies the route in logs, e.g. the TunnelRoute it comes from
+	Name       string
+	Host       string
+	PathPrefix string
+	ClientID   string
+	Selector   labels.Selector
+	Target     *url.URL
+	// Rewrite replaces PathPrefix in the path when set
+	Rewrite         string
+	RequestHeaders  HeaderRules
+	ResponseHeaders HeaderRules
+	// Timeouts override the ones of the proxy
+	Timeouts Timeouts
+}
+
+// HeaderRules set and remove headers
+type HeaderRules struct {
+	Set    map[string]string
+	Remove []string
+}
+
+func (h HeaderRules) apply(header http.Header) {
+	for _, key := range h.Remove {
+		header.Del(key)
+	}
+	for key, value := range h.Set {
+		header.Set(key, value)
+	}
+}
+
+// SetRoutes replaces the routes, the longest path prefix of a host wins, then the longest of any host
+func (p *Proxy) SetRoutes(routes []Route) {
+	routes = append([]Route(nil), routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if (routes[i].Host == "") != (routes[j].Host == "") {
+			return routes[j].Host == ""
+		}
+		return len(routes[i].PathPrefix) > len(routes[j].PathPrefix)
+	})
+	p.routesLock.Lock()
+	defer p.routesLock.Unlock()
+	p.routes = routes
+}
+
+func (p *Proxy) matchRoute(host, path string) (Route, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	p.routesLock.RLock()
+	defer p.routesLock.RUnlock()
+	for _, r := range p.routes {
+		if (r.Host == "" || strings.EqualFold(r.Host, host)) && HasSegmentPrefix(path, r.PathPrefix) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// HasSegmentPrefix returns true if prefix is path or its leading segments, /app and /app/ match /app and
+// /app/x but not /apple
+func HasSegmentPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// RouteHosts returns the hosts of the routes
+func (p *Proxy) RouteHosts() []string {
+	p.routesLock.RLock()
+	defer p.routesLock.RUnlock()
+	var hosts []string
+	for _, r := range p.routes {
+		if r.Host != "" {
+			hosts = append(hosts, r.Host)
+		}
+	}
+	return hosts
+}
+
+// Reachable returns true if the client is connected to this or another host
+func (p *Proxy) Reachable(clientKey string) bool {
+	if p.server.HasSession(clientKey) {
+		return true
+	}
+	_, ok := p.owner(clientKey)
+	return ok
+}
+
+// Connected returns a tunnel client of the route connected to any host
+func (p *Proxy) Connected(r Route) (string, bool) {
+	if r.ClientID != "" {
+		return r.ClientID, p.Reachable(r.ClientID)
+	}
+	if local := healthy(p.registry.Select(r.Selector)); len(local) > 0 {
+		return local[0].ClientID, true
+	}
+	if remote, ok := p.selectRemote(r.Selector); ok {
+		return remote.Session.ClientID, true
+	}
+	return "", false
+}
+
+// Route serves the requests matching a route, it is the handler of the requests no other route of the
+// router matches. A request whose client is connected to another host goes to that host, which has the
+// same routes.
+func (p *Proxy) Route(rw http.ResponseWriter, req *http.Request) {
+	r, ok := p.matchRoute(req.Host, req.URL.Path)
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	p.ServeRoute(rw, req, r, req.URL.RequestURI())
+}
+
+// ServeRoute serves the request with r, within the limits, circuits and timeouts of the proxy. A request
+// whose client is connected to another host is sent to uri on that host, which serves it with r too.
+func (p *Proxy) ServeRoute(rw http.ResponseWriter, req *http.Request, r Route, uri string) {
+	clientKey := r.ClientID
+	if clientKey == "" {
+		if local := healthy(p.registry.Select(r.Selector)); len(local) > 0 {
+			clientKey = local[rand.Intn(len(local))].ClientID
+		} else if remote, ok := p.selectRemote(r.Selector); ok && !p.forwarded(req) {
+			p.forward(remote.Peer, rw, req, uri)
+			return
+		}
+	} else if !p.server.HasSession(clientKey) && !p.forwarded(req) {
+		if owner, ok := p.owner(clientKey); ok {
+			p.forward(owner, rw, req, uri)
+			return
+		}
+	}
+	if clientKey == "" || !p.server.HasSession(clientKey) {
+		writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("route %s has no connected client", r.Name))
+		return
+	}
+	network, target := "tcp", hostPort(r.Target.Scheme, r.Target.Host)
+	if r.Target.Scheme == "unix" {
+		network, target = "unix", r.Target.Path
+	}
+	if !p.permit(rw, clientKey, network, target) {
+		return
+	}
+	t, err := p.timeouts(req, r.Timeouts)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	release, ok := p.acquire(rw, req, clientKey)
+	if !ok {
+		return
+	}
+	defer release()
+	done, release, ok := p.allow(rw, req, clientKey, target)
+	if !ok {
+		return
+	}
+	defer release()
+	p.serveRoute(rw, req, r, clientKey, t, done)
+}
+
+// serveRoute proxies the request to the target of r through the client
+func (p *Proxy) serveRoute(rw http.ResponseWriter, req *http.Request, r Route, clientKey string, t Timeouts, done func(int, error)) {
+	req, cancel := withTimeout(req, t)
+	defer cancel()
+
+	klog.Infof("ROUTE %s %s %s%s to client[%s] %s", r.Name, req.Method, req.Host, req.URL.Path, clientKey, r.Target)
+	// a unix:///path target is http over the socket path
+	scheme, host, base := r.Target.Scheme, r.Target.Host, r.Target.Path
+	dialer := p.dialer(req, clientKey, t.Dial)
+	dial := dialer
+	if scheme == "unix" {
+		scheme, host, base = "http", "unix", ""
+		dial = func(string, string) (net.Conn, error) {
+			return dialer("unix", r.Target.Path)
+		}
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			reqPath := out.URL.Path
+			if r.Rewrite != "" {
+				reqPath = strings.TrimSuffix(r.Rewrite, "/") + strings.TrimPrefix(reqPath, strings.TrimSuffix(r.PathPrefix, "/"))
+				if reqPath == "" {
+					reqPath = "/"
+				}
+			}
+			out.URL.Scheme = scheme
+			out.URL.Host = host
+			out.URL.Path = joinPath(base, reqPath)
+			out.URL.RawPath = ""
+			if r.Target.RawQuery != "" {
+				out.URL.RawQuery = r.Target.RawQuery + "&" + out.URL.RawQuery
+			}
+			out.Host = host
+			out.Header.Set("X-Forwarded-Host", req.Host)
+			dropForwarding(out.Header)
+			r.RequestHeaders.apply(out.Header)
+		},
+		Transport: transport(dial, t),
+		ModifyResponse: func(resp *http.Response) error {
+			done(resp.StatusCode, nil)
+			r.ResponseHeaders.apply(resp.Header)
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			klog.Errorf("ROUTE ERR %s %s%s to client[%s] %s: %v", r.Name, req.Host, req.URL.Path, clientKey, r.Target, err)
+			done(0, err)
+			writeError(rw, timeoutCode(req, err, http.StatusBadGateway), err)
+		},
+	}
+	proxy.ServeHTTP(rw, req)
+}
+
+// joinPath joins the target path and the request path, keeping a trailing slash of the request path
+func joinPath(base, p string) string {
+	if base == "" || base == "/" {
+		return p
+	}
+	joined := path.Join(base, p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	return joined
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import "testing"
+
+func TestMatchRoute(t *testing.T) {
+	p := &Proxy{}
+	p.SetRoutes([]Route{
+		{Name: "root", PathPrefix: "/"},
+		{Name: "app", PathPrefix: "/app"},
+		{Name: "api", PathPrefix: "/api/"},
+		{Name: "host", Host: "example.com", PathPrefix: "/"},
+	})
+	for _, test := range []struct {
+		host, path, want string
+	}{
+		{"other.com", "/app", "app"},
+		{"other.com", "/app/x", "app"},
+		// a prefix matches whole segments
+		{"other.com", "/apple", "root"},
+		{"other.com", "/application/x", "root"},
+		{"other.com", "/api", "api"},
+		{"other.com", "/api/v1", "api"},
+		{"other.com", "/apiary", "root"},
+		{"Example.com:80", "/app", "host"},
+	} {
+		r, ok := p.matchRoute(test.host, test.path)
+		if !ok || r.Name != test.want {
+			t.Errorf("%s%s: expected route %s, got %s %v", test.host, test.path, test.want, r.Name, ok)
+		}
+	}
+}
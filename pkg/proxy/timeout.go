@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/remotedialer"
+)
+
+const (
+	// DIAL_TIMEOUT bounds the dial of the agent by default
+	DIAL_TIMEOUT = 15 * time.Second
+	// CLIENT_TIMEOUT bounds the requests of /client and /select without request timeout, as it always did
+	CLIENT_TIMEOUT = 15 * time.Second
+	// MAX_TIMEOUT bounds the timeouts a caller asks for by default
+	MAX_TIMEOUT = 5 * time.Minute
+
+	// TIMEOUT_HEADER overrides the timeouts of a request, e.g. dial=5s,header=30s,idle=1m,request=2m
+	TIMEOUT_HEADER = "X-Tunnel-Timeout"
+)
+
+// Timeouts of a request through a tunnel client, a zero timeout is none
+type Timeouts struct {
+	// Dial bounds the dial of the target by the agent
+	Dial time.Duration
+	// ResponseHeader bounds the wait for the response headers once the request is sent
+	ResponseHeader time.Duration
+	// Idle bounds the time without bytes read or written on the connection to the target
+	Idle time.Duration
+	// Request bounds the whole request
+	Request time.Duration
+}
+
+// ParseTimeouts parses dial=5s,header=30s,idle=1m,request=2m, the missing timeouts are zero
+func ParseTimeouts(value string) (Timeouts, error) {
+	var t Timeouts
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return Timeouts{}, fmt.Errorf("invalid timeout %s, expected name=duration", item)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d <= 0 {
+			return Timeouts{}, fmt.Errorf("invalid timeout %s, expected a positive duration", item)
+		}
+		switch parts[0] {
+		case "dial":
+			t.Dial = d
+		case "header":
+			t.ResponseHeader = d
+		case "idle":
+			t.Idle = d
+		case "request":
+			t.Request = d
+		default:
+			return Timeouts{}, fmt.Errorf("unknown timeout %s, expected dial, header, idle or request", parts[0])
+		}
+	}
+	return t, nil
+}
+
+// Override returns t with the timeouts set in o
+func (t Timeouts) Override(o Timeouts) Timeouts {
+	if o.Dial > 0 {
+		t.Dial = o.Dial
+	}
+	if o.ResponseHeader > 0 {
+		t.ResponseHeader = o.ResponseHeader
+	}
+	if o.Idle > 0 {
+		t.Idle = o.Idle
+	}
+	if o.Request > 0 {
+		t.Request = o.Request
+	}
+	return t
+}
+
+// timeouts returns the timeouts of req: the ones of the proxy, overridden by the ones of its route, then
+// by the ones of the caller in TIMEOUT_HEADER, which can not be above MaxTimeout
+func (p *Proxy) timeouts(req *http.Request, route Timeouts) (Timeouts, error) {
+	var caller Timeouts
+	if value := req.Header.Get(TIMEOUT_HEADER); value != "" {
+		var err error
+		if caller, err = ParseTimeouts(value); err != nil {
+			return Timeouts{}, err
+		}
+	}
+	for _, d := range []time.Duration{caller.Dial, caller.ResponseHeader, caller.Idle, caller.Request} {
+		if err := p.bounded(d); err != nil {
+			return Timeouts{}, err
+		}
+	}
+	return p.Timeouts.Override(route).Override(caller), nil
+}
+
+// queryTimeout parses the timeout query parameter of /client and /select, in seconds
+func (p *Proxy) queryTimeout(value string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid timeout %s, expected a positive number of seconds", value)
+	}
+	d := time.Duration(seconds) * time.Second
+	return d, p.bounded(d)
+}
+
+func (p *Proxy) bounded(d time.Duration) error {
+	if p.MaxTimeout > 0 && d > p.MaxTimeout {
+		return fmt.Errorf("timeout %s above the maximum %s", d, p.MaxTimeout)
+	}
+	return nil
+}
+
+// withTimeout bounds the context of req by the request timeout, the transport then closes the tunnel
+// connection and the agent the one to the target
+func withTimeout(req *http.Request, t Timeouts) (*http.Request, context.CancelFunc) {
+	if t.Request <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.Request)
+	return req.WithContext(ctx), cancel
+}
+
+// transport sends requests on connections of dial with the timeouts t
+func transport(dial remotedialer.Dialer, t Timeouts) *http.Transport {
+	return &http.Transport{
+		Dial: func(network, address string) (net.Conn, error) {
+			conn, err := dial(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return idle(conn, t.Idle), nil
+		},
+		ResponseHeaderTimeout: t.ResponseHeader,
+		DisableKeepAlives:     true,
+	}
+}
+
+// timeoutCode is 504 when err is a timeout, code otherwise
+func timeoutCode(req *http.Request, err error, code int) int {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	if req.Context().Err() == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout
+	}
+	return code
+}
+
+// idle closes conn after timeout without bytes read or written, remotedialer connections ignore read deadlines
+func idle(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	c := &idleConn{Conn: conn, timeout: timeout}
+	c.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&c.expired, 1)
+		c.Conn.Close()
+	})
+	return c
+}
+
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.active(err)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	return n, c.active(err)
+}
+
+func (c *idleConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// active restarts the idle timer, err of a connection closed for idleness is a timeout
+func (c *idleConn) active(err error) error {
+	if atomic.LoadInt32(&c.expired) == 1 {
+		return idleTimeout{}
+	}
+	c.timer.Reset(c.timeout)
+	return err
+}
+
+type idleTimeout struct{}
+
+func (idleTimeout) Error() string   { return "idle timeout" }
+func (idleTimeout) Timeout() bool   { return true }
+func (idleTimeout) Temporary() bool { return false }
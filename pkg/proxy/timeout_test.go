@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	p := &Proxy{Timeouts: Timeouts{Dial: DIAL_TIMEOUT, Idle: time.Minute}, MaxTimeout: MAX_TIMEOUT}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TIMEOUT_HEADER, "header=10s, request=2m")
+	got, err := p.timeouts(req, Timeouts{Dial: 5 * time.Second, Request: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("timeouts: %v", err)
+	}
+	if want := (Timeouts{Dial: 5 * time.Second, ResponseHeader: 10 * time.Second, Idle: time.Minute, Request: 2 * time.Minute}); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	for _, value := range []string{"request=1h", "dial=0s", "idle=x", "retry=1s", "10s"} {
+		req.Header.Set(TIMEOUT_HEADER, value)
+		if _, err := p.timeouts(req, Timeouts{}); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+	for _, value := range []string{"abc", "-1", "0", "3600"} {
+		if _, err := p.queryTimeout(value); err == nil {
+			t.Errorf("timeout=%s: expected an error", value)
+		}
+	}
+}
+
+func TestIdle(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	conn := idle(client, 50*time.Millisecond)
+	defer conn.Close()
+
+	go server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	start := time.Now()
+	_, err := conn.Read(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected an idle timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("idle timeout after %s", elapsed)
+	}
+}
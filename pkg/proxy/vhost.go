@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// VHOST_PORT_SEPARATOR separates the port in the target label, grafana--3000 is grafana:3000
+const VHOST_PORT_SEPARATOR = "--"
+
+// VirtualHost serves the requests for <target>.<clientid>.<suffix> through the client, the others go to next.
+// The target is a host, port 80 unless it ends with --<port>, reached over http. The path is kept.
+func (p *Proxy) VirtualHost(suffix string, next http.Handler) http.Handler {
+	suffix = "." + strings.Trim(strings.ToLower(suffix), ".")
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		clientKey, target, ok := parseVirtualHost(req.Host, suffix)
+		if !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if target == "" {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid virtual host %s", req.Host))
+			return
+		}
+		if !p.server.HasSession(clientKey) && !p.forwarded(req) {
+			if owner, ok := p.owner(clientKey); ok {
+				p.forward(owner, rw, req, req.URL.RequestURI())
+				return
+			}
+		}
+		if !p.server.HasSession(clientKey) {
+			writeError(rw, http.StatusServiceUnavailable, fmt.Errorf("client %s is not connected", clientKey))
+			return
+		}
+		if !p.permit(rw, clientKey, "tcp", target) {
+			return
+		}
+		t, err := p.timeouts(req, Timeouts{})
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		release, ok := p.acquire(rw, req, clientKey)
+		if !ok {
+			return
+		}
+		defer release()
+		done, release, ok := p.allow(rw, req, clientKey, target)
+		if !ok {
+			return
+		}
+		defer release()
+		p.serveVirtualHost(rw, req, clientKey, target, t, done)
+	})
+}
+
+// parseVirtualHost returns the client and the host:port target of host, ok is false when host is not
+// a virtual host, e.g. api.<suffix> with a single label under suffix, and the target is empty when it is invalid
+func parseVirtualHost(host, suffix string) (clientKey, target string, ok bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, suffix) {
+		return "", "", false
+	}
+	name := strings.TrimSuffix(host, suffix)
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		// a host of hostmanager itself, served by next
+		return "", "", false
+	}
+	if i == 0 || i == len(name)-1 {
+		return "", "", true
+	}
+	target, clientKey = name[:i], name[i+1:]
+
+	port := "80"
+	if j := strings.LastIndex(target, VHOST_PORT_SEPARATOR); j > 0 {
+		if n, err := strconv.Atoi(target[j+len(VHOST_PORT_SEPARATOR):]); err == nil {
+			if n <= 0 || n > 65535 {
+				return clientKey, "", true
+			}
+			target, port = target[:j], strconv.Itoa(n)
+		}
+	}
+	return clientKey, net.JoinHostPort(target, port), true
+}
+
+// serveVirtualHost proxies the request to target through the client. The target gets its own host in
+// the Host header, the virtual host is in X-Forwarded-Host.
+func (p *Proxy) serveVirtualHost(rw http.ResponseWriter, req *http.Request, clientKey, target string, t Timeouts, done func(int, error)) {
+	req, cancel := withTimeout(req, t)
+	defer cancel()
+	klog.Infof("VHOST %s %s%s to client[%s] %s", req.Method, req.Host, req.URL.Path, clientKey, target)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: target})
+	director := proxy.Director
+	proxy.Director = func(out *http.Request) {
+		director(out)
+		out.Header.Set("X-Forwarded-Host", req.Host)
+		dropForwarding(out.Header)
+		out.Host = target
+	}
+	proxy.Transport = transport(p.dialer(req, clientKey, t.Dial), t)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		done(resp.StatusCode, nil)
+		return nil
+	}
+	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
+		klog.Errorf("VHOST ERR %s%s to client[%s] %s: %v", req.Host, req.URL.Path, clientKey, target, err)
+		done(0, err)
+		writeError(rw, timeoutCode(req, err, http.StatusBadGateway), err)
+	}
+	proxy.ServeHTTP(rw, req)
+}
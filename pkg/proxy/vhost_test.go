@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseVirtualHost(t *testing.T) {
+	const suffix = ".hm.example.com"
+	for _, test := range []struct {
+		host      string
+		clientKey string
+		target    string
+		ok        bool
+	}{
+		{host: "grafana.foo.hm.example.com", clientKey: "foo", target: "grafana:80", ok: true},
+		{host: "grafana.foo.hm.example.com:8123", clientKey: "foo", target: "grafana:80", ok: true},
+		{host: "grafana--3000.foo.hm.example.com", clientKey: "foo", target: "grafana:3000", ok: true},
+		// only the last separator is the port, a label that is not a port is kept
+		{host: "my--app--8080.foo.hm.example.com", clientKey: "foo", target: "my--app:8080", ok: true},
+		{host: "my--app.foo.hm.example.com", clientKey: "foo", target: "my--app:80", ok: true},
+		{host: "grafana--0.foo.hm.example.com", clientKey: "foo", target: "", ok: true},
+		{host: "grafana--65536.foo.hm.example.com", clientKey: "foo", target: "", ok: true},
+		// an ip target, and a target with its own domain
+		{host: "10.0.0.5.foo.hm.example.com", clientKey: "foo", target: "10.0.0.5:80", ok: true},
+		{host: "10.0.0.5--8080.foo.hm.example.com", clientKey: "foo", target: "10.0.0.5:8080", ok: true},
+		{host: "db.site.local.foo.hm.example.com", clientKey: "foo", target: "db.site.local:80", ok: true},
+		// hosts are case insensitive
+		{host: "Grafana--3000.FOO.HM.Example.com", clientKey: "foo", target: "grafana:3000", ok: true},
+		{host: ".foo.hm.example.com", target: "", ok: true},
+		// a single label under the suffix is a host of hostmanager itself
+		{host: "api.hm.example.com", ok: false},
+		{host: "hm.example.com", ok: false},
+		{host: "grafana.foo.example.com", ok: false},
+		{host: "10.0.2.15:8123", ok: false},
+	} {
+		clientKey, target, ok := parseVirtualHost(test.host, suffix)
+		if clientKey != test.clientKey || target != test.target || ok != test.ok {
+			t.Errorf("%s: expected %q %q %v, got %q %q %v", test.host, test.clientKey, test.target, test.ok, clientKey, target, ok)
+		}
+	}
+}
+
+func TestVirtualHostNext(t *testing.T) {
+	p := &Proxy{}
+	handler := p.VirtualHost("hm.example.com.", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	for host, want := range map[string]int{
+		"api.hm.example.com":      http.StatusTeapot,
+		"10.0.2.15:8123":          http.StatusTeapot,
+		".foo.hm.example.com":     http.StatusBadRequest,
+		"x--0.foo.hm.example.com": http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/hosts", nil)
+		req.Host = host
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != want {
+			t.Errorf("%s: expected %d, got %d", host, want, rw.Code)
+		}
+	}
+}
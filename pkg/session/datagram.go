@@ -0,0 +1,45 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// MAX_DATAGRAM is the largest datagram a frame carries
+	MAX_DATAGRAM = 65535
+	// TCP_CHECK_PREFIX starts the first frame of a connection to the udp relay of an agent checking a tcp
+	// target instead of relaying udp, e.g. tcp:10.0.0.2:22. The relay dials the target and answers with an
+	// empty frame when connected, the error otherwise.
+	TCP_CHECK_PREFIX = "tcp:"
+)
+
+// WriteDatagram writes b to w in one frame, its length as a big endian uint16 first. remotedialer keeps no
+// datagram boundaries, the frames do.
+func WriteDatagram(w io.Writer, b []byte) error {
+	if len(b) > MAX_DATAGRAM {
+		return fmt.Errorf("datagram of %d bytes larger than %d", len(b), MAX_DATAGRAM)
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadDatagram reads the datagram of a frame written by WriteDatagram into buf, which must be large enough
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n > len(buf) {
+		return 0, fmt.Errorf("datagram of %d bytes larger than the buffer", n)
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,281 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// PING_ADDRESS is dialed by the pings, the agent fails to dial an address without port at once and
+	// answers with the error
+	PING_ADDRESS = "hostmanager-ping"
+
+	// the states of a probed session
+	HEALTHY  = "healthy"
+	DEGRADED = "degraded"
+
+	PROBE_INTERVAL = 10 * time.Second
+	PROBE_TIMEOUT  = 5 * time.Second
+	PROBE_FAILURES = 3
+	// PING_WINDOW is the number of recent pings the loss is measured over
+	PING_WINDOW = 20
+	// PROBE_GRACE is the time the agent gets to report a failed dial of a target after its dial timeout
+	PROBE_GRACE = time.Second
+)
+
+var errProbeTimeout = errors.New("probe timeout")
+
+// ProbeOptions configure the probes of the sessions
+type ProbeOptions struct {
+	Interval time.Duration
+	// Timeout bounds a ping and the dial of a target
+	Timeout time.Duration
+	// Failures in a row of the pings, or of the probes of a target, degrade a session
+	Failures int
+}
+
+// Health is the result of the probes of a session
+type Health struct {
+	sync.Mutex
+	rtt time.Duration
+	// pings are the results of the last PING_WINDOW pings, oldest first
+	pings       []bool
+	failures    int
+	lastSuccess time.Time
+	targets     map[string]*targetHealth
+	degraded    bool
+}
+
+type targetHealth struct {
+	failures    int
+	err         string
+	lastSuccess time.Time
+}
+
+// ping records a ping answered after rtt, or lost with err, and returns true if the session became
+// degraded or healthy again
+func (h *Health) ping(rtt time.Duration, err error, failures int) bool {
+	h.Lock()
+	defer h.Unlock()
+	h.pings = append(h.pings, err == nil)
+	if len(h.pings) > PING_WINDOW {
+		h.pings = h.pings[1:]
+	}
+	if err != nil {
+		h.failures++
+	} else {
+		h.rtt, h.failures, h.lastSuccess = rtt, 0, time.Now()
+	}
+	return h.update(failures)
+}
+
+// probe records the probe of target, failed with err, and returns true if the session became degraded
+// or healthy again
+func (h *Health) probe(target string, err error, failures int) bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.targets == nil {
+		h.targets = map[string]*targetHealth{}
+	}
+	t := h.targets[target]
+	if t == nil {
+		t = &targetHealth{}
+		h.targets[target] = t
+	}
+	if err != nil {
+		t.failures, t.err = t.failures+1, err.Error()
+	} else {
+		t.failures, t.err, t.lastSuccess = 0, "", time.Now()
+	}
+	return h.update(failures)
+}
+
+// update sets degraded with the lock held, true when it changed
+func (h *Health) update(failures int) bool {
+	degraded := h.failures >= failures
+	for _, t := range h.targets {
+		if t.failures >= failures {
+			degraded = true
+		}
+	}
+	changed := degraded != h.degraded
+	h.degraded = degraded
+	return changed
+}
+
+// Degraded returns true when the last pings, or the last probes of a target, failed
+func (h *Health) Degraded() bool {
+	if h == nil {
+		return false
+	}
+	h.Lock()
+	defer h.Unlock()
+	return h.degraded
+}
+
+// Status returns the health as published in the Host status, nil before the first ping
+func (h *Health) Status() *hostv1.ClientHealth {
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	if len(h.pings) == 0 {
+		return nil
+	}
+	status := &hostv1.ClientHealth{
+		State:       HEALTHY,
+		RTT:         metav1.Duration{Duration: h.rtt.Round(time.Microsecond)},
+		LastSuccess: since(h.lastSuccess),
+	}
+	if h.degraded {
+		status.State = DEGRADED
+	}
+	lost := 0
+	for _, ok := range h.pings {
+		if !ok {
+			lost++
+		}
+	}
+	status.Loss = int32(lost * 100 / len(h.pings))
+	for target, t := range h.targets {
+		status.Targets = append(status.Targets, hostv1.TargetHealth{
+			Target:      target,
+			Healthy:     t.failures == 0,
+			Error:       t.err,
+			LastSuccess: since(t.lastSuccess),
+		})
+	}
+	sort.Slice(status.Targets, func(i, j int) bool { return status.Targets[i].Target < status.Targets[j].Target })
+	return status
+}
+
+// since returns t in seconds as the status keeps it, nil if zero
+func since(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := metav1.NewTime(t.Truncate(time.Second))
+	return &mt
+}
+
+// Probe pings the sessions of the agents answering pings every opts.Interval, and probes the targets they
+// declared, until stopCh is closed. A client with several sessions here is dialed through any of them, as
+// remotedialer does for its requests.
+func (r *Registry) Probe(opts ProbeOptions, stopCh <-chan struct{}) {
+	go wait.Until(func() { r.probe(opts) }, opts.Interval, stopCh)
+}
+
+func (r *Registry) probe(opts ProbeOptions) {
+	r.RLock()
+	var sessions []*Session
+	for _, clientSessions := range r.sessions {
+		for _, session := range clientSessions {
+			if session.health != nil {
+				sessions = append(sessions, session)
+			}
+		}
+	}
+	r.RUnlock()
+
+	var changed int32
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *Session) {
+			defer wg.Done()
+			if r.probeSession(session, opts) {
+				atomic.StoreInt32(&changed, 1)
+			}
+		}(session)
+	}
+	wg.Wait()
+	// publish the degraded or recovered sessions at once
+	if changed == 1 {
+		r.notify()
+	}
+}
+
+// probeSession pings session and probes its targets, it returns true if the session became degraded or healthy
+func (r *Registry) probeSession(session *Session, opts ProbeOptions) bool {
+	dial := r.server.Dialer(session.ClientID, opts.Timeout)
+	start := time.Now()
+	err := ping(dial, opts.Timeout)
+	if err != nil {
+		klog.V(2).Infof("ping client[%s] session from %s fail:%s", session.ClientID, session.RemoteAddress, err.Error())
+	}
+	changed := session.health.ping(time.Since(start), err, opts.Failures)
+	for _, target := range session.Probes {
+		err := probeTarget(dial, target, opts.Timeout)
+		if err != nil {
+			klog.V(2).Infof("probe %s through client[%s] fail:%s", target, session.ClientID, err.Error())
+		}
+		if session.health.probe(target, err, opts.Failures) {
+			changed = true
+		}
+	}
+	if changed {
+		state := HEALTHY
+		if session.health.Degraded() {
+			state = DEGRADED
+		}
+		klog.Infof("client[%s] session from %s %s", session.ClientID, session.RemoteAddress, state)
+	}
+	return changed
+}
+
+// ping dials PING_ADDRESS through the agent, its error is the answer
+func ping(dial remotedialer.Dialer, timeout time.Duration) error {
+	conn, err := dial("tcp", PING_ADDRESS)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := await(conn, timeout); err == errProbeTimeout {
+		return fmt.Errorf("ping lost after %s", timeout)
+	}
+	return nil
+}
+
+// probeTarget dials target through the agent, which reports a failed dial within the timeout. The target
+// is up when no error came by then.
+func probeTarget(dial remotedialer.Dialer, target string, timeout time.Duration) error {
+	conn, err := dial("tcp", target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	switch err := await(conn, timeout+PROBE_GRACE); err {
+	case nil, io.EOF, errProbeTimeout:
+		return nil
+	default:
+		return err
+	}
+}
+
+// await waits up to timeout for data or an error on conn, remotedialer connections ignore read deadlines
+func await(conn net.Conn, timeout time.Duration) error {
+	var expired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		conn.Close()
+	})
+	defer timer.Stop()
+	_, err := conn.Read(make([]byte, 1))
+	if atomic.LoadInt32(&expired) == 1 {
+		return errProbeTimeout
+	}
+	return err
+}
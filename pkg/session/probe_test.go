@@ -0,0 +1,52 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	var h *Health
+	if h.Degraded() || h.Status() != nil {
+		t.Fatalf("a session not probed has a health")
+	}
+
+	h = &Health{}
+	lost := errors.New("ping lost")
+	if h.ping(2*time.Millisecond, nil, 2) || h.ping(0, lost, 2) {
+		t.Errorf("degraded after one lost ping")
+	}
+	if !h.ping(0, lost, 2) || !h.Degraded() {
+		t.Errorf("not degraded after two lost pings")
+	}
+	if !h.ping(3*time.Millisecond, nil, 2) || h.Degraded() {
+		t.Errorf("still degraded after an answered ping")
+	}
+
+	h.probe("10.0.0.1:80", nil, 2)
+	h.probe("10.0.0.2:80", errors.New("connection refused"), 2)
+	if !h.probe("10.0.0.2:80", errors.New("connection refused"), 2) {
+		t.Errorf("not degraded after two failed probes of a target")
+	}
+	status := h.Status()
+	if status.State != DEGRADED || status.RTT.Duration != 3*time.Millisecond || status.Loss != 50 || status.LastSuccess == nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if len(status.Targets) != 2 || !status.Targets[0].Healthy || status.Targets[1].Healthy || status.Targets[1].Error != "connection refused" {
+		t.Errorf("unexpected targets %+v", status.Targets)
+	}
+}
+
+func TestAwait(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	start := time.Now()
+	if err := await(client, 50*time.Millisecond); err != errProbeTimeout {
+		t.Errorf("expected a probe timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout after %s", elapsed)
+	}
+}
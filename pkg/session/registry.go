@@ -0,0 +1,669 @@
+package session
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/limit"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// PUBLISH_PERIOD is how often the sessions are published even if they did not change
+	PUBLISH_PERIOD = 30 * time.Second
+
+	// AGENT_VERSION_HEADER is sent by the agent on /connect
+	AGENT_VERSION_HEADER = "X-Tunnel-Agent-Version"
+	// metadata sent by the agent on /connect, labels are k=v and cidrs comma separated
+	HOSTNAME_HEADER = "X-Tunnel-Hostname"
+	OS_HEADER       = "X-Tunnel-OS"
+	ARCH_HEADER     = "X-Tunnel-Arch"
+	LABELS_HEADER   = "X-Tunnel-Labels"
+	CIDRS_HEADER    = "X-Tunnel-CIDRs"
+	// EXPOSE_HEADER lists, comma separated, the port[/udp]=host:port listeners the agent asks for
+	EXPOSE_HEADER = "X-Tunnel-Expose"
+	// CLUSTER_HEADER carries the base64 encoded json Cluster of an agent running in a kubernetes cluster
+	CLUSTER_HEADER = "X-Tunnel-Cluster"
+	// ALLOW_HEADER lists, comma separated, the [proto:]address rules of the dials the agent allows, hostmanager
+	// refuses the others before dialing as the agent would end the session
+	ALLOW_HEADER = "X-Tunnel-Allow"
+	// LIMITS_HEADER carries the limits the agent declares, e.g. streams=10,rate=5,bandwidth=1048576
+	LIMITS_HEADER = "X-Tunnel-Limits"
+	// PING_HEADER is set by agents answering the pings of hostmanager, dials of PING_ADDRESS
+	PING_HEADER = "X-Tunnel-Ping"
+	// PROBES_HEADER lists, comma separated, the host:port targets hostmanager probes through the agent
+	PROBES_HEADER = "X-Tunnel-Probes"
+	// UDP_RELAY_HEADER carries the tcp host:port of the udp relay of the agent. hostmanager dials it through
+	// the agent for a udp flow, sends the target in the first frame of WriteDatagram and the datagrams after it
+	UDP_RELAY_HEADER = "X-Tunnel-UDP-Relay"
+
+	// POOL_LABEL is the client label naming its pool, for pools without a configured selector
+	POOL_LABEL = "pool"
+	// DRAINING_HEADER is set on the 503 response of /connect while draining, the agent should connect elsewhere
+	DRAINING_HEADER = "X-Tunnel-Draining"
+	// CORDONED_HEADER is set on the 503 response of /connect while cordoned
+	CORDONED_HEADER = "X-Tunnel-Cordoned"
+	// REDIRECT_HEADER lists, comma separated, the connect urls a refused agent can connect to instead
+	REDIRECT_HEADER = "X-Tunnel-Redirect"
+)
+
+// Admission decides if new client sessions are accepted
+type Admission interface {
+	// Cordoned returns true when new client sessions are refused
+	Cordoned() bool
+	// Alternatives returns the connect urls of the hostmanagers to connect to instead
+	Alternatives() []string
+}
+
+// Session is a tunnel client connected to this hostmanager
+type Session struct {
+	ClientID      string
+	ConnectedAt   time.Time
+	RemoteAddress string
+	AgentVersion  string
+	Hostname      string
+	OS            string
+	Arch          string
+	Labels        labels.Set
+	CIDRs         []string
+	Expose        []Exposure
+	// Allow are the dials the agent allows
+	Allow Rules
+	// Cluster is set when the agent proxies the API server of its kubernetes cluster
+	Cluster *Cluster
+	// Limits are declared by the agent, hostmanager enforces them when stricter than its own
+	Limits limit.Limits
+	// Probes are the targets the agent asks hostmanager to probe through it
+	Probes []string
+	// UDPRelay is the address of the udp relay of the agent, the udp flows go through it framed
+	UDPRelay string
+
+	conn net.Conn
+	// health is shared by the copies of the session, nil when the agent does not answer pings
+	health *Health
+}
+
+// Cluster is the identity of the kubernetes cluster an agent runs in, and how hostmanager reaches its
+// API server through the agent. The token is kept in memory only, it is not published.
+type Cluster struct {
+	ID string `json:"id"`
+	// Address is the host:port of the API server on the agent network
+	Address string `json:"address"`
+	// Token is the service account token of the agent
+	Token string `json:"token"`
+	// CACert is the PEM CA bundle of the API server
+	CACert []byte `json:"caCert,omitempty"`
+}
+
+// Header encodes c for CLUSTER_HEADER
+func (c Cluster) Header() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func parseCluster(value string) (*Cluster, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	if c.ID == "" || c.Token == "" {
+		return nil, fmt.Errorf("id and token are required")
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return nil, fmt.Errorf("invalid address %s", c.Address)
+	}
+	return c, nil
+}
+
+// Exposure is a port the agent asks hostmanager to listen on, the connections go to Target on its network
+type Exposure struct {
+	Port int
+	// Proto is tcp or udp
+	Proto  string
+	Target string
+}
+
+// ParseExposure parses port[/proto]=host:port or port=unix:///path, the proto is tcp unless it is udp
+func ParseExposure(value string) (Exposure, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return Exposure{}, fmt.Errorf("invalid expose %s, expected port[/udp]=host:port", value)
+	}
+	e := Exposure{Proto: "tcp", Target: parts[1]}
+	port := parts[0]
+	if i := strings.Index(port, "/"); i >= 0 {
+		port, e.Proto = port[:i], strings.ToLower(port[i+1:])
+	}
+	var err error
+	if e.Port, err = strconv.Atoi(port); err != nil || e.Port <= 0 || e.Port > 65535 || (e.Proto != "tcp" && e.Proto != "udp") {
+		return Exposure{}, fmt.Errorf("invalid expose %s, expected port[/udp]=host:port", value)
+	}
+	if _, ok := UnixSocket(e.Target); ok && e.Proto == "tcp" {
+		return e, nil
+	}
+	if _, _, err := net.SplitHostPort(e.Target); err != nil {
+		return Exposure{}, fmt.Errorf("invalid expose %s target: %s", value, err.Error())
+	}
+	return e, nil
+}
+
+// UnixSocket returns the socket path of a unix:///path or unix:/path target
+func UnixSocket(target string) (string, bool) {
+	if !strings.HasPrefix(target, "unix:") {
+		return "", false
+	}
+	socket := "/" + strings.TrimLeft(strings.TrimPrefix(target, "unix:"), "/")
+	return socket, socket != "/"
+}
+
+// String formats e as ParseExposure parses it
+func (e Exposure) String() string {
+	if e.Proto == "udp" {
+		return fmt.Sprintf("%d/udp=%s", e.Port, e.Target)
+	}
+	return fmt.Sprintf("%d=%s", e.Port, e.Target)
+}
+
+// Status returns the session as published in the Host status
+func (s *Session) Status() hostv1.ClientSession {
+	return hostv1.ClientSession{
+		ClientID: s.ClientID,
+		// the status only keeps seconds, so an unchanged session compares equal after a round trip
+		ConnectedAt:   metav1.NewTime(s.ConnectedAt.Truncate(time.Second)),
+		RemoteAddress: s.RemoteAddress,
+		AgentVersion:  s.AgentVersion,
+		Hostname:      s.Hostname,
+		OS:            s.OS,
+		Arch:          s.Arch,
+		Labels:        s.Labels,
+		CIDRs:         s.CIDRs,
+		Cluster:       s.clusterID(),
+		Allow:         s.Allow.Strings(),
+		UDPRelay:      s.UDPRelay,
+		Health:        s.health.Status(),
+	}
+}
+
+// Degraded returns true when the probes of the session fail, a session not probed is not degraded
+func (s *Session) Degraded() bool {
+	return s.health.Degraded()
+}
+
+func (s *Session) clusterID() string {
+	if s.Cluster == nil {
+		return ""
+	}
+	return s.Cluster.ID
+}
+
+// parseMetadata sets the metadata the agent sent on /connect
+func (s *Session) parseMetadata(header http.Header) error {
+	s.AgentVersion = header.Get(AGENT_VERSION_HEADER)
+	s.Hostname = header.Get(HOSTNAME_HEADER)
+	s.OS = header.Get(OS_HEADER)
+	s.Arch = header.Get(ARCH_HEADER)
+	if value := header.Get(LABELS_HEADER); value != "" {
+		set, err := labels.ConvertSelectorToLabelsMap(value)
+		if err != nil {
+			return fmt.Errorf("invalid labels %s: %s", value, err.Error())
+		}
+		s.Labels = set
+	}
+	for _, cidr := range strings.Split(header.Get(CIDRS_HEADER), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %s", cidr)
+		}
+		s.CIDRs = append(s.CIDRs, cidr)
+	}
+	if value := header.Get(CLUSTER_HEADER); value != "" {
+		cluster, err := parseCluster(value)
+		if err != nil {
+			return fmt.Errorf("invalid cluster: %s", err.Error())
+		}
+		s.Cluster = cluster
+	}
+	if value := header.Get(LIMITS_HEADER); value != "" {
+		limits, err := limit.ParseLimits(value)
+		if err != nil {
+			return err
+		}
+		s.Limits = limits
+	}
+	allow, err := ParseRules(strings.Split(header.Get(ALLOW_HEADER), ","))
+	if err != nil {
+		return err
+	}
+	s.Allow = allow
+	if header.Get(PING_HEADER) != "" {
+		s.health = &Health{}
+	}
+	for _, target := range strings.Split(header.Get(PROBES_HEADER), ",") {
+		if target = strings.TrimSpace(target); target == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid probe %s: %s", target, err.Error())
+		}
+		if s.health == nil {
+			return fmt.Errorf("probe %s without %s", target, PING_HEADER)
+		}
+		if !s.Allow.Allows("tcp", target) {
+			return fmt.Errorf("probe %s not allowed by the agent", target)
+		}
+		s.Probes = append(s.Probes, target)
+	}
+	if relay := header.Get(UDP_RELAY_HEADER); relay != "" {
+		if _, _, err := net.SplitHostPort(relay); err != nil {
+			return fmt.Errorf("invalid udp relay %s: %s", relay, err.Error())
+		}
+		s.UDPRelay = relay
+	}
+	for _, expose := range strings.Split(header.Get(EXPOSE_HEADER), ",") {
+		if expose = strings.TrimSpace(expose); expose == "" {
+			continue
+		}
+		e, err := ParseExposure(expose)
+		if err != nil {
+			return err
+		}
+		s.Expose = append(s.Expose, e)
+	}
+	return nil
+}
+
+// Registry serves /connect for a remotedialer server and keeps track of the tunnel client
+// sessions, which remotedialer does not expose.
+type Registry struct {
+	sync.RWMutex
+	server    *remotedialer.Server
+	auth      remotedialer.Authorizer
+	admission Admission
+	sessions  map[string][]*Session
+	changed   chan struct{}
+	draining  bool
+	// watchers are called after the sessions changed
+	watchers []func()
+	// Usage returns the use of a client for the published sessions, none when nil
+	Usage func(clientID string) *hostv1.ClientUsage
+	// Remote returns the sessions of a client on the other hosts, for the clients not connected here
+	Remote func(clientID string) []hostv1.ClientSession
+	// ClusterClients binds the kubernetes cluster ids to the only client that may register them, the others
+	// are refused. Without bindings any client may register a cluster id no other client holds.
+	ClusterClients map[string]string
+	// RemoteClusters returns the sessions registering the kubernetes cluster id on the other hosts
+	RemoteClusters func(id string) []hostv1.ClientSession
+}
+
+// NewRegistry returns a Registry for server, auth has to be the authorizer server was created with.
+func NewRegistry(server *remotedialer.Server, auth remotedialer.Authorizer, admission Admission) *Registry {
+	return &Registry{
+		server:    server,
+		auth:      auth,
+		admission: admission,
+		sessions:  map[string][]*Session{},
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// peers are not tunnel clients
+	if req.Header.Get(remotedialer.ID) != "" && req.Header.Get(remotedialer.Token) != "" {
+		r.server.ServeHTTP(rw, req)
+		return
+	}
+
+	clientID, authed, err := r.auth(req)
+	if err != nil || !authed {
+		r.server.ServeHTTP(rw, req)
+		return
+	}
+
+	r.RLock()
+	draining := r.draining
+	r.RUnlock()
+	if draining {
+		klog.Infof("client[%s] refused, draining", clientID)
+		rw.Header().Set(DRAINING_HEADER, "true")
+		r.refuse(rw, "hostmanager is draining")
+		return
+	}
+	if r.admission.Cordoned() {
+		klog.Infof("client[%s] refused, cordoned", clientID)
+		rw.Header().Set(CORDONED_HEADER, "true")
+		r.refuse(rw, "hostmanager is cordoned")
+		return
+	}
+
+	session := &Session{
+		ClientID:      clientID,
+		ConnectedAt:   time.Now(),
+		RemoteAddress: req.RemoteAddr,
+	}
+	if err := session.parseMetadata(req.Header); err != nil {
+		klog.Infof("client[%s] refused, %s", clientID, err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code, err := r.checkCluster(session); err != nil {
+		klog.Infof("client[%s] refused, %s", clientID, err.Error())
+		http.Error(rw, err.Error(), code)
+		return
+	}
+	added := false
+	r.server.ServeHTTP(&hijackWriter{
+		ResponseWriter: rw,
+		hijacked: func(conn net.Conn) {
+			// the websocket upgrade succeeded
+			session.conn = conn
+			if added = r.add(session); !added {
+				conn.Close()
+			}
+		},
+	}, req)
+	if added {
+		r.remove(session)
+	}
+}
+
+// checkCluster refuses the cluster of session when it is bound to another client, or registered by
+// another client here or on another host. The answer is the status code of the refusal.
+func (r *Registry) checkCluster(session *Session) (int, error) {
+	id := session.clusterID()
+	if id == "" {
+		return 0, nil
+	}
+	if r.ClusterClients != nil && r.ClusterClients[id] != session.ClientID {
+		return http.StatusForbidden, fmt.Errorf("cluster %s is not bound to client %s", id, session.ClientID)
+	}
+	r.RLock()
+	holder := r.clusterHolder(id, session.ClientID)
+	r.RUnlock()
+	if holder == "" && r.RemoteClusters != nil {
+		for _, s := range r.RemoteClusters(id) {
+			if s.ClientID != session.ClientID {
+				holder = s.ClientID
+				break
+			}
+		}
+	}
+	if holder != "" {
+		return http.StatusConflict, fmt.Errorf("cluster %s is registered by client %s", id, holder)
+	}
+	return 0, nil
+}
+
+// clusterHolder returns a client other than clientID registering the cluster id here, the lock must be held
+func (r *Registry) clusterHolder(id, clientID string) string {
+	for holder, sessions := range r.sessions {
+		if holder == clientID {
+			continue
+		}
+		for _, s := range sessions {
+			if s.clusterID() == id {
+				return holder
+			}
+		}
+	}
+	return ""
+}
+
+// refuse answers 503 with the hostmanagers the agent can connect to instead
+func (r *Registry) refuse(rw http.ResponseWriter, reason string) {
+	if alternatives := r.admission.Alternatives(); len(alternatives) > 0 {
+		rw.Header().Set(REDIRECT_HEADER, strings.Join(alternatives, ","))
+	}
+	http.Error(rw, reason, http.StatusServiceUnavailable)
+}
+
+// add registers session, unless another client registered its cluster since it was checked
+func (r *Registry) add(session *Session) bool {
+	r.Lock()
+	if id := session.clusterID(); id != "" {
+		if holder := r.clusterHolder(id, session.ClientID); holder != "" {
+			r.Unlock()
+			klog.Infof("client[%s] refused, cluster %s is registered by client %s", session.ClientID, id, holder)
+			return false
+		}
+	}
+	r.sessions[session.ClientID] = append(r.sessions[session.ClientID], session)
+	r.Unlock()
+	klog.Infof("client[%s] connected from %s", session.ClientID, session.RemoteAddress)
+	r.notify()
+	return true
+}
+
+func (r *Registry) remove(session *Session) {
+	r.Lock()
+	var sessions []*Session
+	for _, s := range r.sessions[session.ClientID] {
+		if s != session {
+			sessions = append(sessions, s)
+		}
+	}
+	if len(sessions) == 0 {
+		delete(r.sessions, session.ClientID)
+	} else {
+		r.sessions[session.ClientID] = sessions
+	}
+	r.Unlock()
+	klog.Infof("client[%s] disconnected from %s", session.ClientID, session.RemoteAddress)
+	r.notify()
+}
+
+// Drain refuses new client sessions, the existing ones keep working
+func (r *Registry) Drain() {
+	r.Lock()
+	defer r.Unlock()
+	r.draining = true
+}
+
+// CloseSessions disconnects all clients, which then reconnect to another hostmanager
+func (r *Registry) CloseSessions() {
+	r.RLock()
+	defer r.RUnlock()
+	for _, sessions := range r.sessions {
+		for _, session := range sessions {
+			klog.Infof("close client[%s] session from %s", session.ClientID, session.RemoteAddress)
+			session.conn.Close()
+		}
+	}
+}
+
+func (r *Registry) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+	r.RLock()
+	watchers := r.watchers
+	r.RUnlock()
+	for _, watch := range watchers {
+		watch()
+	}
+}
+
+// Watch calls watch after every change of the sessions, it must not block
+func (r *Registry) Watch(watch func()) {
+	r.Lock()
+	defer r.Unlock()
+	r.watchers = append(r.watchers, watch)
+}
+
+// Clients returns the sorted ids of the connected tunnel clients
+func (r *Registry) Clients() []string {
+	r.RLock()
+	defer r.RUnlock()
+	clients := make([]string, 0, len(r.sessions))
+	for id := range r.sessions {
+		clients = append(clients, id)
+	}
+	sort.Strings(clients)
+	return clients
+}
+
+// Select returns the sessions whose labels match selector, sorted by client id and connect time
+func (r *Registry) Select(selector labels.Selector) []Session {
+	var sessions []Session
+	for _, session := range r.Sessions("") {
+		if selector.Matches(session.Labels) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// Allows returns true if the agents of clientID allow to dial address with proto, all of them as any may
+// be dialed. A client connected elsewhere is checked with the rules its owners publish. Without them only
+// unix sockets are refused, they need to be allowed explicitly.
+func (r *Registry) Allows(clientID, proto, address string) bool {
+	var rules []Rules
+	for _, session := range r.Sessions(clientID) {
+		rules = append(rules, session.Allow)
+	}
+	if len(rules) == 0 && r.Remote != nil {
+		for _, session := range r.Remote(clientID) {
+			allow, err := ParseRules(session.Allow)
+			if err != nil {
+				klog.Errorf("client[%s] published invalid rules:%s", clientID, err.Error())
+				return false
+			}
+			rules = append(rules, allow)
+		}
+	}
+	if len(rules) == 0 {
+		return proto != "unix"
+	}
+	for _, allow := range rules {
+		if !allow.Allows(proto, address) {
+			return false
+		}
+	}
+	return true
+}
+
+// UDPRelay returns the udp relay of the agent of clientID remotedialer dials through, the one of its oldest
+// session here or else of a session on another host. It is empty when the agent has none.
+func (r *Registry) UDPRelay(clientID string) string {
+	if sessions := r.Sessions(clientID); len(sessions) > 0 {
+		return sessions[0].UDPRelay
+	}
+	if r.Remote != nil {
+		for _, session := range r.Remote(clientID) {
+			return session.UDPRelay
+		}
+	}
+	return ""
+}
+
+// Clusters returns the sessions proxying the API server of the kubernetes cluster id
+func (r *Registry) Clusters(id string) []Session {
+	var sessions []Session
+	for _, session := range r.Sessions("") {
+		if session.Cluster != nil && session.Cluster.ID == id {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// Limits returns the stricter of the limits declared by the agents of clientID connected here
+func (r *Registry) Limits(clientID string) limit.Limits {
+	r.RLock()
+	defer r.RUnlock()
+	var limits limit.Limits
+	for _, session := range r.sessions[clientID] {
+		limits = limits.Min(session.Limits)
+	}
+	return limits
+}
+
+// Sessions returns the sessions of clientID, or all sessions when clientID is empty,
+// sorted by client id and connect time
+func (r *Registry) Sessions(clientID string) []Session {
+	r.RLock()
+	var sessions []Session
+	for id, clientSessions := range r.sessions {
+		if clientID != "" && id != clientID {
+			continue
+		}
+		for _, session := range clientSessions {
+			sessions = append(sessions, *session)
+		}
+	}
+	r.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].ClientID != sessions[j].ClientID {
+			return sessions[i].ClientID < sessions[j].ClientID
+		}
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+	return sessions
+}
+
+// Run calls publish with the connected sessions whenever they change, at most once a second,
+// and every PUBLISH_PERIOD, until stopCh is closed. A failed publish is retried.
+func (r *Registry) Run(publish func(sessions []hostv1.ClientSession) error, stopCh <-chan struct{}) {
+	r.notify()
+	go wait.Until(func() {
+		select {
+		case <-stopCh:
+			return
+		case <-r.changed:
+		case <-time.After(PUBLISH_PERIOD):
+		}
+		var sessions []hostv1.ClientSession
+		for _, session := range r.Sessions("") {
+			status := session.Status()
+			if r.Usage != nil {
+				status.Usage = r.Usage(session.ClientID)
+			}
+			sessions = append(sessions, status)
+		}
+		if err := publish(sessions); err != nil {
+			klog.Errorf("publish sessions fail:%s", err.Error())
+			r.notify()
+		}
+	}, time.Second, stopCh)
+}
+
+// hijackWriter reports the connection of a successful websocket upgrade
+type hijackWriter struct {
+	http.ResponseWriter
+	hijacked func(conn net.Conn)
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked(conn)
+	}
+	return conn, rw, err
+}
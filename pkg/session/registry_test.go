@@ -0,0 +1,249 @@
+package session
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/limit"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseMetadata(t *testing.T) {
+	cluster, err := Cluster{ID: "east", Address: "10.96.0.1:443", Token: "sa-token"}.Header()
+	if err != nil {
+		t.Fatalf("encode cluster: %v", err)
+	}
+	for _, test := range []struct {
+		name   string
+		header map[string]string
+		check  func(s *Session) bool
+		err    string
+	}{
+		{
+			name: "metadata",
+			header: map[string]string{
+				AGENT_VERSION_HEADER: "1.2.0", HOSTNAME_HEADER: "edge-1", OS_HEADER: "linux", ARCH_HEADER: "arm64",
+			},
+			check: func(s *Session) bool {
+				return s.AgentVersion == "1.2.0" && s.Hostname == "edge-1" && s.OS == "linux" && s.Arch == "arm64" &&
+					s.Labels == nil && s.CIDRs == nil && s.Cluster == nil && s.health == nil
+			},
+		},
+		{
+			name:   "labels",
+			header: map[string]string{LABELS_HEADER: "site=berlin,zone=a"},
+			check: func(s *Session) bool {
+				return reflect.DeepEqual(s.Labels, labels.Set{"site": "berlin", "zone": "a"})
+			},
+		},
+		{name: "invalid labels", header: map[string]string{LABELS_HEADER: "site"}, err: "invalid labels"},
+		{
+			name:   "cidrs",
+			header: map[string]string{CIDRS_HEADER: "10.0.0.0/8, ,192.168.1.0/24"},
+			check: func(s *Session) bool {
+				return reflect.DeepEqual(s.CIDRs, []string{"10.0.0.0/8", "192.168.1.0/24"})
+			},
+		},
+		{name: "invalid cidr", header: map[string]string{CIDRS_HEADER: "10.0.0.0/8,10.0.0.1"}, err: "invalid cidr 10.0.0.1"},
+		{
+			name:   "cluster",
+			header: map[string]string{CLUSTER_HEADER: cluster},
+			check:  func(s *Session) bool { return s.clusterID() == "east" && s.Cluster.Token == "sa-token" },
+		},
+		{name: "invalid cluster", header: map[string]string{CLUSTER_HEADER: "e30="}, err: "invalid cluster"},
+		{
+			name:   "limits",
+			header: map[string]string{LIMITS_HEADER: "streams=10,rate=5"},
+			check:  func(s *Session) bool { return s.Limits == limit.Limits{Streams: 10, Rate: 5} },
+		},
+		{
+			name:   "allow and probes",
+			header: map[string]string{ALLOW_HEADER: "tcp:10.0.*:443,unix:/var/run/docker.sock", PING_HEADER: "true", PROBES_HEADER: "10.0.0.5:443"},
+			check: func(s *Session) bool {
+				return len(s.Allow) == 2 && s.health != nil && reflect.DeepEqual(s.Probes, []string{"10.0.0.5:443"})
+			},
+		},
+		{name: "invalid allow", header: map[string]string{ALLOW_HEADER: "tcp:"}, err: "invalid rule"},
+		{name: "probe without ping", header: map[string]string{PROBES_HEADER: "10.0.0.5:443"}, err: "without"},
+		{name: "probe not allowed", header: map[string]string{ALLOW_HEADER: "tcp:10.0.*:80", PING_HEADER: "true", PROBES_HEADER: "10.0.0.5:443"}, err: "not allowed"},
+		{
+			name:   "expose",
+			header: map[string]string{EXPOSE_HEADER: "30022=127.0.0.1:22,30053/udp=10.0.0.2:53"},
+			check: func(s *Session) bool {
+				return reflect.DeepEqual(s.Expose, []Exposure{{Port: 30022, Proto: "tcp", Target: "127.0.0.1:22"}, {Port: 30053, Proto: "udp", Target: "10.0.0.2:53"}})
+			},
+		},
+	} {
+		header := http.Header{}
+		for name, value := range test.header {
+			header.Set(name, value)
+		}
+		s := &Session{}
+		err := s.parseMetadata(header)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !test.check(s) {
+			t.Errorf("%s: unexpected session %+v", test.name, s)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	now := time.Now()
+	r := NewRegistry(nil, nil, nil)
+	for _, s := range []*Session{
+		{ClientID: "foo", ConnectedAt: now.Add(time.Second), Labels: labels.Set{"site": "berlin", "zone": "b"}},
+		{ClientID: "foo", ConnectedAt: now, Labels: labels.Set{"site": "berlin", "zone": "a"}},
+		{ClientID: "bar", ConnectedAt: now, Labels: labels.Set{"site": "paris"}},
+		{ClientID: "baz", ConnectedAt: now},
+	} {
+		r.sessions[s.ClientID] = append(r.sessions[s.ClientID], s)
+	}
+
+	for _, test := range []struct {
+		selector string
+		want     []string
+	}{
+		{selector: "site=berlin", want: []string{"foo/a", "foo/b"}},
+		{selector: "site in (berlin,paris)", want: []string{"bar/", "foo/a", "foo/b"}},
+		{selector: "site!=berlin", want: []string{"bar/", "baz/"}},
+		{selector: "zone", want: []string{"foo/a", "foo/b"}},
+		{selector: "site=rome", want: nil},
+	} {
+		selector, err := labels.Parse(test.selector)
+		if err != nil {
+			t.Fatalf("parse %s: %v", test.selector, err)
+		}
+		var got []string
+		for _, s := range r.Select(selector) {
+			got = append(got, s.ClientID+"/"+s.Labels["zone"])
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.selector, test.want, got)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	rules := func(list ...string) Rules {
+		parsed, err := ParseRules(list)
+		if err != nil {
+			t.Fatalf("parse %v: %v", list, err)
+		}
+		return parsed
+	}
+	r := NewRegistry(nil, nil, nil)
+	for _, s := range []*Session{
+		{ClientID: "open"},
+		{ClientID: "web", Allow: rules("tcp:10.0.*:80")},
+		// two agents with the same id, a dial goes to either so both must allow it
+		{ClientID: "pair", Allow: rules("tcp:*:80", "unix:/var/run/docker.sock")},
+		{ClientID: "pair", Allow: rules("tcp:10.0.*:80")},
+	} {
+		r.sessions[s.ClientID] = append(r.sessions[s.ClientID], s)
+	}
+	r.Remote = func(clientID string) []hostv1.ClientSession {
+		switch clientID {
+		case "remote":
+			return []hostv1.ClientSession{{ClientID: "remote", Allow: []string{"unix:/var/run/docker.sock"}}}
+		case "broken":
+			return []hostv1.ClientSession{{ClientID: "broken", Allow: []string{"tcp:"}}}
+		case "web":
+			return []hostv1.ClientSession{{ClientID: "web"}}
+		}
+		return nil
+	}
+
+	for _, test := range []struct {
+		clientID string
+		proto    string
+		address  string
+		want     bool
+	}{
+		{clientID: "open", proto: "tcp", address: "192.168.0.1:22", want: true},
+		{clientID: "open", proto: "unix", address: "/var/run/docker.sock", want: false},
+		{clientID: "web", proto: "tcp", address: "10.0.0.1:80", want: true},
+		// the local sessions win over the published ones
+		{clientID: "web", proto: "tcp", address: "10.1.0.1:80", want: false},
+		{clientID: "pair", proto: "tcp", address: "10.0.0.1:80", want: true},
+		{clientID: "pair", proto: "tcp", address: "10.1.0.1:80", want: false},
+		{clientID: "pair", proto: "unix", address: "/var/run/docker.sock", want: false},
+		{clientID: "remote", proto: "unix", address: "/var/run/docker.sock", want: true},
+		{clientID: "remote", proto: "tcp", address: "10.0.0.1:80", want: false},
+		{clientID: "broken", proto: "tcp", address: "10.0.0.1:80", want: false},
+		// an unknown client gets the default rules, the dial fails on its own
+		{clientID: "unknown", proto: "tcp", address: "10.0.0.1:80", want: true},
+		{clientID: "unknown", proto: "unix", address: "/var/run/docker.sock", want: false},
+	} {
+		if got := r.Allows(test.clientID, test.proto, test.address); got != test.want {
+			t.Errorf("client[%s] allows %s %s: expected %v, got %v", test.clientID, test.proto, test.address, test.want, got)
+		}
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	cluster := func(clientID, id string) *Session {
+		return &Session{ClientID: clientID, Cluster: &Cluster{ID: id}}
+	}
+	for _, test := range []struct {
+		name     string
+		bindings map[string]string
+		local    []*Session
+		remote   []hostv1.ClientSession
+		session  *Session
+		code     int
+	}{
+		{name: "no cluster", local: []*Session{cluster("a", "east")}, session: &Session{ClientID: "b"}},
+		{name: "free", session: cluster("a", "east")},
+		{name: "same client again", local: []*Session{cluster("a", "east")}, remote: []hostv1.ClientSession{{ClientID: "a", Cluster: "east"}}, session: cluster("a", "east")},
+		{name: "held here", local: []*Session{cluster("a", "east")}, session: cluster("b", "east"), code: http.StatusConflict},
+		{name: "held elsewhere", remote: []hostv1.ClientSession{{ClientID: "a", Cluster: "east"}}, session: cluster("b", "east"), code: http.StatusConflict},
+		{name: "other cluster held", local: []*Session{cluster("a", "west")}, session: cluster("b", "east")},
+		{name: "bound", bindings: map[string]string{"east": "a"}, session: cluster("a", "east")},
+		{name: "bound to another", bindings: map[string]string{"east": "a"}, session: cluster("b", "east"), code: http.StatusForbidden},
+		{name: "not bound", bindings: map[string]string{"east": "a"}, session: cluster("a", "west"), code: http.StatusForbidden},
+	} {
+		r := NewRegistry(nil, nil, nil)
+		r.ClusterClients = test.bindings
+		for _, s := range test.local {
+			r.sessions[s.ClientID] = append(r.sessions[s.ClientID], s)
+		}
+		r.RemoteClusters = func(id string) []hostv1.ClientSession {
+			var sessions []hostv1.ClientSession
+			for _, s := range test.remote {
+				if s.Cluster == id {
+					sessions = append(sessions, s)
+				}
+			}
+			return sessions
+		}
+		code, err := r.checkCluster(test.session)
+		if code != test.code || (err != nil) != (test.code != 0) {
+			t.Errorf("%s: expected %d, got %d %v", test.name, test.code, code, err)
+		}
+	}
+
+	// a session checked before another client registered the cluster is not added
+	r := NewRegistry(nil, nil, nil)
+	if !r.add(cluster("a", "east")) {
+		t.Fatalf("first registration of east refused")
+	}
+	if r.add(cluster("b", "east")) {
+		t.Errorf("second client registering east added")
+	}
+	if !r.add(cluster("a", "east")) {
+		t.Errorf("second session of the client of east refused")
+	}
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rule allows dials with Proto, any proto but unix when empty, to the addresses matching Address,
+// a path.Match pattern like 10.0.*:80 or /var/run/docker.sock for unix
+type Rule struct {
+	Proto   string
+	Address string
+}
+
+// ParseRule parses [proto:]address, e.g. tcp:10.0.*:80 or *:443
+func ParseRule(rule string) (Rule, error) {
+	r := Rule{Address: rule}
+	for _, proto := range []string{"tcp", "udp", "unix"} {
+		if strings.HasPrefix(rule, proto+":") {
+			r = Rule{Proto: proto, Address: strings.TrimPrefix(rule, proto+":")}
+			break
+		}
+	}
+	if r.Address == "" {
+		return Rule{}, fmt.Errorf("invalid rule %s: no address", rule)
+	}
+	if _, err := path.Match(r.Address, ""); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule %s: %s", rule, err.Error())
+	}
+	return r, nil
+}
+
+// Allows returns true if the rule allows to dial address with proto
+func (r Rule) Allows(proto, address string) bool {
+	if r.Proto != "" && r.Proto != proto {
+		return false
+	}
+	// unix sockets reach local daemons, only an explicit unix rule allows them
+	if r.Proto == "" && proto == "unix" {
+		return false
+	}
+	ok, _ := path.Match(r.Address, address)
+	return ok
+}
+
+// String formats r as ParseRule parses it
+func (r Rule) String() string {
+	if r.Proto == "" {
+		return r.Address
+	}
+	return r.Proto + ":" + r.Address
+}
+
+// Rules are the dials an agent allows, every dial but unix sockets when empty
+type Rules []Rule
+
+// ParseRules parses the rules of list, skipping empty items
+func ParseRules(list []string) (Rules, error) {
+	var rules Rules
+	for _, rule := range list {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		r, err := ParseRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Allows returns true if one of the rules allows to dial address with proto
+func (rules Rules) Allows(proto, address string) bool {
+	if len(rules) == 0 {
+		return proto != "unix"
+	}
+	for _, rule := range rules {
+		if rule.Allows(proto, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings returns the rules formatted as ParseRule parses them
+func (rules Rules) Strings() []string {
+	var list []string
+	for _, rule := range rules {
+		list = append(list, rule.String())
+	}
+	return list
+}
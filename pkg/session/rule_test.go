@@ -0,0 +1,89 @@
+package session
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	for _, test := range []struct {
+		list []string
+		want Rules
+		err  bool
+	}{
+		{list: nil, want: nil},
+		{list: []string{" ", ""}, want: nil},
+		{list: []string{"tcp:10.0.*:80", " *:443 "}, want: Rules{{Proto: "tcp", Address: "10.0.*:80"}, {Address: "*:443"}}},
+		{list: []string{"unix:/var/run/docker.sock"}, want: Rules{{Proto: "unix", Address: "/var/run/docker.sock"}}},
+		// not a known proto, the whole rule is the address
+		{list: []string{"sctp:10.0.0.1:9"}, want: Rules{{Address: "sctp:10.0.0.1:9"}}},
+		{list: []string{"udp:"}, err: true},
+		{list: []string{"tcp:10.0.0.1:80", "[10.0.0.1"}, err: true},
+	} {
+		got, err := ParseRules(test.list)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected error %v, got %v", test.list, test.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %v, got %v", test.list, test.want, got)
+		}
+		// the rules declared to hostmanager parse back the same
+		if again, err := ParseRules(got.Strings()); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%q: formatted as %q", test.list, got.Strings())
+		}
+	}
+}
+
+func TestRulesAllows(t *testing.T) {
+	for _, test := range []struct {
+		rules   []string
+		proto   string
+		address string
+		want    bool
+	}{
+		// no rules allow every dial but unix sockets
+		{rules: nil, proto: "tcp", address: "10.0.0.1:80", want: true},
+		{rules: nil, proto: "udp", address: "10.0.0.1:53", want: true},
+		{rules: nil, proto: "unix", address: "/var/run/docker.sock", want: false},
+		// a rule without proto never allows unix sockets, even matching them
+		{rules: []string{"*"}, proto: "unix", address: "/var/run/docker.sock", want: false},
+		{rules: []string{"/var/run/*"}, proto: "unix", address: "/var/run/docker.sock", want: false},
+		{rules: []string{"unix:/var/run/*.sock"}, proto: "unix", address: "/var/run/docker.sock", want: true},
+		{rules: []string{"unix:/var/run/*.sock"}, proto: "unix", address: "/var/run/sub/docker.sock", want: false},
+		// a unix rule only allows unix sockets
+		{rules: []string{"unix:/var/run/*.sock"}, proto: "tcp", address: "10.0.0.1:80", want: false},
+		{rules: []string{"tcp:10.0.*:80", "udp:*:53"}, proto: "tcp", address: "10.0.3.4:80", want: true},
+		{rules: []string{"tcp:10.0.*:80", "udp:*:53"}, proto: "udp", address: "10.0.3.4:80", want: false},
+		{rules: []string{"tcp:10.0.*:80", "udp:*:53"}, proto: "udp", address: "10.0.3.4:53", want: true},
+		{rules: []string{"*:443"}, proto: "tcp", address: "10.0.3.4:443", want: true},
+	} {
+		rules, err := ParseRules(test.rules)
+		if err != nil {
+			t.Fatalf("parse %v: %v", test.rules, err)
+		}
+		if got := rules.Allows(test.proto, test.address); got != test.want {
+			t.Errorf("%v allows %s %s: expected %v, got %v", test.rules, test.proto, test.address, test.want, got)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		socket string
+		ok     bool
+	}{
+		{target: "unix:///var/run/docker.sock", socket: "/var/run/docker.sock", ok: true},
+		{target: "unix:/var/run/docker.sock", socket: "/var/run/docker.sock", ok: true},
+		{target: "unix:var/run/docker.sock", socket: "/var/run/docker.sock", ok: true},
+		{target: "unix:", ok: false},
+		{target: "unix:///", ok: false},
+		{target: "127.0.0.1:22", ok: false},
+	} {
+		socket, ok := UnixSocket(test.target)
+		if ok != test.ok || (ok && socket != test.socket) {
+			t.Errorf("%s: expected %q %v, got %q %v", test.target, test.socket, test.ok, socket, ok)
+		}
+	}
+}
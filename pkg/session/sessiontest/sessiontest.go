@@ -0,0 +1,88 @@
+// Package sessiontest runs a remotedialer server with a session Registry and connects agents to it,
+// for the tests of the packages serving requests through tunnel clients.
+package sessiontest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	"hostmanager/pkg/session"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ID_HEADER is the header the test agents send their client id in
+const ID_HEADER = "X-Tunnel-Id"
+
+// Authorize authenticates every agent sending ID_HEADER as that client
+func Authorize(req *http.Request) (string, bool, error) {
+	id := req.Header.Get(ID_HEADER)
+	return id, id != "", nil
+}
+
+// Admission admits every agent
+type Admission struct{}
+
+func (Admission) Cordoned() bool         { return false }
+func (Admission) Alternatives() []string { return nil }
+
+// Server is a remotedialer server and its Registry serving /connect on an httptest server
+type Server struct {
+	*remotedialer.Server
+	Registry *session.Registry
+	// Front serves /connect and everything else with the handler passed to Start
+	Front *httptest.Server
+
+	t *testing.T
+}
+
+// NewServer returns a Server that is not started yet, so the handler of Start can use it
+func NewServer(t *testing.T) *Server {
+	server := remotedialer.New(Authorize, remotedialer.DefaultErrorWriter)
+	return &Server{
+		Server:   server,
+		Registry: session.NewRegistry(server, Authorize, Admission{}),
+		t:        t,
+	}
+}
+
+// Start serves /connect with the Registry and every other path with handler, which may be nil.
+// The server is closed when the test ends.
+func (s *Server) Start(handler http.Handler) *Server {
+	s.Front = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/connect" || handler == nil {
+			s.Registry.ServeHTTP(rw, req)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	s.t.Cleanup(s.Front.Close)
+	return s
+}
+
+// Connect connects an agent as clientID with the session metadata in header, it allows every dial.
+// It returns once the session is registered, the agent is disconnected when the test ends.
+func (s *Server) Connect(clientID string, header http.Header) {
+	s.ConnectWith(clientID, header, func(string, string) bool { return true })
+}
+
+// ConnectWith connects an agent like Connect, the agent only dials the addresses allowed by auth
+func (s *Server) ConnectWith(clientID string, header http.Header, auth remotedialer.ConnectAuthorizer) {
+	s.t.Helper()
+	headers := http.Header{}
+	for name, values := range header {
+		headers[name] = values
+	}
+	headers.Set(ID_HEADER, clientID)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.t.Cleanup(cancel)
+	wsURL := "ws" + strings.TrimPrefix(s.Front.URL, "http") + "/connect"
+	go remotedialer.ClientConnect(ctx, wsURL, headers, nil, auth, nil)
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) { return s.HasSession(clientID), nil }); err != nil {
+		s.t.Fatalf("client %s not connected", clientID)
+	}
+}
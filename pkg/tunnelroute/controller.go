@@ -0,0 +1,211 @@
+package tunnelroute
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	hostinformers "hostmanager/pkg/generated/informers/externalversions"
+	hostlisters "hostmanager/pkg/generated/listers/hostmanager/v1"
+	"hostmanager/pkg/proxy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	RESYNC_PERIOD = 30 * time.Second
+	// STATUS_PERIOD is how often the leader updates whether the clients of the routes are connected
+	STATUS_PERIOD = 10 * time.Second
+)
+
+// Controller serves the TunnelRoutes of all namespaces on the proxy, and the leader, the schedulable
+// host with the lowest id, writes their status
+type Controller struct {
+	hostclientset hostclientset.Interface
+	informer      cache.SharedIndexInformer
+	lister        hostlisters.TunnelRouteLister
+	factory       hostinformers.SharedInformerFactory
+	proxy         *proxy.Proxy
+	hosts         func() []discovery.Peer
+	self          string
+
+	lock sync.Mutex
+	// invalid are the messages of the TunnelRoutes that are not served, by namespace/name
+	invalid map[string]string
+}
+
+func NewController(hostClient hostclientset.Interface, p *proxy.Proxy, hosts func() []discovery.Peer, self string) *Controller {
+	factory := hostinformers.NewSharedInformerFactory(hostClient, RESYNC_PERIOD)
+	informer := factory.Hostmanager().V1().TunnelRoutes()
+	c := &Controller{
+		hostclientset: hostClient,
+		informer:      informer.Informer(),
+		lister:        informer.Lister(),
+		factory:       factory,
+		proxy:         p,
+		hosts:         hosts,
+		self:          self,
+		invalid:       map[string]string{},
+	}
+	// routes are few, every change rebuilds them all
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { c.sync() },
+		UpdateFunc: func(old, new interface{}) {
+			// status updates do not change the routes
+			if old.(*hostv1.TunnelRoute).Generation != new.(*hostv1.TunnelRoute).Generation {
+				c.sync()
+			}
+		},
+		DeleteFunc: func(interface{}) { c.sync() },
+	})
+	return c
+}
+
+// Run starts the informer and the status updates, it does not block
+func (c *Controller) Run(stopCh <-chan struct{}) error {
+	c.factory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, c.informer.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	c.sync()
+	go wait.Until(c.syncStatus, STATUS_PERIOD, stopCh)
+	return nil
+}
+
+// sync sets the routes of the proxy from the TunnelRoutes
+func (c *Controller) sync() {
+	all, err := c.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list tunnel routes fail:%s", err.Error())
+		return
+	}
+	var routes []proxy.Route
+	invalid := map[string]string{}
+	for _, tr := range all {
+		key := tr.Namespace + "/" + tr.Name
+		route, err := toRoute(tr)
+		if err != nil {
+			klog.Errorf("tunnel route:[%s] invalid:%s", key, err.Error())
+			invalid[key] = err.Error()
+			continue
+		}
+		routes = append(routes, route)
+	}
+	c.proxy.SetRoutes(routes)
+	klog.Infof("serve %d tunnel routes", len(routes))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.invalid = invalid
+}
+
+func toRoute(tr *hostv1.TunnelRoute) (proxy.Route, error) {
+	spec := tr.Spec
+	route := proxy.Route{
+		Name:            tr.Namespace + "/" + tr.Name,
+		Host:            spec.Host,
+		PathPrefix:      spec.PathPrefix,
+		ClientID:        spec.ClientID,
+		Rewrite:         spec.Rewrite,
+		RequestHeaders:  proxy.HeaderRules{Set: spec.RequestHeaders.Set, Remove: spec.RequestHeaders.Remove},
+		ResponseHeaders: proxy.HeaderRules{Set: spec.ResponseHeaders.Set, Remove: spec.ResponseHeaders.Remove},
+	}
+	if route.PathPrefix == "" {
+		route.PathPrefix = "/"
+	}
+	if !strings.HasPrefix(route.PathPrefix, "/") {
+		return proxy.Route{}, fmt.Errorf("pathPrefix %s does not start with /", spec.PathPrefix)
+	}
+	if (spec.ClientID == "") == (spec.Selector == "") {
+		return proxy.Route{}, fmt.Errorf("one of clientID and selector is required")
+	}
+	if spec.Selector != "" {
+		selector, err := labels.Parse(spec.Selector)
+		if err != nil {
+			return proxy.Route{}, fmt.Errorf("invalid selector %s: %s", spec.Selector, err.Error())
+		}
+		route.Selector = selector
+	}
+	target, err := url.Parse(spec.Target)
+	if err != nil {
+		return proxy.Route{}, fmt.Errorf("invalid target %s: %s", spec.Target, err.Error())
+	}
+	if target.Scheme == "unix" {
+		if target.Host != "" || !strings.HasPrefix(target.Path, "/") {
+			return proxy.Route{}, fmt.Errorf("target %s is not a unix:///path socket", spec.Target)
+		}
+	} else if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return proxy.Route{}, fmt.Errorf("target %s is not a http(s) url or a unix:///path socket", spec.Target)
+	}
+	route.Target = target
+	for _, timeout := range []struct {
+		name  string
+		value *metav1.Duration
+		d     *time.Duration
+	}{
+		{"timeout", spec.Timeout, &route.Timeouts.Request},
+		{"dialTimeout", spec.DialTimeout, &route.Timeouts.Dial},
+		{"responseHeaderTimeout", spec.ResponseHeaderTimeout, &route.Timeouts.ResponseHeader},
+		{"idleTimeout", spec.IdleTimeout, &route.Timeouts.Idle},
+	} {
+		if timeout.value == nil {
+			continue
+		}
+		if timeout.value.Duration < 0 {
+			return proxy.Route{}, fmt.Errorf("negative %s %s", timeout.name, timeout.value.Duration)
+		}
+		*timeout.d = timeout.value.Duration
+	}
+	return route, nil
+}
+
+// syncStatus updates the status of the TunnelRoutes on the leader
+func (c *Controller) syncStatus() {
+	hosts := c.hosts()
+	if len(hosts) == 0 || hosts[0].ID != c.self {
+		return
+	}
+	all, err := c.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list tunnel routes fail:%s", err.Error())
+		return
+	}
+	for _, tr := range all {
+		status := c.status(tr)
+		if tr.Status == status {
+			continue
+		}
+		tr = tr.DeepCopy()
+		tr.Status = status
+		if _, err := c.hostclientset.HostmanagerV1().TunnelRoutes(tr.Namespace).UpdateStatus(tr); err != nil {
+			klog.Errorf("update tunnel route:[%s/%s] status fail:%s", tr.Namespace, tr.Name, err.Error())
+			continue
+		}
+		klog.Infof("update tunnel route:[%s/%s] connected %t", tr.Namespace, tr.Name, status.Connected)
+	}
+}
+
+func (c *Controller) status(tr *hostv1.TunnelRoute) hostv1.TunnelRouteStatus {
+	c.lock.Lock()
+	message, invalid := c.invalid[tr.Namespace+"/"+tr.Name]
+	c.lock.Unlock()
+	if invalid {
+		return hostv1.TunnelRouteStatus{Message: message}
+	}
+	route, err := toRoute(tr)
+	if err != nil {
+		return hostv1.TunnelRouteStatus{Message: err.Error()}
+	}
+	if clientKey, ok := c.proxy.Connected(route); ok {
+		return hostv1.TunnelRouteStatus{Connected: true, Message: fmt.Sprintf("client %s connected", clientKey)}
+	}
+	return hostv1.TunnelRouteStatus{Message: "no client connected"}
+}
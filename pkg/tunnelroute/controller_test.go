@@ -0,0 +1,97 @@
+package tunnelroute
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	hostfake "hostmanager/pkg/generated/clientset/versioned/fake"
+	"hostmanager/pkg/proxy"
+	"hostmanager/pkg/session/sessiontest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTunnelRoute(name string, spec hostv1.TunnelRouteSpec) *hostv1.TunnelRoute {
+	return &hostv1.TunnelRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func TestTunnelRoute(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Server", "backend")
+		fmt.Fprintf(rw, "%s %s %s %s", req.Method, req.URL.RequestURI(), req.Header.Get("X-Team"), req.Header.Get("Cookie"))
+	}))
+	defer backend.Close()
+
+	server := sessiontest.NewServer(t)
+	p := proxy.New(server.Server, server.Registry, nil, nil)
+	front := server.Start(http.HandlerFunc(p.Route)).Front
+
+	hostClient := hostfake.NewSimpleClientset(
+		newTunnelRoute("app", hostv1.TunnelRouteSpec{
+			PathPrefix:      "/app/",
+			ClientID:        "foo",
+			Target:          backend.URL + "/base",
+			Rewrite:         "/",
+			RequestHeaders:  hostv1.HeaderRules{Set: map[string]string{"X-Team": "ops"}, Remove: []string{"Cookie"}},
+			ResponseHeaders: hostv1.HeaderRules{Remove: []string{"Server"}},
+		}),
+		newTunnelRoute("offline", hostv1.TunnelRouteSpec{PathPrefix: "/offline", ClientID: "bar", Target: backend.URL}),
+		newTunnelRoute("invalid", hostv1.TunnelRouteSpec{PathPrefix: "/invalid", Target: backend.URL}),
+	)
+	self := "10.1.1.1:8123"
+	hosts := func() []discovery.Peer { return []discovery.Peer{discovery.NewPeer(self, "")} }
+	c := NewController(hostClient, p, hosts, self)
+	if err := c.Run(stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+
+	server.Connect("foo", nil)
+
+	req, _ := http.NewRequest(http.MethodPost, front.URL+"/app/v1/items?q=1", strings.NewReader("body"))
+	req.Header.Set("Cookie", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "POST /base/v1/items?q=1 ops " {
+		t.Errorf("unexpected response %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Server") != "" {
+		t.Errorf("response header Server not removed")
+	}
+
+	for path, code := range map[string]int{"/offline": http.StatusServiceUnavailable, "/invalid": http.StatusNotFound, "/none": http.StatusNotFound} {
+		resp, err := http.Get(front.URL + path)
+		if err != nil {
+			t.Fatalf("request %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("%s: expected %d, got %d", path, code, resp.StatusCode)
+		}
+	}
+
+	c.syncStatus()
+	for name, connected := range map[string]bool{"app": true, "offline": false, "invalid": false} {
+		tr, err := hostClient.HostmanagerV1().TunnelRoutes("default").Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get tunnel route %s: %v", name, err)
+		}
+		if tr.Status.Connected != connected || tr.Status.Message == "" {
+			t.Errorf("%s: unexpected status %+v", name, tr.Status)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package tunnelservice
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/forward"
+	hostclientset "hostmanager/pkg/generated/clientset/versioned"
+	hostinformers "hostmanager/pkg/generated/informers/externalversions"
+	hostlisters "hostmanager/pkg/generated/listers/hostmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+const (
+	// RESYNC_PERIOD also updates the Endpoints with the hosts that changed in between
+	RESYNC_PERIOD = 30 * time.Second
+
+	// PORT_NAME is the name of the Service and Endpoints port
+	PORT_NAME = "tunnel"
+	// SERVICE_LABEL marks the Services and Endpoints of a TunnelService
+	SERVICE_LABEL = "hostmanager.crc.com/tunnelservice"
+)
+
+// Controller reconciles the TunnelServices: every hostmanager listens on their allocated port, and
+// the leader, the schedulable host with the lowest id, writes their Services and Endpoints.
+type Controller struct {
+	kubeclientset kubernetes.Interface
+	hostclientset hostclientset.Interface
+	informer      cache.SharedIndexInformer
+	lister        hostlisters.TunnelServiceLister
+	factory       hostinformers.SharedInformerFactory
+	workqueue     workqueue.RateLimitingInterface
+	forwards      *forward.Manager
+	// hosts returns the schedulable hostmanagers, which are the Endpoints addresses
+	hosts func() []discovery.Peer
+	self  string
+	ports forward.PortRange
+}
+
+func NewController(kubeClient kubernetes.Interface, hostClient hostclientset.Interface, forwards *forward.Manager, hosts func() []discovery.Peer, self string, ports forward.PortRange) *Controller {
+	factory := hostinformers.NewSharedInformerFactory(hostClient, RESYNC_PERIOD)
+	informer := factory.Hostmanager().V1().TunnelServices()
+	c := &Controller{
+		kubeclientset: kubeClient,
+		hostclientset: hostClient,
+		informer:      informer.Informer(),
+		lister:        informer.Lister(),
+		factory:       factory,
+		workqueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "TunnelServices"),
+		forwards:      forwards,
+		hosts:         hosts,
+		self:          self,
+		ports:         ports,
+	}
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(old, new interface{}) { c.enqueue(new) },
+		DeleteFunc: c.enqueue,
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// Run starts the workers, it does not block. The workqueue lives until stopCh is closed.
+func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+	c.factory.Start(stopCh)
+	if ok := cache.WaitForCacheSync(stopCh, c.informer.HasSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	go func() {
+		<-stopCh
+		c.workqueue.ShutDown()
+	}()
+	return nil
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(obj)
+
+	if err := c.syncHandler(obj.(string)); err != nil {
+		c.workqueue.AddRateLimited(obj)
+		utilruntime.HandleError(fmt.Errorf("error syncing tunnel service '%s': %s, requeuing", obj, err.Error()))
+		return true
+	}
+	c.workqueue.Forget(obj)
+	return true
+}
+
+// syncHandler allocates the listener port of the TunnelService key, updates the listeners, reports if
+// this host listens and, on the leader, updates the Service and Endpoints. Deleted TunnelServices leave
+// the garbage collector their Service.
+func (c *Controller) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+	defer c.syncListeners()
+
+	ts, err := c.lister.TunnelServices(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	port, err := c.allocate(ts)
+	if err != nil {
+		return err
+	}
+	if ts.Status.ListenerPort != port {
+		ts = ts.DeepCopy()
+		ts.Status.ListenerPort = port
+		// the hosts listen on the new port once they sync the update
+		ts.Status.Hosts = nil
+		if ts, err = c.hostclientset.HostmanagerV1().TunnelServices(namespace).UpdateStatus(ts); err != nil {
+			klog.Errorf("allocate tunnel service:[%s] port %d fail:%s", key, port, err.Error())
+			return err
+		}
+		klog.Infof("allocate tunnel service:[%s] port %d success", key, port)
+	} else {
+		c.syncListeners()
+		if ts, err = c.report(ts); err != nil {
+			return err
+		}
+	}
+
+	if !c.leader() {
+		return nil
+	}
+	if err := c.syncService(ts); err != nil {
+		return err
+	}
+	return c.syncEndpoints(ts)
+}
+
+// allocate returns the listener port of ts. It starts at a port hashed from its key, so every
+// hostmanager allocates the same one, and of two TunnelServices with the same port the one with
+// the lower key keeps it. A TunnelListener takes the port from ts, the ports agents expose lose it
+// to ts but are not allocated.
+func (c *Controller) allocate(ts *hostv1.TunnelService) (int32, error) {
+	key := ts.Namespace + "/" + ts.Name
+	all, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+	proto := strings.ToLower(string(protocol(ts)))
+	listeners, exposed := c.forwards.Ports(forward.CRD, proto), c.forwards.Ports(forward.AGENT, proto)
+	used := map[int32]string{}
+	for _, other := range all {
+		otherKey := other.Namespace + "/" + other.Name
+		if otherKey == key || other.Status.ListenerPort == 0 {
+			continue
+		}
+		if owner, ok := used[other.Status.ListenerPort]; !ok || otherKey < owner {
+			used[other.Status.ListenerPort] = otherKey
+		}
+	}
+	if port := ts.Status.ListenerPort; port != 0 {
+		if _, ok := listeners[int(port)]; !ok {
+			if owner, ok := used[port]; !ok || key < owner {
+				return port, nil
+			}
+		}
+	}
+
+	size := c.ports.Max - c.ports.Min + 1
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	start := int(h.Sum32() % uint32(size))
+	for i := 0; i < size; i++ {
+		port := int32(c.ports.Min + (start+i)%size)
+		_, service := used[port]
+		_, listener := listeners[int(port)]
+		_, agent := exposed[int(port)]
+		if !service && !listener && !agent {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port in %d-%d for tunnel service %s", c.ports.Min, c.ports.Max, key)
+}
+
+// syncListeners listens on the allocated ports of all TunnelServices
+func (c *Controller) syncListeners() {
+	all, err := c.lister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	var specs []forward.Spec
+	for _, ts := range all {
+		if ts.Status.ListenerPort == 0 {
+			continue
+		}
+		specs = append(specs, spec(ts))
+	}
+	c.forwards.Set(forward.SERVICE, specs)
+}
+
+// spec returns the listener of ts
+func spec(ts *hostv1.TunnelService) forward.Spec {
+	return forward.Spec{
+		Port:     int(ts.Status.ListenerPort),
+		Proto:    strings.ToLower(string(protocol(ts))),
+		ClientID: ts.Spec.ClientID,
+		Target:   ts.Spec.Target,
+		Name:     fmt.Sprintf("tunnelservice %s/%s", ts.Namespace, ts.Name),
+	}
+}
+
+// report adds this host to the hosts of ts when its listener is open, and removes it otherwise
+func (c *Controller) report(ts *hostv1.TunnelService) (*hostv1.TunnelService, error) {
+	serving := c.forwards.Serving(spec(ts))
+	if contains(ts.Status.Hosts, c.self) == serving {
+		return ts, nil
+	}
+	key := ts.Namespace + "/" + ts.Name
+	services := c.hostclientset.HostmanagerV1().TunnelServices(ts.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := services.Get(ts.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current.Status.ListenerPort != ts.Status.ListenerPort || contains(current.Status.Hosts, c.self) == serving {
+			ts = current
+			return nil
+		}
+		current = current.DeepCopy()
+		var hosts []string
+		for _, host := range current.Status.Hosts {
+			if host != c.self {
+				hosts = append(hosts, host)
+			}
+		}
+		if serving {
+			hosts = append(hosts, c.self)
+			sort.Strings(hosts)
+		}
+		current.Status.Hosts = hosts
+		ts, err = services.UpdateStatus(current)
+		return err
+	})
+	if err != nil {
+		klog.Errorf("report tunnel service:[%s] listening %t fail:%s", key, serving, err.Error())
+		return nil, err
+	}
+	klog.Infof("report tunnel service:[%s] listening %t success", key, serving)
+	return ts, nil
+}
+
+func contains(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// protocol returns the protocol of the Service of ts
+func protocol(ts *hostv1.TunnelService) corev1.Protocol {
+	if strings.EqualFold(ts.Spec.Protocol, string(corev1.ProtocolUDP)) {
+		return corev1.ProtocolUDP
+	}
+	return corev1.ProtocolTCP
+}
+
+// leader returns true if this is the schedulable hostmanager with the lowest id
+func (c *Controller) leader() bool {
+	hosts := c.hosts()
+	return len(hosts) > 0 && hosts[0].ID == c.self
+}
+
+func (c *Controller) objectMeta(ts *hostv1.TunnelService) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      ts.Name,
+		Namespace: ts.Namespace,
+		Labels:    map[string]string{SERVICE_LABEL: ts.Name},
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(ts, hostv1.SchemeGroupVersion.WithKind("TunnelService")),
+		},
+	}
+}
+
+// syncService creates or updates the selectorless Service of ts
+func (c *Controller) syncService(ts *hostv1.TunnelService) error {
+	ports := []corev1.ServicePort{{
+		Name:       PORT_NAME,
+		Protocol:   protocol(ts),
+		Port:       ts.Spec.Port,
+		TargetPort: intstr.FromInt(int(ts.Status.ListenerPort)),
+	}}
+	services := c.kubeclientset.CoreV1().Services(ts.Namespace)
+	service, err := services.Get(ts.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = services.Create(&corev1.Service{
+			ObjectMeta: c.objectMeta(ts),
+			Spec:       corev1.ServiceSpec{Ports: ports},
+		})
+		if err == nil {
+			klog.Infof("create service:[%s/%s] success", ts.Namespace, ts.Name)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(service, ts) {
+		return fmt.Errorf("service %s/%s is not owned by the tunnel service", ts.Namespace, ts.Name)
+	}
+	if equality.Semantic.DeepEqual(service.Spec.Ports, ports) {
+		return nil
+	}
+	service = service.DeepCopy()
+	service.Spec.Ports = ports
+	_, err = services.Update(service)
+	return err
+}
+
+// syncEndpoints creates or updates the Endpoints of ts with the schedulable hostmanagers that have its
+// listener open
+func (c *Controller) syncEndpoints(ts *hostv1.TunnelService) error {
+	var addresses []corev1.EndpointAddress
+	for _, host := range c.hosts() {
+		if !contains(ts.Status.Hosts, host.ID) {
+			continue
+		}
+		ip, _, err := net.SplitHostPort(host.ID)
+		if err != nil {
+			continue
+		}
+		addresses = append(addresses, corev1.EndpointAddress{IP: ip})
+	}
+	var subsets []corev1.EndpointSubset
+	if len(addresses) > 0 {
+		subsets = []corev1.EndpointSubset{{
+			Addresses: addresses,
+			Ports:     []corev1.EndpointPort{{Name: PORT_NAME, Port: ts.Status.ListenerPort, Protocol: protocol(ts)}},
+		}}
+	}
+
+	endpoints := c.kubeclientset.CoreV1().Endpoints(ts.Namespace)
+	current, err := endpoints.Get(ts.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = endpoints.Create(&corev1.Endpoints{
+			ObjectMeta: c.objectMeta(ts),
+			Subsets:    subsets,
+		})
+		if err == nil {
+			klog.Infof("create endpoints:[%s/%s] success", ts.Namespace, ts.Name)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(current.Subsets, subsets) {
+		return nil
+	}
+	current = current.DeepCopy()
+	current.Subsets = subsets
+	_, err = endpoints.Update(current)
+	if err == nil {
+		klog.Infof("update endpoints:[%s/%s] %d hosts", ts.Namespace, ts.Name, len(addresses))
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package tunnelservice
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/remotedialer"
+	hostv1 "hostmanager/pkg/apis/hostmanager/v1"
+	"hostmanager/pkg/discovery"
+	"hostmanager/pkg/forward"
+	hostfake "hostmanager/pkg/generated/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTunnelService(name string, port int32) *hostv1.TunnelService {
+	return &hostv1.TunnelService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: hostv1.TunnelServiceSpec{
+			ClientID: "foo",
+			Target:   "10.0.0.5:5432",
+			Port:     port,
+		},
+	}
+}
+
+func TestSyncTunnelService(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	kubeClient := kubefake.NewSimpleClientset()
+	hostClient := hostfake.NewSimpleClientset(newTunnelService("db", 5432), newTunnelService("cache", 6379))
+	rserver := remotedialer.New(func(*http.Request) (string, bool, error) { return "", false, nil }, remotedialer.DefaultErrorWriter)
+	forwards := forward.NewManager(rserver)
+	defer forwards.Close()
+	hosts := func() []discovery.Peer {
+		return []discovery.Peer{discovery.NewPeer("10.1.1.1:8123", ""), discovery.NewPeer("10.1.1.2:8123", "")}
+	}
+	ports := forward.PortRange{Min: 39100, Max: 39101}
+
+	c := NewController(kubeClient, hostClient, forwards, hosts, "10.1.1.1:8123", ports)
+	if err := c.Run(1, stopCh); err != nil {
+		t.Fatalf("run controller: %v", err)
+	}
+
+	var endpoints *corev1.Endpoints
+	err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		var err error
+		endpoints, err = kubeClient.CoreV1().Endpoints("default").Get("db", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("endpoints not created")
+	}
+
+	db, err := hostClient.HostmanagerV1().TunnelServices("default").Get("db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get tunnel service: %v", err)
+	}
+	port := db.Status.ListenerPort
+	if !ports.Contains(int(port)) {
+		t.Fatalf("listener port %d not in %v", port, ports)
+	}
+	// only this host has reported the listener open
+	if len(endpoints.Subsets) != 1 || len(endpoints.Subsets[0].Addresses) != 1 || endpoints.Subsets[0].Addresses[0].IP != "10.1.1.1" || endpoints.Subsets[0].Ports[0].Port != port {
+		t.Errorf("unexpected endpoints %+v", endpoints.Subsets)
+	}
+
+	service, err := kubeClient.CoreV1().Services("default").Get("db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("service not created: %v", err)
+	}
+	if service.Spec.Ports[0].Port != 5432 || service.Spec.Ports[0].TargetPort.IntValue() != int(port) {
+		t.Errorf("unexpected service ports %+v", service.Spec.Ports)
+	}
+	if !metav1.IsControlledBy(service, db) {
+		t.Errorf("service not owned by the tunnel service")
+	}
+
+	addresses := func() []string {
+		endpoints, err := kubeClient.CoreV1().Endpoints("default").Get("db", metav1.GetOptions{})
+		if err != nil || len(endpoints.Subsets) == 0 {
+			return nil
+		}
+		var ips []string
+		for _, address := range endpoints.Subsets[0].Addresses {
+			ips = append(ips, address.IP)
+		}
+		return ips
+	}
+	awaitAddresses := func(want ...string) {
+		t.Helper()
+		err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return reflect.DeepEqual(addresses(), want), nil
+		})
+		if err != nil {
+			t.Fatalf("expected the endpoints %v, got %v", want, addresses())
+		}
+	}
+
+	// the other host reports its listener open, a host that is gone is not an endpoint
+	db, err = hostClient.HostmanagerV1().TunnelServices("default").Get("db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get tunnel service: %v", err)
+	}
+	db = db.DeepCopy()
+	db.Status.Hosts = append(db.Status.Hosts, "10.1.1.2:8123", "10.9.9.9:8123")
+	if _, err := hostClient.HostmanagerV1().TunnelServices("default").UpdateStatus(db); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	awaitAddresses("10.1.1.1", "10.1.1.2")
+
+	// the listener of this host is gone
+	forwards.Close()
+	db, _ = hostClient.HostmanagerV1().TunnelServices("default").Get("db", metav1.GetOptions{})
+	db = db.DeepCopy()
+	db.Spec.Target = "10.0.0.6:5432"
+	if _, err := hostClient.HostmanagerV1().TunnelServices("default").Update(db); err != nil {
+		t.Fatalf("update tunnel service: %v", err)
+	}
+	awaitAddresses("10.1.1.2")
+
+	// the other TunnelService gets the other port of the range
+	err = wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		cache, err := hostClient.HostmanagerV1().TunnelServices("default").Get("cache", metav1.GetOptions{})
+		return err == nil && cache.Status.ListenerPort != 0 && cache.Status.ListenerPort != port, nil
+	})
+	if err != nil {
+		t.Errorf("cache not allocated another port")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	rserver := remotedialer.New(func(*http.Request) (string, bool, error) { return "", false, nil }, remotedialer.DefaultErrorWriter)
+	forwards := forward.NewManager(rserver)
+	defer forwards.Close()
+	c := NewController(kubefake.NewSimpleClientset(), hostfake.NewSimpleClientset(), forwards, nil, "10.1.1.1:8123", forward.PortRange{Min: 39110, Max: 39111})
+
+	db := newTunnelService("db", 5432)
+	udp := newTunnelService("dns", 53)
+	udp.Spec.Protocol = "UDP"
+	held := newTunnelService("cache", 6379)
+	held.Status.ListenerPort = 39111
+	for _, ts := range []*hostv1.TunnelService{db, udp, held} {
+		c.informer.GetIndexer().Add(ts)
+	}
+	listener := func(port int, proto string) forward.Spec {
+		return forward.Spec{Port: port, Proto: proto, ClientID: "bar", Target: "10.0.0.1:22", Name: "tunnellistener ssh"}
+	}
+
+	for _, test := range []struct {
+		name      string
+		ts        *hostv1.TunnelService
+		listeners []forward.Spec
+		exposed   []forward.Spec
+		want      int32
+		err       bool
+	}{
+		{name: "free port", ts: db, want: 39110},
+		{name: "taken by a tunnel listener", ts: db, listeners: []forward.Spec{listener(39110, "tcp")}, err: true},
+		{name: "udp listener", ts: db, listeners: []forward.Spec{listener(39110, "udp")}, want: 39110},
+		{name: "udp service", ts: udp, listeners: []forward.Spec{listener(39110, "tcp")}, want: 39110},
+		{name: "exposed by an agent", ts: db, exposed: []forward.Spec{listener(39110, "tcp")}, err: true},
+		// an allocated port is kept when an agent exposes it, a tunnel listener takes it
+		{name: "held exposed", ts: held, exposed: []forward.Spec{listener(39111, "tcp")}, want: 39111},
+		{name: "held taken", ts: held, listeners: []forward.Spec{listener(39111, "tcp")}, want: 39110},
+	} {
+		forwards.Set(forward.CRD, test.listeners)
+		forwards.Set(forward.AGENT, test.exposed)
+		port, err := c.allocate(test.ts)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got port %d", test.name, port)
+			}
+			continue
+		}
+		if err != nil || port != test.want {
+			t.Errorf("%s: expected port %d, got %d %v", test.name, test.want, port, err)
+		}
+	}
+}